@@ -262,6 +262,9 @@ function viewVideo(video) {
   } else {
     thumbnailImg.style.display = 'block';
     thumbnailImg.src = video.thumbnail_url;
+    // Resized renditions, when the server made them
+    const srcset = video.thumbnail_srcset || {};
+    thumbnailImg.srcset = srcset['image/webp'] || srcset['image/jpeg'] || '';
   }
 
   const videoPlayer = document.getElementById('video-player');
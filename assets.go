@@ -1,13 +1,9 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"mime"
 	"os"
-	"os/exec"
-	"path/filepath"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -25,10 +21,6 @@ func (cfg apiConfig) getAssetPath(videoID string, mediaType string) (string, err
 	return fmt.Sprintf("%s%s", videoID, ext), nil
 }
 
-func (cfg apiConfig) getAssetDiskPath(assetPath string) string {
-	return filepath.Join(cfg.assetsRoot, assetPath)
-}
-
 func (cfg apiConfig) mediaTypeToExt(mediaType string) string {
 	return mime.TypeByExtension(mediaType)
 }
@@ -52,43 +44,17 @@ func (cfg apiConfig) getExtensionType(mediaType string) (string, error) {
 	return candidates[0], nil
 }
 
-type FFProbeOutput struct {
-	Streams []struct {
-		Width	int		`json:"width`
-		Height	int		`json:"height`
-		Codec 	string	`json:"codec"`
-	}`json:"streams"`
-}
-
 // Placing this here since we are passing it an asset disk path
 func getVideoAspectRatio(filePath string) (string, error) {
-	// run exec.Command to run ffprobe -v error -print_format json -show_streams PATH_TO_VIDEO
-	cmd := exec.Command("ffprobe",  "-v", "error", "-print_format", "json", "-show_streams", filePath)
-	// write to buffer
-	var b bytes.Buffer
-	cmd.Stdout = &b
-
-	// Run command
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("Failed to run ffprobe: %w", err)
-	}
-
-	// Unmarshal JSON output
-	var output FFProbeOutput
-	if err := json.Unmarshal(b.Bytes(), &output); err != nil {
-		return "", fmt.Errorf("Failed to unmarshal ffprobe out: %w", err)
+	width, height, err := getVideoDimensions(filePath)
+	if err != nil {
+		return "", err
 	}
 
-	if len(output.Streams) > 0 {
-		width := output.Streams[0].Width
-		height := output.Streams[0].Height
-
-		if width > 0 && height > 0 {
-			gcd := GCD(int(width), int(height))
-			aspectRatio := closestAspectRatio(width/gcd,height/gcd)
-			return fmt.Sprintf("%s", aspectRatio), nil
-		}
-	}
+	return aspectRatioForDimensions(width, height), nil
+}
 
-	return "", fmt.Errorf("Could not determine aspect ratio")
-}
\ No newline at end of file
+func aspectRatioForDimensions(width, height int) string {
+	gcd := GCD(int(width), int(height))
+	return closestAspectRatio(width/gcd,height/gcd)
+}
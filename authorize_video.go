@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// pathVideo loads the video named by the videoID path value and checks the
+// principal owns it. It writes the error response itself and returns
+// ok=false when the ID is invalid (400), the video doesn't exist (404) or
+// belongs to someone else (403).
+func (cfg *apiConfig) pathVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, ok := pathVideoID(w, r)
+	if !ok {
+		return database.Video{}, false
+	}
+	return cfg.authorizeVideo(w, r, videoID)
+}
+
+// pathVisibleVideo is pathVideo for reading a video, which anyone may do
+// unless it's private. Someone else's private video gets the same 404 as a
+// video that doesn't exist, so as not to give away that it does.
+func (cfg *apiConfig) pathVisibleVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, ok := pathVideoID(w, r)
+	if !ok {
+		return database.Video{}, false
+	}
+	video, ok := cfg.loadVideo(w, videoID)
+	if !ok {
+		return database.Video{}, false
+	}
+	if video.Visibility == database.VideoVisibilityPrivate && !cfg.isVideoOwner(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// authorizeVideo is pathVideo for a video ID that came from elsewhere in
+// the request.
+func (cfg *apiConfig) authorizeVideo(w http.ResponseWriter, r *http.Request, videoID uuid.UUID) (database.Video, bool) {
+	video, ok := cfg.loadVideo(w, videoID)
+	if !ok {
+		return database.Video{}, false
+	}
+	if !cfg.isVideoOwner(r, video) {
+		respondWithError(w, http.StatusForbidden, "You don't own this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+func pathVideoID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return uuid.Nil, false
+	}
+	return videoID, true
+}
+
+func (cfg *apiConfig) loadVideo(w http.ResponseWriter, videoID uuid.UUID) (database.Video, bool) {
+	video, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestVideoRoutesAuthorizeUniformly(t *testing.T) {
+	cfg, store := newTestConfig(t)
+
+	ownerID, _ := newTestUser(t, cfg, "owner@example.com")
+	_, otherToken := newTestUser(t, cfg, "other@example.com")
+	video, err := store.CreateVideo(database.CreateVideoParams{Title: "t", UserID: ownerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := []struct {
+		method  string
+		scope   string
+		handler http.HandlerFunc
+	}{
+		{http.MethodPost, auth.ScopeVideosWrite, cfg.handlerUploadVideo},
+		{http.MethodPost, auth.ScopeVideosWrite, cfg.handlerUploadThumbnail},
+		{http.MethodDelete, auth.ScopeVideosWrite, cfg.handlerVideoMetaDelete},
+		{http.MethodPut, auth.ScopeVideosWrite, cfg.handlerVideoVisibilityUpdate},
+		{http.MethodGet, auth.ScopeVideosRead, cfg.handlerVideoProcessingGet},
+		{http.MethodGet, auth.ScopeVideosRead, cfg.handlerThumbnailCandidatesGet},
+	}
+	for i, route := range routes {
+		do := func(videoID, token string) int {
+			r := httptest.NewRequest(route.method, "/api/videos/"+videoID, strings.NewReader("{}"))
+			r.SetPathValue("videoID", videoID)
+			if token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			cfg.requireAuth(route.scope, route.handler).ServeHTTP(w, r)
+			return w.Code
+		}
+
+		if code := do(video.ID.String(), ""); code != http.StatusUnauthorized {
+			t.Errorf("route %d without a token: got status %d, want %d", i, code, http.StatusUnauthorized)
+		}
+		if code := do(video.ID.String(), otherToken); code != http.StatusForbidden {
+			t.Errorf("route %d on someone else's video: got status %d, want %d", i, code, http.StatusForbidden)
+		}
+		if code := do(uuid.NewString(), otherToken); code != http.StatusNotFound {
+			t.Errorf("route %d on a missing video: got status %d, want %d", i, code, http.StatusNotFound)
+		}
+		if code := do("nope", otherToken); code != http.StatusBadRequest {
+			t.Errorf("route %d with an invalid ID: got status %d, want %d", i, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestVideoGetHidesPrivateVideos(t *testing.T) {
+	cfg, store := newTestConfig(t)
+
+	ownerID, ownerToken := newTestUser(t, cfg, "owner@example.com")
+	_, otherToken := newTestUser(t, cfg, "other@example.com")
+	video, err := store.CreateVideo(database.CreateVideoParams{Title: "t", UserID: ownerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(videoID, token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/videos/"+videoID, nil)
+		r.SetPathValue("videoID", videoID)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		cfg.optionalAuth(auth.ScopeVideosRead, cfg.handlerVideoGet).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, token := range []string{"", otherToken} {
+		if code := do(uuid.NewString(), token); code != http.StatusNotFound {
+			t.Errorf("a missing video: got status %d, want %d", code, http.StatusNotFound)
+		}
+		if code := do("nope", token); code != http.StatusBadRequest {
+			t.Errorf("an invalid ID: got status %d, want %d", code, http.StatusBadRequest)
+		}
+		if code := do(video.ID.String(), token); code != http.StatusOK {
+			t.Errorf("a public video: got status %d, want %d", code, http.StatusOK)
+		}
+	}
+
+	video.Visibility = database.VideoVisibilityPrivate
+	if err := store.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"", otherToken} {
+		if code := do(video.ID.String(), token); code != http.StatusNotFound {
+			t.Errorf("someone else's private video: got status %d, want %d", code, http.StatusNotFound)
+		}
+	}
+	if code := do(video.ID.String(), ownerToken); code != http.StatusOK {
+		t.Errorf("your own private video: got status %d, want %d", code, http.StatusOK)
+	}
+}
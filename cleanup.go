@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// Names recorded in the blob deletion outbox for cfg.videoStore and
+// cfg.thumbnailStore
+const (
+	blobStoreVideos     = "videos"
+	blobStoreThumbnails = "thumbnails"
+)
+
+const (
+	blobCleanupBatchSize  = 100
+	blobCleanupMaxBackoff = time.Hour
+)
+
+func (cfg *apiConfig) blobStoreByName(name string) (storage.BlobStore, bool) {
+	switch name {
+	case blobStoreVideos:
+		return cfg.videoStore, true
+	case blobStoreThumbnails:
+		return cfg.thumbnailStore, true
+	}
+	return nil, false
+}
+
+// orphanedKey returns the outbox entry for an object a row pointed at in
+// the named store. Legacy URLs from anywhere else are left alone.
+func (cfg *apiConfig) orphanedKey(storeName string, stored *string) []database.CreateBlobDeletionParams {
+	if stored == nil {
+		return nil
+	}
+	key, ok := cfg.objectKey(storeName, *stored)
+	if !ok {
+		return nil
+	}
+	return []database.CreateBlobDeletionParams{{Store: storeName, Key: key}}
+}
+
+// thumbnailObjects lists the outbox entries for a thumbnail and its
+// resized variants, the largest of which is usually the thumbnail itself.
+func (cfg *apiConfig) thumbnailObjects(key *string, variants database.ThumbnailVariants) []database.CreateBlobDeletionParams {
+	orphans := cfg.orphanedKey(blobStoreThumbnails, key)
+	for _, variant := range variants {
+		if key != nil && variant.Key == *key {
+			continue
+		}
+		orphans = append(orphans, cfg.orphanedKey(blobStoreThumbnails, &variant.Key)...)
+	}
+	return orphans
+}
+
+// videoFileOrphans lists the MP4, HLS tree and archived original a video
+// currently points at, for when they are replaced or the video is deleted.
+func (cfg *apiConfig) videoFileOrphans(video database.Video) []database.CreateBlobDeletionParams {
+	orphans := cfg.orphanedKey(blobStoreVideos, video.VideoKey)
+	orphans = append(orphans, cfg.orphanedKey(blobStoreVideos, video.OriginalKey)...)
+	// The whole rendition tree sits in the directory of the master playlist
+	for _, playlist := range cfg.orphanedKey(blobStoreVideos, video.HLSKey) {
+		if dir := path.Dir(playlist.Key); dir != "." {
+			playlist.Key = dir + "/"
+		}
+		orphans = append(orphans, playlist)
+	}
+	return orphans
+}
+
+// thumbnailOrphans lists the thumbnail a video currently points at unless
+// it is one of the video's candidates, which still need it.
+func (cfg *apiConfig) thumbnailOrphans(video database.Video) ([]database.CreateBlobDeletionParams, error) {
+	if video.ThumbnailKey == nil {
+		return nil, nil
+	}
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		if candidate.Key == *video.ThumbnailKey {
+			return nil, nil
+		}
+	}
+	return cfg.thumbnailObjects(video.ThumbnailKey, video.ThumbnailVariants), nil
+}
+
+// videoOrphans lists every stored object that belongs to a video.
+func (cfg *apiConfig) videoOrphans(video database.Video) ([]database.CreateBlobDeletionParams, error) {
+	orphans := cfg.videoFileOrphans(video)
+	orphans = append(orphans, cfg.thumbnailObjects(video.ThumbnailKey, video.ThumbnailVariants)...)
+
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		if video.ThumbnailKey != nil && candidate.Key == *video.ThumbnailKey {
+			continue
+		}
+		orphans = append(orphans, cfg.thumbnailObjects(&candidate.Key, candidate.Variants)...)
+	}
+
+	// Staged objects of direct uploads that were never completed
+	directUploads, err := cfg.db.GetVideoDirectUploads(video.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, upload := range directUploads {
+		if upload.CompletedAt == nil {
+			orphans = append(orphans, database.CreateBlobDeletionParams{Store: blobStoreVideos, Key: upload.ObjectKey})
+		}
+	}
+	return orphans, nil
+}
+
+// wakeBlobCleanup starts a cleanup pass now rather than at the next tick.
+func (cfg *apiConfig) wakeBlobCleanup() {
+	select {
+	case cfg.cleanupWake <- struct{}{}:
+	default:
+	}
+}
+
+// startBlobCleanup works through the blob deletion outbox, retrying with
+// backoff while a store is unavailable.
+func (cfg *apiConfig) startBlobCleanup(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			cfg.runBlobCleanup(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-cfg.cleanupWake:
+			}
+		}
+	}()
+}
+
+func (cfg *apiConfig) runBlobCleanup(ctx context.Context) {
+	deletions, err := cfg.db.GetDueBlobDeletions(time.Now(), blobCleanupBatchSize)
+	if err != nil {
+		log.Printf("Couldn't list pending blob deletions: %v", err)
+		return
+	}
+	for _, deletion := range deletions {
+		err := cfg.deleteBlobs(ctx, deletion.Store, deletion.Key)
+		if err == nil {
+			if err := cfg.db.CompleteBlobDeletion(deletion.ID); err != nil {
+				log.Printf("Couldn't complete blob deletion %s: %v", deletion.ID, err)
+			}
+			continue
+		}
+
+		backoff := time.Duration(1<<min(deletion.Attempts, 10)) * 30 * time.Second
+		backoff = min(backoff, blobCleanupMaxBackoff)
+		log.Printf("Couldn't delete %s/%s (attempt %d), retrying in %s: %v", deletion.Store, deletion.Key, deletion.Attempts+1, backoff, err)
+		if err := cfg.db.RetryBlobDeletion(deletion.ID, err.Error(), time.Now().Add(backoff)); err != nil {
+			log.Printf("Couldn't reschedule blob deletion %s: %v", deletion.ID, err)
+		}
+	}
+}
+
+// deleteBlobs deletes one object, or every object under key when it ends
+// in a slash.
+func (cfg *apiConfig) deleteBlobs(ctx context.Context, storeName, key string) error {
+	store, ok := cfg.blobStoreByName(storeName)
+	if !ok {
+		return fmt.Errorf("unknown blob store %q", storeName)
+	}
+	if !strings.HasSuffix(key, "/") {
+		return cfg.deleteObject(ctx, storeName, key)
+	}
+
+	objects, err := store.List(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := cfg.deleteObject(ctx, storeName, object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteVideo removes a video and queues everything it stored for cleanup.
+// Staging files of unfinished resumable uploads are local, not in a blob
+// store, so they are removed directly, as is the source of a job that is
+// still queued here. Its row goes with the video, which cancels it; a job
+// that is already running finds the video gone and discards its work.
+func (cfg *apiConfig) deleteVideo(video database.Video) error {
+	orphans, err := cfg.videoOrphans(video)
+	if err != nil {
+		return err
+	}
+	tusUploads, err := cfg.db.GetVideoTusUploads(video.ID)
+	if err != nil {
+		return err
+	}
+	job, err := cfg.db.GetLatestProcessingJob(video.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.videos.DeleteVideo(video.ID, orphans...); err != nil {
+		return err
+	}
+	cfg.wakeBlobCleanup()
+
+	for _, upload := range tusUploads {
+		if upload.CompletedAt != nil {
+			continue
+		}
+		if err := os.Remove(upload.StagingPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Couldn't remove staging file %s: %v", upload.StagingPath, err)
+		}
+	}
+	// Sources live in the uploads directory of the instance the upload came in on
+	if job.Status == database.ProcessingStatusQueued && job.InstanceID == cfg.instanceID {
+		if err := os.Remove(job.SourcePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Couldn't remove upload %s: %v", job.SourcePath, err)
+		}
+	}
+	return nil
+}
+
+// replaceVideoFiles points a video at a new MP4, HLS tree and archived
+// original, queueing the previous ones for cleanup. New files always get
+// fresh random keys, so the old ones are never still in use.
+func (cfg *apiConfig) replaceVideoFiles(video database.Video, videoKey string, hlsKey, originalKey *string) (database.Video, error) {
+	orphans := cfg.videoFileOrphans(video)
+	video.VideoKey = &videoKey
+	video.HLSKey = hlsKey
+	video.OriginalKey = originalKey
+	if err := cfg.videos.UpdateVideo(video, orphans...); err != nil {
+		return database.Video{}, err
+	}
+	if len(orphans) > 0 {
+		cfg.wakeBlobCleanup()
+	}
+	return video, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+const gcUsage = "usage: tubely gc [-dry-run] [-grace duration]"
+
+// runGC implements the gc subcommand, which reports or deletes stored
+// objects that no video, thumbnail candidate or pending upload points at.
+func (cfg *apiConfig) runGC(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	dryRun := flags.Bool("dry-run", false, "only report orphaned objects")
+	grace := flags.Duration("grace", cfg.orphanGC.grace, "skip objects modified more recently than this")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 || *grace < 0 {
+		return errors.New(gcUsage)
+	}
+
+	result, err := cfg.collectOrphanedObjects(context.Background(), *grace, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, orphan := range result.Orphans {
+		size += orphan.Size
+		fmt.Printf("%-10s  %-64s  %10d  %s\n", orphan.Store, orphan.Key, orphan.Size, orphan.LastModified.Format(time.RFC3339))
+	}
+	fmt.Printf("found %d orphaned object(s), %d bytes", len(result.Orphans), size)
+	if *dryRun {
+		fmt.Println(" (dry run, nothing deleted)")
+		return nil
+	}
+	fmt.Printf(", deleted %d\n", result.Deleted)
+	if result.Failed > 0 {
+		return fmt.Errorf("couldn't delete %d object(s)", result.Failed)
+	}
+	return nil
+}
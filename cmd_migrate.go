@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const migrateUsage = "usage: tubely migrate [up | down [steps] | to <version> | status]"
+
+// runMigrate implements the migrate subcommand so schema changes can be
+// applied or rolled back without starting the server.
+func runMigrate(dbURL string, args []string) error {
+	db, err := database.OpenClient(dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	var n int
+	switch command {
+	case "up":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+		n, err = db.MigrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+		}
+		n, err = db.MigrateDown(steps)
+	case "to":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		n, err = db.MigrateTo(version)
+	case "status":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+		return printMigrationStatus(db)
+	default:
+		return errors.New(migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ran %d migration(s), schema is at version %d\n", n, version)
+	return nil
+}
+
+func printMigrationStatus(db database.Client) error {
+	states, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, s := range states {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d  %-28s  %s\n", s.Version, s.Name, applied)
+	}
+	return nil
+}
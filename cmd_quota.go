@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const quotaUsage = "usage: tubely quota [-storage size] [-videos n] [-file-size size] email\n" +
+	"sizes look like 500MB or 10GB, 0 is unlimited and \"default\" removes the override"
+
+// runQuota implements the quota subcommand, which shows a user's quotas
+// and usage and sets overrides of the defaults.
+func (cfg *apiConfig) runQuota(args []string) error {
+	flags := flag.NewFlagSet("quota", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	storage := flags.String("storage", "", "total bytes stored")
+	videos := flags.String("videos", "", "number of videos")
+	fileSize := flags.String("file-size", "", "bytes per upload")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New(quotaUsage)
+	}
+
+	user, err := cfg.users.GetUserByEmail(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if user.ID == uuid.Nil {
+		return fmt.Errorf("no user with email %s", flags.Arg(0))
+	}
+
+	if *storage != "" || *videos != "" || *fileSize != "" {
+		overrides, err := cfg.db.GetUserQuota(user.ID)
+		if err != nil {
+			return err
+		}
+		if overrides.MaxStorageBytes, err = parseQuotaOverride(*storage, overrides.MaxStorageBytes, parseByteSize); err != nil {
+			return err
+		}
+		if overrides.MaxFileSize, err = parseQuotaOverride(*fileSize, overrides.MaxFileSize, parseByteSize); err != nil {
+			return err
+		}
+		if overrides.MaxVideos, err = parseQuotaOverride(*videos, overrides.MaxVideos, parseVideoCount); err != nil {
+			return err
+		}
+		if err := cfg.db.SetUserQuota(user.ID, overrides); err != nil {
+			return err
+		}
+	}
+
+	quota, err := cfg.userQuota(user.ID)
+	if err != nil {
+		return err
+	}
+	storedBytes, err := cfg.db.GetStoredBytes(user.ID)
+	if err != nil {
+		return err
+	}
+	userVideos, err := cfg.videos.GetVideos(user.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("storage    %d of %s bytes\n", storedBytes, formatQuota(quota.maxStorageBytes))
+	fmt.Printf("videos     %d of %s\n", len(userVideos), formatQuota(int64(quota.maxVideos)))
+	fmt.Printf("file size  %s bytes\n", formatQuota(quota.maxFileSize))
+	return nil
+}
+
+// parseQuotaOverride applies a flag value to an override: empty keeps it,
+// "default" removes it and anything else is parsed.
+func parseQuotaOverride[T any](value string, current *T, parse func(string) (T, error)) (*T, error) {
+	switch value {
+	case "":
+		return current, nil
+	case "default":
+		return nil, nil
+	}
+	parsed, err := parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, quotaUsage)
+	}
+	return &parsed, nil
+}
+
+func parseVideoCount(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid video count %q", s)
+	}
+	return n, nil
+}
+
+func formatQuota(limit int64) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+	return strconv.FormatInt(limit, 10)
+}
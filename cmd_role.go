@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+const roleUsage = "usage: tubely role user|moderator|admin email"
+
+// runRole implements the role subcommand, which is how the first admin is
+// made before anyone can use the admin endpoints.
+func (cfg *apiConfig) runRole(args []string) error {
+	if len(args) != 2 {
+		return errors.New(roleUsage)
+	}
+	role, err := auth.ParseRole(args[0])
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, roleUsage)
+	}
+
+	user, err := cfg.users.GetUserByEmail(args[1])
+	if err != nil {
+		return err
+	}
+	if user.ID == uuid.Nil {
+		return fmt.Errorf("no user with email %s", args[1])
+	}
+	if err := cfg.users.SetUserRole(user.ID, string(role)); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s (was %s)\n", user.Email, role, user.Role)
+	return nil
+}
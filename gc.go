@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// videoKeyPrefixes are the prefixes the video store writes under, those of
+// private videos under storage.PrivatePrefix as well. Thumbnails sit at the
+// root of the thumbnail store, or right under storage.PrivatePrefix, so
+// only keys without another slash belong to it; that keeps the two apart
+// when they share a bucket or directory.
+var videoKeyPrefixes = []string{
+	"landscape/", "portrait/", "other/", originalKeyPrefix, directUploadPrefix,
+	storage.PrivatePrefix + "landscape/",
+	storage.PrivatePrefix + "portrait/",
+	storage.PrivatePrefix + "other/",
+	storage.PrivatePrefix + originalKeyPrefix,
+}
+
+type orphanGCConfig struct {
+	// interval of the background collector, 0 when it is off
+	interval time.Duration
+	// grace protects objects modified this recently, which may belong to an
+	// upload or processing job that hasn't written its row yet
+	grace  time.Duration
+	dryRun bool
+}
+
+type orphanedObject struct {
+	Store string
+	storage.ObjectInfo
+}
+
+type orphanGCResult struct {
+	Orphans []orphanedObject
+	Deleted int
+	Failed  int
+}
+
+// findOrphanedObjects lists objects that no database row points at and that
+// haven't been modified since cutoff.
+func (cfg *apiConfig) findOrphanedObjects(ctx context.Context, cutoff time.Time) ([]orphanedObject, error) {
+	// Objects are listed before references are read, so an object stored and
+	// referenced in between is never mistaken for an orphan
+	listed := []orphanedObject{}
+	for _, prefix := range videoKeyPrefixes {
+		objects, err := cfg.videoStore.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			listed = append(listed, orphanedObject{Store: blobStoreVideos, ObjectInfo: object})
+		}
+	}
+	objects, err := cfg.thumbnailStore.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		if !strings.Contains(strings.TrimPrefix(object.Key, storage.PrivatePrefix), "/") {
+			listed = append(listed, orphanedObject{Store: blobStoreThumbnails, ObjectInfo: object})
+		}
+	}
+
+	refs, err := cfg.db.GetBlobReferences(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	keys := map[database.CreateBlobDeletionParams]bool{}
+	prefixes := []string{}
+	addKeys := func(storeName string, stored []string) {
+		for _, value := range stored {
+			if key, ok := cfg.objectKey(storeName, value); ok {
+				keys[database.CreateBlobDeletionParams{Store: storeName, Key: key}] = true
+			}
+		}
+	}
+	addKeys(blobStoreVideos, refs.VideoKeys)
+	addKeys(blobStoreVideos, refs.DirectUploadKeys)
+	addKeys(blobStoreThumbnails, refs.ThumbnailKeys)
+	for _, value := range refs.HLSKeys {
+		if key, ok := cfg.objectKey(blobStoreVideos, value); ok && path.Dir(key) != "." {
+			prefixes = append(prefixes, path.Dir(key)+"/")
+		}
+	}
+
+	orphans := []orphanedObject{}
+	for _, object := range listed {
+		if !object.LastModified.Before(cutoff) || keys[database.CreateBlobDeletionParams{Store: object.Store, Key: object.Key}] {
+			continue
+		}
+		if object.Store == blobStoreVideos && hasAnyPrefix(object.Key, prefixes) {
+			continue
+		}
+		orphans = append(orphans, object)
+	}
+	return orphans, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectOrphanedObjects finds orphans older than the grace period and,
+// unless dryRun is set, deletes them. Failed deletions are logged and left
+// for the next run.
+func (cfg *apiConfig) collectOrphanedObjects(ctx context.Context, grace time.Duration, dryRun bool) (orphanGCResult, error) {
+	orphans, err := cfg.findOrphanedObjects(ctx, time.Now().Add(-grace))
+	if err != nil {
+		return orphanGCResult{}, err
+	}
+	result := orphanGCResult{Orphans: orphans}
+	if dryRun {
+		return result, nil
+	}
+
+	for _, orphan := range orphans {
+		if err := cfg.deleteObject(ctx, orphan.Store, orphan.Key); err != nil {
+			log.Printf("Couldn't delete orphaned object %s/%s: %v", orphan.Store, orphan.Key, err)
+			result.Failed++
+			continue
+		}
+		result.Deleted++
+	}
+	return result, nil
+}
+
+// startOrphanGC periodically removes objects nothing points at, which
+// earlier versions left behind and crashes can still leave behind.
+func (cfg *apiConfig) startOrphanGC(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(cfg.orphanGC.interval)
+		defer ticker.Stop()
+		for {
+			result, err := cfg.collectOrphanedObjects(ctx, cfg.orphanGC.grace, cfg.orphanGC.dryRun)
+			if err != nil {
+				log.Printf("Couldn't collect orphaned objects: %v", err)
+			} else if cfg.orphanGC.dryRun {
+				for _, orphan := range result.Orphans {
+					log.Printf("Orphaned object %s/%s (%d bytes, modified %s)", orphan.Store, orphan.Key, orphan.Size, orphan.LastModified.Format(time.RFC3339))
+				}
+			} else if len(result.Orphans) > 0 {
+				log.Printf("Deleted %d of %d orphaned objects", result.Deleted, len(result.Orphans))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
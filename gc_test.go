@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestCollectOrphanedObjects(t *testing.T) {
+	cfg, _ := newTestConfig(t)
+	ctx := context.Background()
+
+	put := func(store string, key string) {
+		t.Helper()
+		s, _ := cfg.blobStoreByName(store)
+		if err := s.Put(ctx, key, strings.NewReader("x"), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{
+		"landscape/kept.mp4",
+		"landscape/kept/master.m3u8",
+		"landscape/kept/720p/seg0.m4s",
+		"landscape/orphan.mp4",
+		"landscape/orphan/master.m3u8",
+		"originals/kept.mov",
+		"originals/orphan.mov",
+		"uploads/pending.mp4",
+		"uploads/abandoned.mp4",
+		"uploads/expired.mp4",
+	} {
+		put(blobStoreVideos, key)
+	}
+	for _, key := range []string{"thumb.jpg", "candidate.jpg", "orphan.jpg"} {
+		put(blobStoreThumbnails, key)
+	}
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "t", UserID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rows from before keys were stored hold URLs
+	legacyVideoURL := cfg.videoStore.URL("landscape/kept.mp4")
+	hlsKey := "landscape/kept/master.m3u8"
+	thumbnailKey := "thumb.jpg"
+	originalKey := "originals/kept.mov"
+	video.VideoKey, video.HLSKey, video.ThumbnailKey = &legacyVideoURL, &hlsKey, &thumbnailKey
+	video.OriginalKey = &originalKey
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.db.CreateThumbnailCandidate(database.CreateThumbnailCandidateParams{
+		VideoID: video.ID,
+		Key:     "candidate.jpg",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.db.CreateDirectUpload(uuid.New(), database.CreateDirectUploadParams{
+		UserID:    video.UserID,
+		VideoID:   video.ID,
+		ObjectKey: "uploads/pending.mp4",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.db.CreateDirectUpload(uuid.New(), database.CreateDirectUploadParams{
+		UserID:    video.UserID,
+		VideoID:   video.ID,
+		ObjectKey: "uploads/expired.mp4",
+		ExpiresAt: time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything was just written, so a grace period protects all of it
+	result, err := cfg.collectOrphanedObjects(ctx, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Orphans) != 0 {
+		t.Errorf("found %d orphans inside the grace period", len(result.Orphans))
+	}
+
+	result, err = cfg.collectOrphanedObjects(ctx, -time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, orphan := range result.Orphans {
+		got[orphan.Store+":"+orphan.Key] = true
+	}
+	want := []string{
+		"videos:landscape/orphan.mp4",
+		"videos:landscape/orphan/master.m3u8",
+		"videos:originals/orphan.mov",
+		"videos:uploads/abandoned.mp4",
+		"videos:uploads/expired.mp4",
+		"thumbnails:orphan.jpg",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got orphans %v, want %v", got, want)
+	}
+	for _, key := range want {
+		if !got[key] {
+			t.Errorf("%s not reported as orphaned", key)
+		}
+	}
+	if _, err := cfg.videoStore.Stat(ctx, "landscape/orphan.mp4"); err != nil {
+		t.Errorf("dry run deleted an object: %v", err)
+	}
+
+	result, err = cfg.collectOrphanedObjects(ctx, -time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Deleted != len(want) || result.Failed != 0 {
+		t.Errorf("deleted %d, failed %d, want %d deleted", result.Deleted, result.Failed, len(want))
+	}
+	if _, err := cfg.videoStore.Stat(ctx, "landscape/kept/720p/seg0.m4s"); err != nil {
+		t.Errorf("referenced HLS segment was deleted: %v", err)
+	}
+}
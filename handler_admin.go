@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// adminUser is a user as the admin endpoints show it, without the password
+// hash
+type adminUser struct {
+	ID         uuid.UUID  `json:"id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	DisabledAt *time.Time `json:"disabled_at"`
+}
+
+func newAdminUser(user database.User) adminUser {
+	return adminUser{
+		ID:         user.ID,
+		Email:      user.Email,
+		Role:       user.Role,
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+		DisabledAt: user.DisabledAt,
+	}
+}
+
+func (cfg *apiConfig) handlerAdminUsersList(w http.ResponseWriter, r *http.Request) {
+	users, err := cfg.users.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get users", err)
+		return
+	}
+	resp := make([]adminUser, 0, len(users))
+	for _, user := range users {
+		resp = append(resp, newAdminUser(user))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// pathUser returns the user named by the userID path value. It writes the
+// error response itself and returns ok=false when there is none.
+func (cfg *apiConfig) pathUser(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return database.User{}, false
+	}
+	user, err := cfg.users.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return database.User{}, false
+	}
+	return *user, true
+}
+
+// respondWithUser sends the user as it is now, after a change
+func (cfg *apiConfig) respondWithUser(w http.ResponseWriter, userID uuid.UUID) {
+	user, err := cfg.users.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newAdminUser(*user))
+}
+
+// handlerAdminUserDisable disables an account and ends its sessions. Access
+// tokens and API keys it already holds stop working at once too.
+func (cfg *apiConfig) handlerAdminUserDisable(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.pathUser(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.users.SetUserDisabled(user.ID, true); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable user", err)
+		return
+	}
+	if err := cfg.refreshTokens.RevokeUserRefreshTokens(user.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	log.Printf("Disabled user %s (%s)", user.ID, user.Email)
+	cfg.respondWithUser(w, user.ID)
+}
+
+func (cfg *apiConfig) handlerAdminUserEnable(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.pathUser(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.users.SetUserDisabled(user.ID, false); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable user", err)
+		return
+	}
+	log.Printf("Enabled user %s (%s)", user.ID, user.Email)
+	cfg.respondWithUser(w, user.ID)
+}
+
+// handlerAdminUserRoleUpdate changes a user's role, which applies to their
+// next request whatever role their access token claims.
+func (cfg *apiConfig) handlerAdminUserRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	user, ok := cfg.pathUser(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	role, err := auth.ParseRole(params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Role must be user, moderator or admin", err)
+		return
+	}
+	if err := cfg.users.SetUserRole(user.ID, string(role)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+	log.Printf("Changed role of user %s (%s) from %s to %s", user.ID, user.Email, user.Role, role)
+	cfg.respondWithUser(w, user.ID)
+}
+
+// handlerAdminVideoDelete takes down any video, whoever owns it and
+// whatever its status.
+func (cfg *apiConfig) handlerAdminVideoDelete(w http.ResponseWriter, r *http.Request) {
+	// Only the ownership check of pathVideo is skipped
+	videoID, ok := pathVideoID(w, r)
+	if !ok {
+		return
+	}
+	video, ok := cfg.loadVideo(w, videoID)
+	if !ok {
+		return
+	}
+	// Unlike owners, moderators don't wait for processing to finish. The
+	// job is cancelled along with the video, see deleteVideo.
+	if err := cfg.deleteVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	log.Printf("Took down video %s of user %s", video.ID, video.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
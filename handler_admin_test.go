@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestAdminRoutes(t *testing.T) {
+	cfg, store := newTestConfig(t)
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/users", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUsersList))
+	mux.Handle("POST /admin/users/{userID}/disable", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUserDisable))
+	mux.Handle("PUT /admin/users/{userID}/role", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUserRoleUpdate))
+	mux.Handle("DELETE /admin/videos/{videoID}", cfg.requireRole(auth.RoleModerator, cfg.handlerAdminVideoDelete))
+
+	newUser := func(email string, role auth.Role) (database.User, string) {
+		t.Helper()
+		user, err := store.CreateUser(database.CreateUserParams{Email: email, Password: "x"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetUserRole(user.ID, string(role)); err != nil {
+			t.Fatal(err)
+		}
+		token, err := auth.MakeJWT(user.ID, role, cfg.jwtKeys, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *user, token
+	}
+	admin, adminToken := newUser("admin@example.com", auth.RoleAdmin)
+	_, moderatorToken := newUser("moderator@example.com", auth.RoleModerator)
+	user, userToken := newUser("user@example.com", auth.RoleUser)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do(http.MethodGet, "/admin/users", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("listing users without a token: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := do(http.MethodGet, "/admin/users", moderatorToken, ""); w.Code != http.StatusForbidden {
+		t.Errorf("listing users as a moderator: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w := do(http.MethodGet, "/admin/users", adminToken, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("listing users as an admin: got status %d: %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), "password") {
+		t.Errorf("user list includes passwords: %s", w.Body)
+	}
+	var users []adminUser
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 {
+		t.Errorf("got %d users, want 3", len(users))
+	}
+
+	// Moderators and admins can take down anyone's video
+	video, err := store.CreateVideo(database.CreateVideoParams{Title: "t", UserID: admin.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := do(http.MethodDelete, "/admin/videos/"+video.ID.String(), userToken, ""); w.Code != http.StatusForbidden {
+		t.Errorf("taking down a video with a user token: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := do(http.MethodDelete, "/admin/videos/"+video.ID.String(), moderatorToken, ""); w.Code != http.StatusNoContent {
+		t.Errorf("taking down a video as a moderator: got status %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, "/admin/videos/"+video.ID.String(), moderatorToken, ""); w.Code != http.StatusNotFound {
+		t.Errorf("taking down a missing video: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	// Admin routes answer like every other video route
+	if w := do(http.MethodDelete, "/admin/videos/nope", moderatorToken, ""); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Invalid video ID") {
+		t.Errorf("taking down an invalid video ID: got status %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPut, "/admin/users/nope/role", adminToken, `{"role":"user"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Invalid user ID") {
+		t.Errorf("setting the role of an invalid user ID: got status %d: %s", w.Code, w.Body)
+	}
+
+	if w := do(http.MethodPut, "/admin/users/"+user.ID.String()+"/role", adminToken, `{"role":"owner"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("setting an unknown role: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := do(http.MethodPut, "/admin/users/"+user.ID.String()+"/role", adminToken, `{"role":"moderator"}`); w.Code != http.StatusOK {
+		t.Errorf("setting a role: got status %d: %s", w.Code, w.Body)
+	}
+	if updated, _ := store.GetUser(user.ID); updated.Role != "moderator" {
+		t.Errorf("role = %q, want moderator", updated.Role)
+	}
+
+	// Role changes apply at once, whatever role a token was issued with
+	if w := do(http.MethodGet, "/admin/users", userToken, ""); w.Code != http.StatusForbidden {
+		t.Errorf("listing users as a promoted moderator: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := do(http.MethodDelete, "/admin/videos/"+uuid.NewString(), userToken, ""); w.Code != http.StatusNotFound {
+		t.Errorf("taking down a video as a promoted moderator: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	demoted, demotedToken := newUser("demoted@example.com", auth.RoleAdmin)
+	if w := do(http.MethodPut, "/admin/users/"+demoted.ID.String()+"/role", adminToken, `{"role":"user"}`); w.Code != http.StatusOK {
+		t.Errorf("demoting an admin: got status %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/admin/users", demotedToken, ""); w.Code != http.StatusForbidden {
+		t.Errorf("listing users as a demoted admin: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	// Disabling ends the user's sessions and locks them out of admin routes
+	// even with a token that still claims the role
+	_, err = store.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "session", UserID: admin.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := do(http.MethodPost, "/admin/users/"+admin.ID.String()+"/disable", adminToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("disabling a user: got status %d: %s", w.Code, w.Body)
+	}
+	if rt, _ := store.GetRefreshToken("session"); rt.RevokedAt == nil {
+		t.Error("disabling a user should revoke their refresh tokens")
+	}
+	if w := do(http.MethodGet, "/admin/users", adminToken, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("listing users as a disabled admin: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAdminVideoTakedownWhileProcessing(t *testing.T) {
+	ctx := context.Background()
+	cfg, _ := newTestConfig(t)
+	cfg.videos, cfg.users = cfg.db, cfg.db
+	cfg.instanceID = "test"
+	cfg.jobWake = make(chan struct{}, 1)
+
+	moderator, err := cfg.users.CreateUser(database.CreateUserParams{Email: "moderator@example.com", Password: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.users.SetUserRole(moderator.ID, string(auth.RoleModerator)); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.MakeJWT(moderator.ID, auth.RoleModerator, cfg.jwtKeys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, _ := newTestUser(t, cfg, "user@example.com")
+
+	// queue returns a processing video with a job for an upload of it
+	queue := func() (database.Video, database.ProcessingJob) {
+		t.Helper()
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "t", UserID: userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sourcePath := filepath.Join(t.TempDir(), "upload.mp4")
+		if err := os.WriteFile(sourcePath, newTestMP4(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		job, err := cfg.enqueueVideoProcessing(video.ID, sourcePath, "video/mp4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return video, job
+	}
+	takeDown := func(video database.Video) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodDelete, "/admin/videos/"+video.ID.String(), nil)
+		r.SetPathValue("videoID", video.ID.String())
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.requireRole(auth.RoleModerator, cfg.handlerAdminVideoDelete).ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("taking down a processing video: got status %d: %s", w.Code, w.Body)
+		}
+		if got, _ := cfg.db.GetVideo(video.ID); got.ID != uuid.Nil {
+			t.Error("video is still there after its takedown")
+		}
+	}
+
+	// A queued job goes with the video, along with its upload
+	video, job := queue()
+	takeDown(video)
+	if got, _ := cfg.db.GetProcessingJob(job.ID); got.ID != uuid.Nil {
+		t.Error("queued job of a video that was taken down is still there")
+	}
+	if _, err := os.Stat(job.SourcePath); !os.IsNotExist(err) {
+		t.Errorf("upload of a cancelled job left behind: %v", err)
+	}
+
+	// A running job finds the video gone and gives up without retrying
+	video, _ = queue()
+	job, ok, err := cfg.db.ClaimProcessingJob(cfg.instanceID)
+	if err != nil || !ok {
+		t.Fatalf("ClaimProcessingJob = %t, %v", ok, err)
+	}
+	takeDown(video)
+	if _, err := os.Stat(job.SourcePath); err != nil {
+		t.Fatalf("upload of a running job was removed under it: %v", err)
+	}
+	cfg.runProcessingJob(ctx, job)
+	if _, err := os.Stat(job.SourcePath); !os.IsNotExist(err) {
+		t.Errorf("upload of a job whose video was taken down left behind: %v", err)
+	}
+	if _, ok, _ := cfg.db.ClaimProcessingJob(cfg.instanceID); ok {
+		t.Error("job of a video that was taken down was requeued")
+	}
+	if used, _ := cfg.db.GetStoredBytes(userID); used != 0 {
+		t.Errorf("%d bytes stored for a video that was taken down", used)
+	}
+}
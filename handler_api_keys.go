@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// API keys are managed with a JWT only, so a leaked key can't be used to
+// mint more keys or to hide by revoking the others.
+
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+		// Scopes defaults to all of them
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	type response struct {
+		database.APIKey
+		// Key is only ever shown here
+		Key string `json:"key"`
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required", nil)
+		return
+	}
+	scopes := auth.Scopes
+	if params.Scopes != nil {
+		var err error
+		scopes, err = auth.ParseScopes(params.Scopes)
+		if err != nil || len(scopes) == 0 {
+			respondWithError(w, http.StatusBadRequest, "Scopes must be videos:read and/or videos:write", err)
+			return
+		}
+	}
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "expires_at must be in the future", nil)
+		return
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      params.Name,
+		Prefix:    auth.APIKeyPrefix(key),
+		KeyHash:   auth.HashAPIKey(key),
+		Scopes:    scopes,
+		ExpiresAt: params.ExpiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{APIKey: apiKey, Key: key})
+}
+
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r.Context()).UserID
+
+	keys, err := cfg.db.GetUserAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r.Context()).UserID
+
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	apiKey, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	// Someone else's key is as good as missing
+	if apiKey.ID == uuid.Nil || apiKey.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+
+	if err := cfg.db.RevokeAPIKey(apiKey.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestAPIKeys(t *testing.T) {
+	cfg, _ := newTestConfig(t)
+	// API keys reference users, so they have to live in the same database
+	cfg.users = cfg.db
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/api_keys", cfg.requireSession(cfg.handlerAPIKeyCreate))
+	mux.Handle("GET /api/api_keys", cfg.requireSession(cfg.handlerAPIKeysList))
+	mux.Handle("DELETE /api/api_keys/{keyID}", cfg.requireSession(cfg.handlerAPIKeyRevoke))
+	mux.Handle("GET /api/videos", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerVideosRetrieve))
+	mux.Handle("POST /api/videos", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoMetaCreate))
+
+	newUser := func(email string) (database.User, string) {
+		t.Helper()
+		user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: "x"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := auth.MakeJWT(user.ID, auth.RoleUser, cfg.jwtKeys, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *user, token
+	}
+	user, jwt := newUser("bot@example.com")
+	_, otherJWT := newUser("other@example.com")
+
+	do := func(method, path, authorization, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/api_keys", "Bearer "+jwt, `{"name":"ci","scopes":["videos:read"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating a key: got status %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		ID     string   `json:"id"`
+		Key    string   `json:"key"`
+		Prefix string   `json:"prefix"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.Key, created.Prefix) || len(created.Scopes) != 1 {
+		t.Errorf("created key = %+v", created)
+	}
+	readKey := "ApiKey " + created.Key
+
+	for _, body := range []string{`{"name":""}`, `{"name":"x","scopes":["admin"]}`, `{"name":"x","expires_at":"2000-01-01T00:00:00Z"}`} {
+		if w := do(http.MethodPost, "/api/api_keys", "Bearer "+jwt, body); w.Code != http.StatusBadRequest {
+			t.Errorf("creating a key with %s: got status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := do(http.MethodPost, "/api/api_keys", readKey, `{"name":"more"}`); w.Code != http.StatusForbidden {
+		t.Errorf("creating a key with a key: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w = do(http.MethodGet, "/api/api_keys", "Bearer "+jwt, "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Key) || strings.Contains(w.Body.String(), "hash") {
+		t.Errorf("listing keys: got status %d: %s", w.Code, w.Body)
+	}
+
+	// The key works where its scope allows and nowhere else
+	if w := do(http.MethodGet, "/api/videos", readKey, ""); w.Code != http.StatusOK {
+		t.Errorf("listing videos with a read key: got status %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/api/videos", readKey, `{"title":"t"}`); w.Code != http.StatusForbidden {
+		t.Errorf("creating a video with a read key: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := do(http.MethodGet, "/api/videos", "ApiKey tubely_nope", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("listing videos with an unknown key: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := do(http.MethodDelete, "/api/api_keys/"+created.ID, "Bearer "+otherJWT, ""); w.Code != http.StatusNotFound {
+		t.Errorf("revoking someone else's key: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := do(http.MethodDelete, "/api/api_keys/"+created.ID, "Bearer "+jwt, ""); w.Code != http.StatusNoContent {
+		t.Errorf("revoking a key: got status %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/api/videos", readKey, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("listing videos with a revoked key: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// A key stops working when its user is disabled
+	w = do(http.MethodPost, "/api/api_keys", "Bearer "+jwt, `{"name":"writer"}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(http.MethodPost, "/api/videos", "ApiKey "+created.Key, `{"title":"t"}`); w.Code != http.StatusCreated {
+		t.Errorf("creating a video with a write key: got status %d: %s", w.Code, w.Body)
+	}
+	if err := cfg.db.SetUserDisabled(user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(http.MethodGet, "/api/videos", "ApiKey "+created.Key, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("using the key of a disabled user: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
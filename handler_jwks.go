@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// jwksMaxAge is how long verifiers may cache the key set. A new signing key
+// should be published as a verification key for at least this long before
+// it starts signing.
+const jwksMaxAge = "max-age=300"
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Keys []auth.JWK `json:"keys"`
+	}
+
+	w.Header().Set("Cache-Control", jwksMaxAge)
+	respondWithJSON(w, http.StatusOK, response{Keys: cfg.jwtKeys.JWKS()})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestHandlerJWKS(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name string) string {
+		t.Helper()
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	current, previous := writeKey("current.pem"), writeKey("previous.pem")
+
+	if _, err := loadJWTKeys("", "", ""); err == nil {
+		t.Error("loading JWT keys with neither a secret nor a signing key should fail")
+	}
+	keys, err := loadJWTKeys("secret", current, previous+", ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{jwtKeys: keys}
+
+	w := httptest.NewRecorder()
+	cfg.handlerJWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") == "" {
+		t.Fatalf("got status %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	var resp struct {
+		Keys []auth.JWK `json:"keys"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Keys) != 2 || resp.Keys[0].KeyType != "OKP" || resp.Keys[0].X == "" {
+		t.Fatalf("keys = %+v, want the signing and previous keys", resp.Keys)
+	}
+
+	// Another service can verify a token with nothing but the published key
+	token, err := auth.MakeJWT(uuid.New(), auth.RoleUser, keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(resp.Keys[0].X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jwt.Parse(token, func(token *jwt.Token) (any, error) {
+		if token.Header["kid"] != resp.Keys[0].ID {
+			t.Errorf("token kid %v, want %q", token.Header["kid"], resp.Keys[0].ID)
+		}
+		return ed25519.PublicKey(x), nil
+	}, jwt.WithValidMethods([]string{resp.Keys[0].Algorithm}))
+	if err != nil {
+		t.Errorf("verifying with the published key: %v", err)
+	}
+}
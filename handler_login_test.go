@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerLogin(t *testing.T) {
+	store := database.NewMemoryStore()
+	cfg := &apiConfig{
+		videos:        store,
+		users:         store,
+		refreshTokens: store,
+		jwtKeys:       testJWTKeys(t),
+	}
+
+	hash, err := auth.HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := store.CreateUser(database.CreateUserParams{Email: "a@example.com", Password: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body := `{"email":"a@example.com","password":"` + password + `"}`
+		w := httptest.NewRecorder()
+		cfg.handlerLogin(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)))
+		return w
+	}
+
+	if w := login("wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w := login("hunter2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var resp struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if userID, err := auth.ValidateJWT(resp.Token, cfg.jwtKeys); err != nil || userID != user.ID {
+		t.Errorf("access token is for %v (%v), want %v", userID, err, user.ID)
+	}
+	if rt, _ := store.GetRefreshToken(resp.RefreshToken); rt.UserID != user.ID {
+		t.Error("refresh token wasn't saved for the user")
+	}
+
+	if err := store.SetUserDisabled(user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if w := login("hunter2"); w.Code != http.StatusForbidden {
+		t.Errorf("disabled user: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
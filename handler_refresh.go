@@ -1,15 +1,23 @@
 package main
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+// refreshTokenLifetime is how long a refresh token lasts. Each refresh
+// issues a new one, so a session lasts as long as it keeps being used.
+const refreshTokenLifetime = time.Hour * 24 * 60
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,15 +26,69 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	rt, err := cfg.refreshTokens.GetRefreshToken(refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if rt.Token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+	// A rotated token only comes back if it was stolen, or the client that
+	// rotated it was. Either way nobody can tell which copy is the real
+	// one, so the whole session ends.
+	if rt.ReplacedBy != nil {
+		cfg.revokeRefreshTokenFamily(rt)
+		respondWithError(w, http.StatusUnauthorized, "Refresh token was already used", nil)
+		return
+	}
+	if rt.RevokedAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token was revoked", nil)
+		return
+	}
+	if !rt.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has expired", nil)
+		return
+	}
+
+	user, err := cfg.users.GetUser(rt.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user for refresh token", err)
+		return
+	}
+	if user == nil || user.DisabledAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+
+	nextToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+	_, err = cfg.refreshTokens.RotateRefreshToken(rt.Token, database.CreateRefreshTokenParams{
+		Token:     nextToken,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenLifetime),
+		FamilyID:  rt.FamilyID,
+	})
+	// Lost a race with another refresh using the same token
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		cfg.revokeRefreshTokenFamily(rt)
+		respondWithError(w, http.StatusUnauthorized, "Refresh token was already used", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
 		return
 	}
 
+	// The role is read again, so a changed role takes effect here
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
+		auth.Role(user.Role),
+		cfg.jwtKeys,
 		time.Hour,
 	)
 	if err != nil {
@@ -35,10 +97,18 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: nextToken,
 	})
 }
 
+func (cfg *apiConfig) revokeRefreshTokenFamily(rt database.RefreshToken) {
+	log.Printf("Refresh token reused for user %s, revoking its family %s", rt.UserID, rt.FamilyID)
+	if err := cfg.refreshTokens.RevokeRefreshTokenFamily(rt.FamilyID); err != nil {
+		log.Printf("Couldn't revoke refresh token family %s: %v", rt.FamilyID, err)
+	}
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -46,7 +116,17 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(refreshToken)
+	// Logging out ends the session, including tokens rotated from this one
+	rt, err := cfg.refreshTokens.GetRefreshToken(refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if rt.Token == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	err = cfg.refreshTokens.RevokeRefreshTokenFamily(rt.FamilyID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
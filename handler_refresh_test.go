@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerRefresh(t *testing.T) {
+	cfg, store := newTestConfig(t)
+
+	user, err := store.CreateUser(database.CreateUserParams{Email: "a@example.com", Password: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.CreateRefreshToken(database.CreateRefreshTokenParams{
+		Token:     "login",
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func(token string) (int, string) {
+		r := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.handlerRefresh(w, r)
+		var resp struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.RefreshToken
+	}
+
+	code, second := refresh("login")
+	if code != http.StatusOK || second == "" || second == "login" {
+		t.Fatalf("first refresh: got status %d and refresh token %q", code, second)
+	}
+	code, third := refresh(second)
+	if code != http.StatusOK || third == "" {
+		t.Fatalf("refreshing with the rotated token: got status %d and refresh token %q", code, third)
+	}
+
+	// Replaying a rotated token ends the session, including the newest token
+	if code, _ := refresh(second); code != http.StatusUnauthorized {
+		t.Errorf("replaying a rotated token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh(third); code != http.StatusUnauthorized {
+		t.Errorf("refreshing after a replay: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	_, err = store.CreateRefreshToken(database.CreateRefreshTokenParams{
+		Token:     "expired",
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := refresh("expired"); code != http.StatusUnauthorized {
+		t.Errorf("expired token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh("unknown"); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// A token whose user is gone must not mint access tokens
+	if err := store.DeleteUser(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.CreateRefreshToken(database.CreateRefreshTokenParams{
+		Token:     "orphan",
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := refresh("orphan"); code != http.StatusUnauthorized {
+		t.Errorf("token of a deleted user: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerThumbnailCandidatesGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.pathVideo(w, r)
+	if !ok {
+		return
+	}
+
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return
+	}
+	resp, err := cfg.thumbnailCandidateResponses(r.Context(), video, candidates)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build thumbnail URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerThumbnailCandidateSelect(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.pathVideo(w, r)
+	if !ok {
+		return
+	}
+	candidateID, err := uuid.Parse(r.PathValue("candidateID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid candidate ID", err)
+		return
+	}
+
+	candidate, err := cfg.db.GetThumbnailCandidate(candidateID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidate", err)
+		return
+	}
+	if candidate.ID == uuid.Nil || candidate.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Thumbnail candidate not found", nil)
+		return
+	}
+
+	// An uploaded thumbnail being replaced isn't needed any more
+	orphans, err := cfg.thumbnailOrphans(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return
+	}
+	video.ThumbnailKey = &candidate.Key
+	video.ThumbnailVariants = candidate.Variants
+	if err := cfg.videos.UpdateVideo(video, orphans...); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	resp, err := cfg.videoResponse(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestThumbnailCandidates(t *testing.T) {
+	ctx := context.Background()
+	cfg, _ := newTestConfig(t)
+	cfg.videos, cfg.users = cfg.db, cfg.db
+	cfg.thumbnails = thumbnailConfig{mode: thumbnailModeTimestamp, timestamp: 5 * time.Second, candidates: 3}
+
+	// There's no ffmpeg to grab a frame, so it's a blank one wherever it's from
+	var grabbedAt []time.Duration
+	extractThumbnailFrame = func(filePath, dir string, timestamp time.Duration) (extractedFrame, error) {
+		grabbedAt = append(grabbedAt, timestamp)
+		output := filepath.Join(dir, "frame.jpg")
+		file, err := os.Create(output)
+		if err != nil {
+			return extractedFrame{}, err
+		}
+		defer file.Close()
+		if err := jpeg.Encode(file, image.NewRGBA(image.Rect(0, 0, 640, 360)), nil); err != nil {
+			return extractedFrame{}, err
+		}
+		return extractedFrame{path: output, timestamp: timestamp.Seconds()}, nil
+	}
+	t.Cleanup(func() { extractThumbnailFrame = extractFrameAt })
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/videos/{videoID}/thumbnails", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerThumbnailCandidatesGet))
+	mux.Handle("POST /api/videos/{videoID}/thumbnails/{candidateID}/select", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerThumbnailCandidateSelect))
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	userID, token := newTestUser(t, cfg, "a@example.com")
+	_, otherToken := newTestUser(t, cfg, "b@example.com")
+	newVideo := func() database.Video {
+		t.Helper()
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "t", UserID: userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return video
+	}
+	video, otherVideo := newVideo(), newVideo()
+
+	// A video shorter than the configured timestamp is grabbed halfway in
+	if err := cfg.generateThumbnails(ctx, video.ID, "video.mp4", 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(grabbedAt) != 1 || grabbedAt[0] != 2*time.Second {
+		t.Errorf("grabbed frames at %v, want 2s", grabbedAt)
+	}
+	first, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("GetThumbnailCandidates = %v, %v, want one candidate", first, err)
+	}
+	got, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ThumbnailKey == nil || *got.ThumbnailKey != first[0].Key {
+		t.Fatalf("video thumbnail is %v, want the generated candidate %s", got.ThumbnailKey, first[0].Key)
+	}
+
+	// Processing the video again replaces the candidates but keeps the one
+	// that is the thumbnail
+	if err := cfg.generateThumbnails(ctx, video.ID, "video.mp4", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil || len(candidates) != 1 || candidates[0].ID == first[0].ID {
+		t.Fatalf("GetThumbnailCandidates = %v, %v, want one new candidate", candidates, err)
+	}
+	if got, _ := cfg.db.GetVideo(video.ID); got.ThumbnailKey == nil || *got.ThumbnailKey != first[0].Key {
+		t.Errorf("video thumbnail changed to %v when it already had one", got.ThumbnailKey)
+	}
+	if _, err := cfg.thumbnailStore.Stat(ctx, first[0].Key); err != nil {
+		t.Errorf("object of the current thumbnail was deleted: %v", err)
+	}
+	if err := cfg.generateThumbnails(ctx, otherVideo.ID, "video.mp4", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	w := do(http.MethodGet, "/api/videos/"+video.ID.String()+"/thumbnails", token)
+	var listed []thumbnailCandidateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("listing candidates: got status %d: %s", w.Code, w.Body)
+	}
+	if len(listed) != 1 || listed[0].ID != candidates[0].ID || listed[0].URL != cfg.thumbnailStore.URL(candidates[0].Key) || len(listed[0].Srcset) == 0 {
+		t.Errorf("listed %+v, want %s", listed, candidates[0].ID)
+	}
+	if w := do(http.MethodGet, "/api/videos/"+video.ID.String()+"/thumbnails", otherToken); w.Code != http.StatusForbidden {
+		t.Errorf("listing someone else's candidates: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	selectPath := func(candidateID string) string {
+		return "/api/videos/" + video.ID.String() + "/thumbnails/" + candidateID + "/select"
+	}
+	w = do(http.MethodPost, selectPath(candidates[0].ID.String()), token)
+	var resp videoResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("selecting a candidate: got status %d: %s", w.Code, w.Body)
+	}
+	if resp.ThumbnailURL == nil || *resp.ThumbnailURL != cfg.thumbnailStore.URL(candidates[0].Key) {
+		t.Errorf("thumbnail_url = %v, want the selected candidate", resp.ThumbnailURL)
+	}
+	if got, _ := cfg.db.GetVideo(video.ID); got.ThumbnailKey == nil || *got.ThumbnailKey != candidates[0].Key {
+		t.Errorf("video thumbnail is %v after selecting %s", got.ThumbnailKey, candidates[0].Key)
+	}
+
+	others, err := cfg.db.GetThumbnailCandidates(otherVideo.ID)
+	if err != nil || len(others) != 1 {
+		t.Fatalf("GetThumbnailCandidates = %v, %v, want one candidate", others, err)
+	}
+	for name, tc := range map[string]struct {
+		candidateID string
+		token       string
+		want        int
+	}{
+		"another video's candidate": {others[0].ID.String(), token, http.StatusNotFound},
+		"a missing candidate":       {uuid.NewString(), token, http.StatusNotFound},
+		"a bad candidate ID":        {"nope", token, http.StatusBadRequest},
+		"someone else's video":      {candidates[0].ID.String(), otherToken, http.StatusForbidden},
+	} {
+		if w := do(http.MethodPost, selectPath(tc.candidateID), tc.token); w.Code != tc.want {
+			t.Errorf("selecting %s: got status %d, want %d", name, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads following the tus 1.0 protocol (https://tus.io), with
+// the creation, termination and expiration extensions. Finished uploads are
+// handed to the same processing queue as POST /api/video_upload/{videoID}.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusUploadPath = "/api/tus/"
+)
+
+// tusLocks prevents two PATCH requests from appending to the same upload
+// at once.
+type tusLocks struct {
+	mu     sync.Mutex
+	active map[uuid.UUID]bool
+}
+
+func (l *tusLocks) lock(id uuid.UUID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active == nil {
+		l.active = map[uuid.UUID]bool{}
+	}
+	if l.active[id] {
+		return false
+	}
+	l.active[id] = true
+	return true
+}
+
+func (l *tusLocks) unlock(id uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.active, id)
+}
+
+func (cfg *apiConfig) tusStagingDir() string {
+	return filepath.Join(cfg.uploadsRoot, "tus")
+}
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// checkTusResumable rejects clients speaking a protocol version we don't
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	setTusHeaders(w)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header of comma separated
+// "key base64(value)" pairs.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value for %q: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	// Users may have their own limit, this is the default
+	if cfg.quotas.maxFileSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(cfg.quotas.maxFileSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	quota, err := cfg.userQuota(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get quota", err)
+		return
+	}
+	if !cfg.enforceUploadQuota(w, userID, quota, length) {
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+
+	videoID, err := uuid.Parse(metadata["videoID"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload-Metadata must include a valid videoID", err)
+		return
+	}
+
+	if _, ok := cfg.authorizeVideo(w, r, videoID); !ok {
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(metadata["filetype"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload-Metadata must include a valid filetype", err)
+		return
+	}
+	// Only a first check, the file's own bytes decide once it's complete
+	if !slices.Contains(acceptedVideoTypes, mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Uploaded file is not a supported video", nil)
+		return
+	}
+
+	uploadID := uuid.New()
+	if err := cfg.videos.StartVideoUpload(videoID, uploadID); err != nil {
+		if errors.Is(err, database.ErrInvalidVideoTransition) {
+			respondWithError(w, http.StatusConflict, "Video is already being processed", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+
+	if err := os.MkdirAll(cfg.tusStagingDir(), 0755); err != nil {
+		cfg.failVideoUpload(videoID, uploadID, "unable to create staging directory")
+		respondWithError(w, http.StatusInternalServerError, "Unable to create staging directory", err)
+		return
+	}
+	stagingFile, err := os.CreateTemp(cfg.tusStagingDir(), videoID.String()+"-*")
+	if err != nil {
+		cfg.failVideoUpload(videoID, uploadID, "unable to create staging file")
+		respondWithError(w, http.StatusInternalServerError, "Unable to create staging file", err)
+		return
+	}
+	stagingFile.Close()
+
+	upload, err := cfg.db.CreateTusUpload(uploadID, database.CreateTusUploadParams{
+		UserID:      userID,
+		VideoID:     videoID,
+		Length:      length,
+		ContentType: mediaType,
+		Metadata:    r.Header.Get("Upload-Metadata"),
+		StagingPath: stagingFile.Name(),
+		ExpiresAt:   time.Now().Add(cfg.tusUploadExpiry),
+	})
+	if err != nil {
+		os.Remove(stagingFile.Name())
+		cfg.failVideoUpload(videoID, uploadID, "couldn't create upload")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	w.Header().Set("Location", tusUploadPath+upload.ID.String())
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// getTusUpload loads the upload the request refers to for its user.
+// It writes the error response itself and returns ok=false on failure.
+func (cfg *apiConfig) getTusUpload(w http.ResponseWriter, r *http.Request) (database.TusUpload, bool) {
+	if !checkTusResumable(w, r) {
+		return database.TusUpload{}, false
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", err)
+		return database.TusUpload{}, false
+	}
+	upload, err := cfg.db.GetTusUpload(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return database.TusUpload{}, false
+	}
+	if upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return database.TusUpload{}, false
+	}
+	if upload.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "User does not own this upload", nil)
+		return database.TusUpload{}, false
+	}
+	if upload.CompletedAt == nil && time.Now().After(upload.ExpiresAt) {
+		respondWithError(w, http.StatusGone, "Upload has expired", nil)
+		return database.TusUpload{}, false
+	}
+	return upload, true
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Metadata", upload.Metadata)
+	if upload.CompletedAt == nil {
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+
+	if !cfg.tusLocks.lock(upload.ID) {
+		respondWithError(w, http.StatusLocked, "Upload is already being written to", nil)
+		return
+	}
+	defer cfg.tusLocks.unlock(upload.ID)
+
+	// Reload now that we hold the lock, another PATCH may have just finished
+	upload, err := cfg.db.GetTusUpload(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if offset != upload.Offset {
+		respondWithError(w, http.StatusConflict, "Upload-Offset does not match the current offset", nil)
+		return
+	}
+	if upload.CompletedAt != nil {
+		respondWithError(w, http.StatusForbidden, "Upload is already complete", nil)
+		return
+	}
+
+	stagingFile, err := os.OpenFile(upload.StagingPath, os.O_WRONLY, 0644)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to open staging file", err)
+		return
+	}
+	defer stagingFile.Close()
+
+	// Drop bytes from an interrupted request that never made it into the
+	// recorded offset
+	if err := stagingFile.Truncate(upload.Offset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to prepare staging file", err)
+		return
+	}
+	if _, err := stagingFile.Seek(upload.Offset, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to prepare staging file", err)
+		return
+	}
+
+	// Keep whatever arrived before the connection dropped, that is the
+	// point of a resumable upload
+	remaining := upload.Length - upload.Offset
+	written, copyErr := io.Copy(stagingFile, io.LimitReader(r.Body, remaining))
+	if err := stagingFile.Sync(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	upload.Offset += written
+	if err := cfg.db.UpdateTusUploadOffset(upload.ID, upload.Offset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload offset", err)
+		return
+	}
+	if copyErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to write upload data", copyErr)
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		// filetype in Upload-Metadata was only the client's claim
+		mediaType, _, err := cfg.validateVideoFile(upload.StagingPath)
+		if err != nil {
+			var ruleErr *mediaRuleError
+			if errors.As(err, &ruleErr) {
+				cfg.deleteTusUpload(upload, ruleErr.Message)
+				respondWithMediaRuleError(w, ruleErr)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't validate uploaded video", err)
+			return
+		}
+		upload.ContentType = mediaType
+		if _, err := cfg.finishTusUpload(upload); err != nil {
+			if errors.Is(err, database.ErrInvalidVideoTransition) {
+				cfg.deleteTusUpload(upload, "")
+				respondWithError(w, http.StatusConflict, "Video is already being processed", err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
+			return
+		}
+	} else {
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if !cfg.tusLocks.lock(upload.ID) {
+		respondWithError(w, http.StatusLocked, "Upload is being written to", nil)
+		return
+	}
+	defer cfg.tusLocks.unlock(upload.ID)
+
+	if err := cfg.deleteTusUpload(upload, "upload was cancelled"); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload hands the assembled file to the processing queue, which
+// takes ownership of the staging file from here on.
+func (cfg *apiConfig) finishTusUpload(upload database.TusUpload) (database.ProcessingJob, error) {
+	job, err := cfg.enqueueVideoProcessing(upload.VideoID, upload.StagingPath, upload.ContentType)
+	if err != nil {
+		return database.ProcessingJob{}, err
+	}
+	if err := cfg.db.CompleteTusUpload(upload.ID); err != nil {
+		return database.ProcessingJob{}, err
+	}
+	log.Printf("Queued upload %s of video %s by user %s as job %s", upload.ID, upload.VideoID, upload.UserID, job.ID)
+	return job, nil
+}
+
+// deleteTusUpload discards an upload. If it never completed, the video is
+// marked failed with reason, unless reason is empty or the video has moved
+// on from the upload.
+func (cfg *apiConfig) deleteTusUpload(upload database.TusUpload, reason string) error {
+	// Completed uploads belong to their processing job now
+	if upload.CompletedAt == nil {
+		if err := os.Remove(upload.StagingPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if reason != "" {
+			cfg.failVideoUpload(upload.VideoID, upload.ID, reason)
+		}
+	}
+	return cfg.db.DeleteTusUpload(upload.ID)
+}
+
+// startTusExpiry periodically removes uploads that were abandoned before
+// they finished.
+func (cfg *apiConfig) startTusExpiry(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			cfg.expireTusUploads(time.Now())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// expireTusUploads deletes the uploads that expired by now, skipping any
+// that are receiving a chunk.
+func (cfg *apiConfig) expireTusUploads(now time.Time) {
+	uploads, err := cfg.db.GetExpiredTusUploads(now)
+	if err != nil {
+		log.Printf("Couldn't list expired uploads: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		if !cfg.tusLocks.lock(upload.ID) {
+			continue
+		}
+		if err := cfg.deleteTusUpload(upload, "upload expired"); err != nil {
+			log.Printf("Couldn't delete expired upload %s: %v", upload.ID, err)
+		}
+		cfg.tusLocks.unlock(upload.ID)
+	}
+}
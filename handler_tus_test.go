@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	out = append(out, typ...)
+	return append(out, body...)
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// newTestMP4 builds a fast start MP4 with one 1280x720 avc1 track lasting
+// 10 seconds, which is all validateVideoFile needs to read.
+func newTestMP4() []byte {
+	mvhd := mp4Box("mvhd", u32(0), u32(0), u32(0), u32(1000), u32(10000), make([]byte, 80))
+	tkhd := mp4Box("tkhd", u32(0), u32(0), u32(0), u32(1), u32(0), u32(10000), make([]byte, 52), u32(1280<<16), u32(720<<16))
+	mdhd := mp4Box("mdhd", u32(0), u32(0), u32(0), u32(90000), u32(900000), u32(0))
+	hdlr := mp4Box("hdlr", u32(0), u32(0), []byte("vide"), make([]byte, 13))
+	avcC := mp4Box("avcC", []byte{1, 0x64, 0x00, 0x1f, 0xff})
+	avc1 := mp4Box("avc1", make([]byte, 24), []byte{0x05, 0x00, 0x02, 0xd0}, make([]byte, 50), avcC)
+	stsd := mp4Box("stsd", u32(0), u32(1), avc1)
+	stbl := mp4Box("stbl", stsd, mp4Box("stco", u32(0), u32(1), u32(0)))
+	trak := mp4Box("trak", tkhd, mp4Box("mdia", mdhd, hdlr, mp4Box("minf", stbl)))
+	moov := mp4Box("moov", mvhd, trak)
+	ftyp := mp4Box("ftyp", []byte("isom"), u32(512), []byte("isomiso2avc1mp41"))
+	return bytes.Join([][]byte{ftyp, moov, mp4Box("mdat", make([]byte, 1024))}, nil)
+}
+
+func TestTusUpload(t *testing.T) {
+	cfg, _ := newTestConfig(t)
+	cfg.videos, cfg.users = cfg.db, cfg.db
+	cfg.uploadsRoot = t.TempDir()
+	cfg.tusUploadExpiry = time.Hour
+	cfg.tusLocks = &tusLocks{}
+	cfg.jobWake = make(chan struct{}, 1)
+	cfg.quotas.maxFileSize = 1 << 20
+
+	// The MP4 boxes say as much as ffprobe would, installed or not
+	probeUpload = func(string) (FFProbeOutput, error) {
+		return FFProbeOutput{Streams: []FFProbeStream{{CodecType: "video", CodecName: "h264", Width: 1280, Height: 720}}}, nil
+	}
+	t.Cleanup(func() { probeUpload = probeVideo })
+
+	mux := http.NewServeMux()
+	write := func(handler http.HandlerFunc) http.Handler { return cfg.requireAuth(auth.ScopeVideosWrite, handler) }
+	mux.HandleFunc("OPTIONS /api/tus/", cfg.handlerTusOptions)
+	mux.Handle("POST /api/tus/{$}", write(cfg.handlerTusCreate))
+	mux.Handle("HEAD /api/tus/{uploadID}", write(cfg.handlerTusHead))
+	mux.Handle("PATCH /api/tus/{uploadID}", write(cfg.handlerTusPatch))
+
+	userID, token := newTestUser(t, cfg, "a@example.com")
+	_, otherToken := newTestUser(t, cfg, "b@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "t", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := newTestMP4()
+
+	do := func(method, path, token string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.Header.Set("Tus-Resumable", tusVersion)
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodOptions, tusUploadPath, "", nil, nil)
+	if w.Code != http.StatusNoContent || w.Header().Get("Tus-Version") != tusVersion ||
+		w.Header().Get("Tus-Extension") != tusExtensions || w.Header().Get("Tus-Max-Size") != "1048576" {
+		t.Errorf("OPTIONS: got status %d, headers %v", w.Code, w.Header())
+	}
+
+	metadata := "videoID " + base64.StdEncoding.EncodeToString([]byte(video.ID.String())) +
+		",filetype " + base64.StdEncoding.EncodeToString([]byte("video/mp4"))
+	create := map[string]string{"Upload-Length": strconv.Itoa(len(data)), "Upload-Metadata": metadata}
+	if w := do(http.MethodPost, tusUploadPath, token, map[string]string{"Tus-Resumable": "0.2.2"}, nil); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("POST with an unsupported version: got status %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+	if w := do(http.MethodPost, tusUploadPath, otherToken, create, nil); w.Code != http.StatusForbidden {
+		t.Errorf("POST for someone else's video: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w = do(http.MethodPost, tusUploadPath, token, create, nil)
+	if w.Code != http.StatusCreated || w.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("POST: got status %d: %s", w.Code, w.Body)
+	}
+	location := w.Header().Get("Location")
+	uploadID, err := uuid.Parse(location[len(tusUploadPath):])
+	if err != nil {
+		t.Fatalf("POST returned Location %q", location)
+	}
+
+	head := func(token string) *httptest.ResponseRecorder {
+		t.Helper()
+		return do(http.MethodHead, location, token, nil, nil)
+	}
+	if w := head(token); w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "0" || w.Header().Get("Upload-Length") != strconv.Itoa(len(data)) {
+		t.Errorf("HEAD of a new upload: got status %d, headers %v", w.Code, w.Header())
+	}
+	if w := head(otherToken); w.Code != http.StatusForbidden {
+		t.Errorf("HEAD of someone else's upload: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	patch := func(offset int, chunk []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		return do(http.MethodPatch, location, token, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		}, chunk)
+	}
+	half := len(data) / 2
+	if w := patch(0, data[:half]); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("PATCH of the first chunk: got status %d, offset %q: %s", w.Code, w.Header().Get("Upload-Offset"), w.Body)
+	}
+	// Resending a chunk the server already has is a conflict
+	if w := patch(0, data[:half]); w.Code != http.StatusConflict {
+		t.Errorf("PATCH at a stale offset: got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := head(token); w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Errorf("HEAD after the first chunk: Upload-Offset = %q, want %d", w.Header().Get("Upload-Offset"), half)
+	}
+	if got, _ := cfg.db.GetLatestProcessingJob(video.ID); got.ID != uuid.Nil {
+		t.Fatal("a partial upload was queued for processing")
+	}
+
+	// The final chunk hands the file to the processing queue
+	if w := patch(half, data[half:]); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != strconv.Itoa(len(data)) {
+		t.Fatalf("PATCH of the final chunk: got status %d, offset %q: %s", w.Code, w.Header().Get("Upload-Offset"), w.Body)
+	}
+	job, err := cfg.db.GetLatestProcessingJob(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == uuid.Nil || job.ContentType != "video/mp4" || job.Status != database.ProcessingStatusQueued {
+		t.Fatalf("final chunk queued %+v", job)
+	}
+	upload, err := cfg.db.GetTusUpload(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.CompletedAt == nil || job.SourcePath != upload.StagingPath {
+		t.Errorf("upload %+v isn't complete or isn't the job's source", upload)
+	}
+	if got, _ := cfg.db.GetVideo(video.ID); got.Status != database.VideoStatusProcessing {
+		t.Errorf("video is %s after its upload finished, want processing", got.Status)
+	}
+	select {
+	case <-cfg.jobWake:
+	default:
+		t.Error("finishing an upload didn't wake a worker")
+	}
+	if w := patch(len(data), nil); w.Code != http.StatusForbidden {
+		t.Errorf("PATCH of a complete upload: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestTusUploadExpiry(t *testing.T) {
+	cfg, _ := newTestConfig(t)
+	cfg.videos, cfg.users = cfg.db, cfg.db
+	cfg.tusLocks = &tusLocks{}
+	cfg.jobWake = make(chan struct{}, 1)
+	userID, _ := newTestUser(t, cfg, "a@example.com")
+
+	// newUpload starts an upload of a video that expires at expiresAt
+	newUpload := func(videoID uuid.UUID, expiresAt time.Time) database.TusUpload {
+		t.Helper()
+		uploadID := uuid.New()
+		if err := cfg.videos.StartVideoUpload(videoID, uploadID); err != nil {
+			t.Fatal(err)
+		}
+		upload, err := cfg.db.CreateTusUpload(uploadID, database.CreateTusUploadParams{
+			UserID:      userID,
+			VideoID:     videoID,
+			Length:      100,
+			ContentType: "video/mp4",
+			StagingPath: filepath.Join(t.TempDir(), "upload"),
+			ExpiresAt:   expiresAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return upload
+	}
+	newVideo := func() database.Video {
+		t.Helper()
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "t", UserID: userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return video
+	}
+	expired := time.Now().Add(-time.Minute)
+
+	abandoned := newVideo()
+	newUpload(abandoned.ID, expired)
+
+	// The newer upload of this one finished and is being processed
+	processing := newVideo()
+	stale := newUpload(processing.ID, expired)
+	finished := newUpload(processing.ID, time.Now().Add(time.Hour))
+	if _, err := cfg.finishTusUpload(finished); err != nil {
+		t.Fatal(err)
+	}
+
+	// And this one is still receiving its newer upload
+	uploading := newVideo()
+	newUpload(uploading.ID, expired)
+	newUpload(uploading.ID, time.Now().Add(time.Hour))
+
+	cfg.expireTusUploads(time.Now())
+
+	if got, _ := cfg.db.GetTusUpload(stale.ID); got.ID != uuid.Nil {
+		t.Error("expired upload wasn't deleted")
+	}
+	for _, tc := range []struct {
+		name  string
+		video database.Video
+		want  database.VideoStatus
+	}{
+		{"abandoned", abandoned, database.VideoStatusFailed},
+		{"processing a newer upload", processing, database.VideoStatusProcessing},
+		{"receiving a newer upload", uploading, database.VideoStatusUploading},
+	} {
+		if got, _ := cfg.db.GetVideo(tc.video.ID); got.Status != tc.want {
+			t.Errorf("%s: video is %s after an upload expired, want %s", tc.name, got.Status, tc.want)
+		}
+	}
+}
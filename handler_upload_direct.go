@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	directUploadExpiry = time.Hour
+	// Files above the threshold are uploaded in parts of directUploadPartSize
+	directUploadMultipartThreshold = 100 << 20
+	directUploadPartSize           = 64 << 20
+	// Objects waiting for completion live here until they are validated
+	directUploadPrefix = "uploads/"
+)
+
+type directUploadPart struct {
+	PartNumber int32  `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+func (cfg *apiConfig) handlerUploadVideoPresign(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Size        int64  `json:"size"`
+		ContentType string `json:"content_type"`
+	}
+	type response struct {
+		UploadID  uuid.UUID          `json:"upload_id"`
+		Key       string             `json:"key"`
+		ExpiresAt time.Time          `json:"expires_at"`
+		URL       string             `json:"url,omitempty"`
+		PartSize  int64              `json:"part_size,omitempty"`
+		Parts     []directUploadPart `json:"parts,omitempty"`
+	}
+
+	uploader, ok := cfg.videoStore.(storage.DirectUploader)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads require S3 video storage", nil)
+		return
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	video, ok := cfg.pathVideo(w, r)
+	if !ok {
+		return
+	}
+	videoID := video.ID
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Size must be positive", nil)
+		return
+	}
+	quota, err := cfg.userQuota(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get quota", err)
+		return
+	}
+	if !cfg.enforceUploadQuota(w, userID, quota, params.Size) {
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(params.ContentType)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid content_type", err)
+		return
+	}
+	// Only a first check, the object's own bytes decide once it's complete
+	if !slices.Contains(acceptedVideoTypes, mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Uploaded file is not a supported video", nil)
+		return
+	}
+
+	uploadID := uuid.New()
+	if err := cfg.videos.StartVideoUpload(videoID, uploadID); err != nil {
+		if errors.Is(err, database.ErrInvalidVideoTransition) {
+			respondWithError(w, http.StatusConflict, "Video is already being processed", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+	// From here on a failed presign leaves its reason on the video
+	fail := func(code int, msg string, err error) {
+		cfg.failVideoUpload(videoID, uploadID, msg)
+		respondWithError(w, code, msg, err)
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		fail(http.StatusInternalServerError, "Error generating random bytes", err)
+		return
+	}
+	key := directUploadPrefix + hex.EncodeToString(randomBytes) + videoExtensions[mediaType]
+
+	resp := response{Key: key}
+	uploadParams := database.CreateDirectUploadParams{
+		UserID:      userID,
+		VideoID:     videoID,
+		ObjectKey:   key,
+		Size:        params.Size,
+		ContentType: mediaType,
+		ExpiresAt:   time.Now().Add(directUploadExpiry),
+	}
+
+	if params.Size <= directUploadMultipartThreshold {
+		resp.URL, err = uploader.PresignPut(r.Context(), key, mediaType, directUploadExpiry)
+		if err != nil {
+			fail(http.StatusInternalServerError, "Couldn't presign upload", err)
+			return
+		}
+	} else {
+		uploadParams.MultipartUploadID, err = uploader.CreateMultipartUpload(r.Context(), key, mediaType)
+		if err != nil {
+			fail(http.StatusInternalServerError, "Couldn't start multipart upload", err)
+			return
+		}
+		resp.PartSize = directUploadPartSize
+		partCount := (params.Size + directUploadPartSize - 1) / directUploadPartSize
+		for n := int32(1); n <= int32(partCount); n++ {
+			url, err := uploader.PresignUploadPart(r.Context(), key, uploadParams.MultipartUploadID, n, directUploadExpiry)
+			if err != nil {
+				uploader.AbortMultipartUpload(r.Context(), key, uploadParams.MultipartUploadID)
+				fail(http.StatusInternalServerError, "Couldn't presign upload part", err)
+				return
+			}
+			resp.Parts = append(resp.Parts, directUploadPart{PartNumber: n, URL: url})
+		}
+	}
+
+	upload, err := cfg.db.CreateDirectUpload(uploadID, uploadParams)
+	if err != nil {
+		if uploadParams.MultipartUploadID != "" {
+			uploader.AbortMultipartUpload(r.Context(), key, uploadParams.MultipartUploadID)
+		}
+		fail(http.StatusInternalServerError, "Couldn't save upload", err)
+		return
+	}
+	resp.UploadID = upload.ID
+	resp.ExpiresAt = upload.ExpiresAt
+
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (cfg *apiConfig) handlerUploadVideoComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UploadID uuid.UUID               `json:"upload_id"`
+		Parts    []storage.CompletedPart `json:"parts"`
+	}
+
+	uploader, ok := cfg.videoStore.(storage.DirectUploader)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads require S3 video storage", nil)
+		return
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	video, ok := cfg.pathVideo(w, r)
+	if !ok {
+		return
+	}
+	videoID := video.ID
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	upload, err := cfg.db.GetDirectUpload(params.UploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+	if upload.ID == uuid.Nil || upload.VideoID != videoID || upload.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return
+	}
+	if upload.CompletedAt != nil {
+		respondWithError(w, http.StatusConflict, "Upload is already complete", nil)
+		return
+	}
+	// Its URLs no longer work and the sweep may be discarding it already
+	if time.Now().After(upload.ExpiresAt) {
+		respondWithError(w, http.StatusGone, "Upload expired", nil)
+		return
+	}
+	// A later upload, through this or another route, may have replaced it
+	if video.Status != database.VideoStatusUploading || video.UploadID == nil || *video.UploadID != upload.ID {
+		respondWithError(w, http.StatusConflict, "Video is not waiting for an upload", nil)
+		return
+	}
+
+	if upload.MultipartUploadID != "" {
+		if len(params.Parts) == 0 {
+			respondWithError(w, http.StatusBadRequest, "Parts are required to complete a multipart upload", nil)
+			return
+		}
+		err = uploader.CompleteMultipartUpload(r.Context(), upload.ObjectKey, upload.MultipartUploadID, params.Parts)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't complete multipart upload", err)
+			return
+		}
+	}
+
+	// Check the object that actually landed in the bucket
+	info, err := cfg.videoStore.Stat(r.Context(), upload.ObjectKey)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Uploaded object not found", err)
+		return
+	}
+	// From here on the staged object is discarded whatever happens, and a
+	// rejected upload leaves its reason on the video
+	defer func() {
+		if err := cfg.videoStore.Delete(context.WithoutCancel(r.Context()), upload.ObjectKey); err != nil {
+			log.Printf("Couldn't delete staged upload %s: %v", upload.ObjectKey, err)
+		}
+	}()
+	fail := func(code int, msg string, err error) {
+		cfg.failVideoUpload(videoID, upload.ID, msg)
+		respondWithError(w, code, msg, err)
+	}
+	if info.Size != upload.Size {
+		fail(http.StatusUnprocessableEntity, fmt.Sprintf("Uploaded object is %d bytes, expected %d", info.Size, upload.Size), nil)
+		return
+	}
+
+	// Other uploads may have used up the quota since this one was presigned
+	quota, err := cfg.userQuota(userID)
+	if err != nil {
+		fail(http.StatusInternalServerError, "Couldn't get quota", err)
+		return
+	}
+	quotaErr, err := cfg.checkUploadQuota(userID, quota, info.Size)
+	if err != nil {
+		fail(http.StatusInternalServerError, "Couldn't check quota", err)
+		return
+	}
+	if quotaErr != nil {
+		cfg.failVideoUpload(videoID, upload.ID, quotaErr.Message)
+		respondWithQuotaError(w, quotaErr)
+		return
+	}
+
+	// The object only skipped the trip through the server, it is validated
+	// and processed like any other upload from a file the workers can find
+	uploadFile, err := os.CreateTemp(cfg.uploadsRoot, videoID.String()+"-*")
+	if err != nil {
+		fail(http.StatusInternalServerError, "Unable to create upload file", err)
+		return
+	}
+	if err := cfg.downloadObject(r.Context(), upload.ObjectKey, uploadFile); err != nil {
+		os.Remove(uploadFile.Name())
+		fail(http.StatusInternalServerError, "Couldn't read uploaded object", err)
+		return
+	}
+	if err := cfg.db.CompleteDirectUpload(upload.ID); err != nil {
+		log.Printf("Couldn't mark direct upload %s complete: %v", upload.ID, err)
+	}
+
+	mediaType, _, err := cfg.validateVideoFile(uploadFile.Name())
+	if err != nil {
+		os.Remove(uploadFile.Name())
+		var ruleErr *mediaRuleError
+		if errors.As(err, &ruleErr) {
+			cfg.failVideoUpload(videoID, upload.ID, ruleErr.Message)
+			respondWithMediaRuleError(w, ruleErr)
+			return
+		}
+		fail(http.StatusInternalServerError, "Couldn't validate uploaded video", err)
+		return
+	}
+
+	job, err := cfg.enqueueVideoProcessing(videoID, uploadFile.Name(), mediaType)
+	if err != nil {
+		os.Remove(uploadFile.Name())
+		if errors.Is(err, database.ErrInvalidVideoTransition) {
+			respondWithError(w, http.StatusConflict, "Video is already being processed", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
+		return
+	}
+
+	log.Printf("Queued direct upload of video %s by user %s as job %s", videoID, userID, job.ID)
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// downloadObject copies a staged object from the video store to file and
+// closes it.
+func (cfg *apiConfig) downloadObject(ctx context.Context, key string, file *os.File) error {
+	body, _, err := cfg.videoStore.Get(ctx, key)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	defer body.Close()
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// startDirectUploadExpiry periodically discards direct uploads that were
+// abandoned before they were completed.
+func (cfg *apiConfig) startDirectUploadExpiry(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			cfg.expireDirectUploads(ctx, time.Now())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// expireDirectUploads discards every upload that expired before now.
+// Failures are logged and retried on the next pass.
+func (cfg *apiConfig) expireDirectUploads(ctx context.Context, now time.Time) {
+	uploads, err := cfg.db.GetExpiredDirectUploads(now)
+	if err != nil {
+		log.Printf("Couldn't list expired direct uploads: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		if err := cfg.expireDirectUpload(ctx, upload); err != nil {
+			log.Printf("Couldn't expire direct upload %s: %v", upload.ID, err)
+		}
+	}
+}
+
+// expireDirectUpload aborts an expired upload, deletes whatever it staged
+// and marks its video failed, unless the video has moved on to a newer
+// upload since.
+func (cfg *apiConfig) expireDirectUpload(ctx context.Context, upload database.DirectUpload) error {
+	if uploader, ok := cfg.videoStore.(storage.DirectUploader); ok && upload.MultipartUploadID != "" {
+		err := uploader.AbortMultipartUpload(ctx, upload.ObjectKey, upload.MultipartUploadID)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	if err := cfg.videoStore.Delete(ctx, upload.ObjectKey); err != nil {
+		return err
+	}
+
+	cfg.failVideoUpload(upload.VideoID, upload.ID, "upload expired")
+	return cfg.db.DeleteDirectUpload(upload.ID)
+}
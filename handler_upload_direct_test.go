@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// fakeDirectUploader stands in for S3. Nothing fetches its presigned URLs,
+// tests put the uploaded objects in the store themselves.
+type fakeDirectUploader struct {
+	*storage.MemoryStore
+	aborted []string
+}
+
+func (f *fakeDirectUploader) PresignPut(ctx context.Context, key, contentType string, expiresIn time.Duration) (string, error) {
+	return f.URL(key) + "?upload", nil
+}
+
+func (f *fakeDirectUploader) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	return "multipart-" + key, nil
+}
+
+func (f *fakeDirectUploader) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expiresIn time.Duration) (string, error) {
+	return fmt.Sprintf("%s?part=%d", f.URL(key), partNumber), nil
+}
+
+func (f *fakeDirectUploader) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []storage.CompletedPart) error {
+	return nil
+}
+
+func (f *fakeDirectUploader) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	f.aborted = append(f.aborted, uploadID)
+	return nil
+}
+
+type directUploadTest struct {
+	t        *testing.T
+	cfg      *apiConfig
+	uploader *fakeDirectUploader
+	mux      *http.ServeMux
+}
+
+func newDirectUploadTest(t *testing.T) *directUploadTest {
+	cfg, _ := newTestConfig(t)
+	cfg.videos, cfg.users = cfg.db, cfg.db
+	cfg.uploadsRoot = t.TempDir()
+	uploader := &fakeDirectUploader{MemoryStore: storage.NewMemoryStore("http://localhost:8091/memory/videos")}
+	cfg.videoStore = uploader
+
+	// There's no ffprobe to read the object, so it always finds a 1080p video
+	probeUpload = func(string) (FFProbeOutput, error) {
+		return FFProbeOutput{Streams: []FFProbeStream{{CodecType: "video", CodecName: "h264", Width: 1920, Height: 1080}}}, nil
+	}
+	t.Cleanup(func() { probeUpload = probeVideo })
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/video_upload/{videoID}/presign", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerUploadVideoPresign))
+	mux.Handle("POST /api/video_upload/{videoID}/complete", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerUploadVideoComplete))
+	return &directUploadTest{t: t, cfg: cfg, uploader: uploader, mux: mux}
+}
+
+func (d *directUploadTest) newVideo(userID uuid.UUID) database.Video {
+	d.t.Helper()
+	video, err := d.cfg.db.CreateVideo(database.CreateVideoParams{Title: "t", UserID: userID})
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	return video
+}
+
+func (d *directUploadTest) post(path, token string, body any) *httptest.ResponseRecorder {
+	d.t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	d.mux.ServeHTTP(w, r)
+	return w
+}
+
+type presignResponse struct {
+	UploadID uuid.UUID `json:"upload_id"`
+	Key      string    `json:"key"`
+	URL      string    `json:"url"`
+	Parts    []directUploadPart
+}
+
+func (d *directUploadTest) presign(videoID uuid.UUID, token string, size int) presignResponse {
+	d.t.Helper()
+	return d.presignType(videoID, token, size, "video/mp4")
+}
+
+func (d *directUploadTest) presignType(videoID uuid.UUID, token string, size int, contentType string) presignResponse {
+	d.t.Helper()
+	w := d.post("/api/video_upload/"+videoID.String()+"/presign", token, map[string]any{"size": size, "content_type": contentType})
+	if w.Code != http.StatusCreated {
+		d.t.Fatalf("presigning an upload: got status %d: %s", w.Code, w.Body)
+	}
+	var resp presignResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		d.t.Fatal(err)
+	}
+	return resp
+}
+
+func (d *directUploadTest) complete(videoID uuid.UUID, token string, uploadID uuid.UUID) *httptest.ResponseRecorder {
+	d.t.Helper()
+	return d.post("/api/video_upload/"+videoID.String()+"/complete", token, map[string]any{"upload_id": uploadID})
+}
+
+func TestDirectUpload(t *testing.T) {
+	ctx := context.Background()
+	d := newDirectUploadTest(t)
+	testMP4 := newTestMP4()
+	userID, token := newTestUser(t, d.cfg, "a@example.com")
+	otherID, otherToken := newTestUser(t, d.cfg, "b@example.com")
+	video := d.newVideo(userID)
+
+	upload := d.presign(video.ID, token, len(testMP4))
+	if upload.URL == "" || !strings.HasPrefix(upload.Key, directUploadPrefix) {
+		t.Fatalf("presign returned %+v", upload)
+	}
+	if err := d.uploader.Put(ctx, upload.Key, bytes.NewReader(testMP4), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the upload's own user can complete it, and only for its video
+	if w := d.complete(video.ID, otherToken, upload.UploadID); w.Code != http.StatusForbidden {
+		t.Errorf("completing someone else's video: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := d.complete(d.newVideo(otherID).ID, otherToken, upload.UploadID); w.Code != http.StatusNotFound {
+		t.Errorf("completing someone else's upload: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := d.complete(d.newVideo(userID).ID, token, upload.UploadID); w.Code != http.StatusNotFound {
+		t.Errorf("completing an upload for another video: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// It is validated and queued like an upload through the server
+	w := d.complete(video.ID, token, upload.UploadID)
+	var job database.ProcessingJob
+	if err := json.Unmarshal(w.Body.Bytes(), &job); w.Code != http.StatusAccepted || err != nil {
+		t.Fatalf("completing an upload: got status %d: %s", w.Code, w.Body)
+	}
+	if got, _ := d.cfg.db.GetVideo(video.ID); got.Status != database.VideoStatusProcessing {
+		t.Errorf("completed video is %s, want processing", got.Status)
+	}
+	job, err := d.cfg.db.GetProcessingJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.VideoID != video.ID || job.ContentType != "video/mp4" {
+		t.Errorf("queued %+v", job)
+	}
+	if source, err := os.ReadFile(job.SourcePath); err != nil || !bytes.Equal(source, testMP4) {
+		t.Errorf("job source doesn't hold the uploaded object: %v", err)
+	}
+	if _, err := d.uploader.Stat(ctx, upload.Key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("staged object left behind: %v", err)
+	}
+	if w := d.complete(video.ID, token, upload.UploadID); w.Code != http.StatusConflict {
+		t.Errorf("completing an upload twice: got status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	// An object of a different size than was presigned is discarded
+	video = d.newVideo(userID)
+	upload = d.presign(video.ID, token, len(testMP4))
+	if err := d.uploader.Put(ctx, upload.Key, bytes.NewReader(append(testMP4, 0)), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if w := d.complete(video.ID, token, upload.UploadID); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("completing an upload of the wrong size: got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+	if _, err := d.uploader.Stat(ctx, upload.Key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("object of the wrong size left behind: %v", err)
+	}
+	if got, _ := d.cfg.db.GetVideo(video.ID); got.Status != database.VideoStatusFailed {
+		t.Errorf("video is %s after an upload of the wrong size, want failed", got.Status)
+	}
+
+	// The quota is checked again against what was actually uploaded, since
+	// other uploads may have finished in the meantime
+	upload = d.presign(video.ID, token, len(testMP4))
+	if err := d.uploader.Put(ctx, upload.Key, bytes.NewReader(testMP4), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	d.cfg.quotas.maxStorageBytes = int64(len(testMP4))
+	err = d.cfg.db.RecordStoredObject(database.RecordStoredObjectParams{Store: blobStoreVideos, Key: "other.mp4", UserID: userID, Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := d.complete(video.ID, token, upload.UploadID); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("completing an upload over the storage quota: got status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if got, _ := d.cfg.db.GetVideo(video.ID); got.Status != database.VideoStatusFailed {
+		t.Errorf("video is %s after an upload over the storage quota, want failed", got.Status)
+	}
+	d.cfg.quotas.maxStorageBytes = 0
+
+	// Other containers are accepted too, their bytes decide once uploaded
+	if upload := d.presignType(video.ID, token, len(testMP4), "video/webm"); !strings.HasSuffix(upload.Key, ".webm") {
+		t.Errorf("presigned a WebM upload as %s", upload.Key)
+	}
+	w = d.post("/api/video_upload/"+video.ID.String()+"/presign", token, map[string]any{"size": 10, "content_type": "image/png"})
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("presigning an image: got status %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+
+	upload = d.presign(video.ID, token, 1000)
+	if err := d.uploader.Put(ctx, upload.Key, bytes.NewReader(make([]byte, 1000)), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if w := d.complete(video.ID, token, upload.UploadID); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("completing an upload that isn't a video: got status %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+	if entries, _ := os.ReadDir(d.cfg.uploadsRoot); len(entries) != 1 {
+		t.Errorf("rejected uploads left %d files behind, want only the queued one", len(entries))
+	}
+}
+
+func TestDirectUploadExpiry(t *testing.T) {
+	ctx := context.Background()
+	d := newDirectUploadTest(t)
+	testMP4 := newTestMP4()
+	userID, token := newTestUser(t, d.cfg, "a@example.com")
+
+	video := d.newVideo(userID)
+	upload := d.presign(video.ID, token, directUploadMultipartThreshold+1)
+	if len(upload.Parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(upload.Parts))
+	}
+	// A part that made it before the client gave up
+	if err := d.uploader.Put(ctx, upload.Key, strings.NewReader("part"), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A video that moved on to a newer upload keeps uploading
+	superseded := d.newVideo(userID)
+	stale, err := d.cfg.db.CreateDirectUpload(uuid.New(), database.CreateDirectUploadParams{
+		UserID:    userID,
+		VideoID:   superseded.ID,
+		ObjectKey: directUploadPrefix + "stale.mp4",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.presign(superseded.ID, token, len(testMP4))
+	if w := d.complete(superseded.ID, token, stale.ID); w.Code != http.StatusGone {
+		t.Errorf("completing an expired upload: got status %d, want %d", w.Code, http.StatusGone)
+	}
+
+	d.cfg.expireDirectUploads(ctx, time.Now())
+	if got, _ := d.cfg.db.GetDirectUpload(stale.ID); got.ID != uuid.Nil {
+		t.Error("expired upload wasn't deleted")
+	}
+	if got, _ := d.cfg.db.GetVideo(superseded.ID); got.Status != database.VideoStatusUploading {
+		t.Errorf("video is %s after an older upload expired, want uploading", got.Status)
+	}
+	if got, _ := d.cfg.db.GetDirectUpload(upload.UploadID); got.ID == uuid.Nil {
+		t.Fatal("upload expired before its URLs did")
+	}
+
+	later := time.Now().Add(directUploadExpiry + time.Minute)
+	d.cfg.expireDirectUploads(ctx, later)
+	if len(d.uploader.aborted) != 1 || d.uploader.aborted[0] != "multipart-"+upload.Key {
+		t.Errorf("aborted %v, want the multipart upload of %s", d.uploader.aborted, upload.Key)
+	}
+	if _, err := d.uploader.Stat(ctx, upload.Key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("staged object of an expired upload left behind: %v", err)
+	}
+	if got, _ := d.cfg.db.GetDirectUpload(upload.UploadID); got.ID != uuid.Nil {
+		t.Error("expired upload wasn't deleted")
+	}
+	got, err := d.cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != database.VideoStatusFailed || got.FailureReason == nil || *got.FailureReason != "upload expired" {
+		t.Errorf("video is %s (%v) after its upload expired, want failed", got.Status, got.FailureReason)
+	}
+	if got, _ := d.cfg.db.GetVideo(superseded.ID); got.Status != database.VideoStatusFailed {
+		t.Errorf("video is %s after its newest upload expired, want failed", got.Status)
+	}
+}
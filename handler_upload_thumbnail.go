@@ -1,51 +1,56 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"fmt"
+	"errors"
 	"io"
-	"mime"
+	"log"
 	"net/http"
-	"os"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
-	"github.com/google/uuid"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
 )
 
-func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
-		return
-	}
+// maxThumbnailUploadSize caps thumbnail uploads even for users whose file
+// size quota allows more, since the whole image is decoded in memory
+const maxThumbnailUploadSize = 20 << 20
 
+func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
 	// AUTH
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	userID := principalFrom(r.Context()).UserID
+
+	// Get video for updating metadata, checking this user is its owner
+	// before reading an upload that would only be thrown away
+	video, ok := cfg.pathVideo(w, r)
+	if !ok {
 		return
 	}
+	videoID := video.ID
 
-	// AUTH
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	quota, err := cfg.userQuota(userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get quota", err)
 		return
 	}
+	limit := int64(maxThumbnailUploadSize)
+	if quota.maxFileSize > 0 {
+		limit = min(limit, quota.maxFileSize)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverhead)
 
 	// Allow 10 MB for form parsing (including file uploads)
 	const maxMemory = 10 << 20
 	err = r.ParseMultipartForm(maxMemory)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithQuotaError(w, fileSizeQuotaError(quotaConfig{maxFileSize: limit}, maxBytesErr.Limit))
+		return
+	}
 	if err != nil {
         http.Error(w, "Failed to parse form", http.StatusBadRequest)
         return
     }
 
 	// return first file with form key "thumbnail"
-	file, header, err := r.FormFile("thumbnail")
+	file, _, err := r.FormFile("thumbnail")
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
@@ -59,81 +64,49 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// used to get extension type
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+	if int64(len(imageData)) > limit {
+		respondWithQuotaError(w, fileSizeQuotaError(quotaConfig{maxFileSize: limit}, int64(len(imageData))))
 		return
 	}
-
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", err)
+	if !cfg.enforceUploadQuota(w, userID, quota, int64(len(imageData))) {
 		return
 	}
 
-	// BEGIN
-	// Create the /assets disk path
-	// Create new file path using crypto/rand.Read 
-	// to fill a 32-byte slice with random bytes
-
-	// TODO: Separate this into its own function for use elsewhere
-	// Used for cache busting
-	randomBytes := make([]byte, 32)
-	if _, err := rand.Read(randomBytes); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error generating random bytes", err)
+	// The image is decoded and re-encoded rather than stored as sent, so
+	// whatever the client claimed, only a real JPEG or PNG gets through
+	thumbnailKey, variants, err := cfg.storeThumbnailImage(r.Context(), video, imageData)
+	if errors.Is(err, imaging.ErrUnsupportedImage) || errors.Is(err, imaging.ErrImageTooLarge) {
+		respondWithError(w, http.StatusBadRequest, "Thumbnail must be a JPEG or PNG image", err)
 		return
 	}
-
-	// create asset path 
-	assetPath, err := cfg.getAssetPath(base64.RawURLEncoding.EncodeToString(randomBytes), mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong when getting the asset path", err)
-	}
-	assetDiskPath := cfg.getAssetDiskPath(assetPath)
-	
-	// Create the file where we are going to copy image data
-	imageFileCreate, err := os.Create(assetDiskPath)
-	defer imageFileCreate.Close()
-
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Something went wrong when trying to create the file at path %s", assetDiskPath), err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong when trying to store thumbnail", err)
 		return
 	}
 
-	// Copy image data to newly created file
-	err = os.WriteFile(assetDiskPath, imageData, 0644)
+	// The previous thumbnail goes unless it's still a candidate
+	orphans, err := cfg.thumbnailOrphans(video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Something went wrong when trying to copy raw image bytes to path %s", assetDiskPath), err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
 		return
 	}
 
-	// Get video for updating metadata
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Video not found", err)
-		return
-	}
+	video.ThumbnailKey = &thumbnailKey
+	video.ThumbnailVariants = variants
 
-	// Check to see if this user is owner of this video
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "User does not own this video", err)
+	// Update the video in the database if everything is 
+	err = cfg.videos.UpdateVideo(video, orphans...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
 
-	// create URL
-	thumbnailURL := cfg.getAssetURL(assetPath)
-	video.ThumbnailURL = &thumbnailURL
+	log.Printf("Uploaded thumbnail of video %s by user %s to %s", videoID, userID, thumbnailKey)
 
-	// Update the video in the database if everything is 
-	err = cfg.db.UpdateVideo(video)
+	resp, err := cfg.videoResponse(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't updarte video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
 		return
 	}
-
-	fmt.Printf("Uploading thumbnail for video:%s\nBy user: %s\nSaving to: %s\n", videoID, userID, assetDiskPath)
-
-	// Respond with video data in JSON format
-	// marshalled by  database.Video
-	respondWithJSON(w, http.StatusOK, database.Video(video))
+	respondWithJSON(w, http.StatusOK, resp)
 }
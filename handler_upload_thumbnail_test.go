@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerUploadThumbnail(t *testing.T) {
+	cfg, store := newTestConfig(t)
+
+	userID, token := newTestUser(t, cfg, "a@example.com")
+	video, err := store.CreateVideo(database.CreateVideoParams{Title: "t", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(data []byte, contentType string) (int, videoResponse) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreatePart(map[string][]string{
+			"Content-Disposition": {`form-data; name="thumbnail"; filename="thumb"`},
+			"Content-Type":        {contentType},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+		form.Close()
+
+		r := httptest.NewRequest(http.MethodPost, "/api/thumbnail_upload/"+video.ID.String(), &body)
+		r.SetPathValue("videoID", video.ID.String())
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerUploadThumbnail).ServeHTTP(w, r)
+		var resp videoResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	if code, _ := upload([]byte("<script>alert(1)</script>"), "image/png"); code != http.StatusBadRequest {
+		t.Errorf("upload of a fake PNG: got status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewNRGBA(image.Rect(0, 0, 2000, 1000))); err != nil {
+		t.Fatal(err)
+	}
+	code, resp := upload(img.Bytes(), "application/octet-stream")
+	if code != http.StatusOK {
+		t.Fatalf("upload of a PNG: got status %d, want %d", code, http.StatusOK)
+	}
+	for _, contentType := range []string{"image/jpeg", "image/webp"} {
+		srcset := strings.Split(resp.ThumbnailSrcset[contentType], ", ")
+		if len(srcset) != len(thumbnailWidths) || !strings.HasSuffix(srcset[0], " 1280w") || !strings.HasSuffix(srcset[2], " 320w") {
+			t.Errorf("%s srcset is %q", contentType, resp.ThumbnailSrcset[contentType])
+		}
+	}
+	if resp.ThumbnailURL == nil || !strings.HasSuffix(*resp.ThumbnailURL, "-1280.jpg") {
+		t.Errorf("thumbnail_url is %v, want the largest JPEG", resp.ThumbnailURL)
+	}
+
+	saved, err := store.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.ThumbnailVariants) != 2*len(thumbnailWidths) {
+		t.Fatalf("saved %d variants, want %d", len(saved.ThumbnailVariants), 2*len(thumbnailWidths))
+	}
+	for _, variant := range saved.ThumbnailVariants {
+		if _, err := cfg.thumbnailStore.Stat(context.Background(), variant.Key); err != nil {
+			t.Errorf("variant %s wasn't stored: %v", variant.Key, err)
+		}
+	}
+
+	// Replacing the thumbnail queues every variant of the old one
+	if code, _ := upload(img.Bytes(), "image/png"); code != http.StatusOK {
+		t.Fatalf("second upload: got status %d, want %d", code, http.StatusOK)
+	}
+	if orphans := store.Orphans(); len(orphans) != len(saved.ThumbnailVariants) {
+		t.Errorf("replacing the thumbnail queued %d objects, want %d", len(orphans), len(saved.ThumbnailVariants))
+	}
+}
@@ -1,178 +1,145 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/hex"
-	"fmt"
+	"errors"
 	"io"
 	"log"
-	"mime"
 	"net/http"
 	"os"
-	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
+// multipartOverhead is what a multipart body may add to the file it
+// carries, for limiting the body before the file's own size is known
+const multipartOverhead = 1 << 20
+
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 
-	// AUTH
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
+	userID := principalFrom(r.Context()).UserID
 
-	// AUTH - userID
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
-
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	// Get video for updating metadata, checking this user is its owner
+	video, ok := cfg.pathVideo(w, r)
+	if !ok {
 		return
 	}
+	videoID := video.ID
 
-	// Get video for updating metadata
-	video, err := cfg.db.GetVideo(videoID)
+	quota, err := cfg.userQuota(userID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get quota", err)
 		return
 	}
-
-	// Check to see if this user is owner of this video
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "User does not own this video", err)
+	if !cfg.enforceUploadQuota(w, userID, quota, 0) {
 		return
 	}
-	
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<30)
-	file, header,  err := r.FormFile("video"); 
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+	if quota.maxFileSize > 0 && r.ContentLength > quota.maxFileSize+multipartOverhead {
+		respondWithQuotaError(w, fileSizeQuotaError(quota, r.ContentLength))
 		return
 	}
-	defer file.Close();
 
-	// get media type 
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Trouble extracting media type from header", err)
+	// Refuse a second upload while the first is still being processed
+	uploadID := uuid.New()
+	if err := cfg.videos.StartVideoUpload(videoID, uploadID); err != nil {
+		if errors.Is(err, database.ErrInvalidVideoTransition) {
+			respondWithError(w, http.StatusConflict, "Video is already being processed", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Uploaded file is not an MP4", err)
-		return
-	}
-
-	// get the extension type
-	ext, err := cfg.getExtensionType(mediaType)
-	if err != nil {
-		log.Print(err)
+	// From here on a rejected upload leaves its reason on the video
+	fail := func(code int, msg string, err error) {
+		cfg.failVideoUpload(videoID, uploadID, msg)
+		respondWithError(w, code, msg, err)
 	}
-
-	// load 32 random bytes used for the s3 key name
-	randomBytes := make([]byte, 32)
-	if _, err := rand.Read(randomBytes); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error generating random bytes", err)
-		return
+	// A rejected upload that was never stored only failed its quota
+	failQuota := func(quotaErr *quotaError) {
+		cfg.failVideoUpload(videoID, uploadID, quotaErr.Message)
+		respondWithQuotaError(w, quotaErr)
 	}
 	
-	// Create name with random bytes for cache busting, add extension as well
-	s3Key := hex.EncodeToString(randomBytes) + ext
-
-	// Create temporary file to store video
-	tempFile, err := os.CreateTemp("", "*." + ext)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create temporary file", err)
-		return
+	if quota.maxFileSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, quota.maxFileSize+multipartOverhead)
 	}
-	defer func() {
-		tempFile.Close()
-		os.Remove(tempFile.Name())
-	}()
-
-	// Copy the request body into the temporary file.
-	if _, err := io.Copy(tempFile, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create copy video into temporary file", err)
+	file, _,  err := r.FormFile("video"); 
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		failQuota(fileSizeQuotaError(quota, maxBytesErr.Limit))
 		return
 	}
-	// Set pointer of file back to start
-	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to reset file pointer", err)
+	if err != nil {
+		fail(http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
+	defer file.Close();
 
-	// Create processed video using the temp file
-	processedVideoPath, err := processVideoForFastStart(tempFile.Name()) 
+	// Persist the raw upload where the processing workers can find it,
+	// even after a restart
+	uploadFile, err := os.CreateTemp(cfg.uploadsRoot, videoID.String()+"-*")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to process video", err)
+		fail(http.StatusInternalServerError, "Unable to create upload file", err)
 		return
 	}
+	defer uploadFile.Close()
 
-	// Open file for processing
-	processedVideoFile, err := os.Open(processedVideoPath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to open processed video for upload", err)
+	size, err := io.Copy(uploadFile, file)
+	if errors.As(err, &maxBytesErr) {
+		os.Remove(uploadFile.Name())
+		failQuota(fileSizeQuotaError(quota, maxBytesErr.Limit))
 		return
 	}
-	defer processedVideoFile.Close()
-
-	// Check that video is optimized for streaming
-	_, err = checkFileContainsString(processedVideoFile.Name(), "moov")
 	if err != nil {
-		fmt.Println("Error:", err)
+		os.Remove(uploadFile.Name())
+		fail(http.StatusInternalServerError, "Unable to save uploaded video", err)
 		return
 	}
-	
-	// append aspect ratio orientation to end of string
-	aspectRatio, err := getVideoAspectRatio(processedVideoFile.Name())
-	if err != nil {
-		fmt.Printf("Error getting aspect ratio of file %s: %v", processedVideoFile.Name(), err)
+	if err := uploadFile.Close(); err != nil {
+		os.Remove(uploadFile.Name())
+		fail(http.StatusInternalServerError, "Unable to save uploaded video", err)
+		return
 	}
 
-	// Create directory bucket string using aspect ratio
-	aspectRatioOrientation := getAspectRatioOrientation(aspectRatio)
-	s3KeyWithAspectRatioOrientation := createDirectoryBucketPrefix(aspectRatioOrientation) + s3Key
-
-	// Uploaded processed video to s3
-	putObjectInput :=  &s3.PutObjectInput{
-		Bucket: aws.String(cfg.s3Bucket),
-		Key: aws.String(s3KeyWithAspectRatioOrientation),
-		Body: processedVideoFile,
-		ContentType: aws.String(mediaType), 
+	// Now that the real size is known
+	quotaErr, err := cfg.checkUploadQuota(userID, quota, size)
+	if err != nil || quotaErr != nil {
+		os.Remove(uploadFile.Name())
+		if quotaErr != nil {
+			failQuota(quotaErr)
+			return
+		}
+		fail(http.StatusInternalServerError, "Couldn't check quota", err)
+		return
 	}
 
-	_, err = cfg.s3client.PutObject(r.Context(), putObjectInput)
+	// The type comes from the file itself, the part's Content-Type is only
+	// what the client claims. Nothing reaches the queue until it passes.
+	mediaType, _, err := cfg.validateVideoFile(uploadFile.Name())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to upload to S3", err)
+		os.Remove(uploadFile.Name())
+		var ruleErr *mediaRuleError
+		if errors.As(err, &ruleErr) {
+			cfg.failVideoUpload(videoID, uploadID, ruleErr.Message)
+			respondWithMediaRuleError(w, ruleErr)
+			return
+		}
+		fail(http.StatusInternalServerError, "Couldn't validate uploaded video", err)
 		return
 	}
 
-	// Use URL path to our CDN, CloudFront
-	// Grabbing CloudFront distribution from env
-	url := strings.Join([]string{cfg.s3CfDistribution, s3KeyWithAspectRatioOrientation}, "/")
-	fmt.Printf("video.VideoURL = %s\n", url)
-	video.VideoURL = &url
-
-	err = cfg.db.UpdateVideo(video)
+	job, err := cfg.enqueueVideoProcessing(videoID, uploadFile.Name(), mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		os.Remove(uploadFile.Name())
+		if errors.Is(err, database.ErrInvalidVideoTransition) {
+			respondWithError(w, http.StatusConflict, "Video is already being processed", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
 		return
 	}
-	
-	fmt.Println("uploading video ID: ", videoID, "\nBy user:", userID, "\nSaving to :", *video.VideoURL + "\n")
-	
-	os.Remove(processedVideoPath)
 
-	respondWithJSON(w, http.StatusOK, database.Video(video))
+	log.Printf("Queued upload of video %s by user %s as job %s", videoID, userID, job.ID)
 
+	respondWithJSON(w, http.StatusAccepted, job)
 }
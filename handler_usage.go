@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+)
+
+type usageItem struct {
+	Used int64 `json:"used"`
+	// Limit is null when there is none
+	Limit *int64 `json:"limit"`
+}
+
+type usageResponse struct {
+	Storage     usageItem `json:"storage"`
+	Videos      usageItem `json:"videos"`
+	MaxFileSize *int64    `json:"max_file_size"`
+}
+
+// quotaLimit turns a quota into a response field, where unlimited is null
+func quotaLimit(limit int64) *int64 {
+	if limit == 0 {
+		return nil
+	}
+	return &limit
+}
+
+func (cfg *apiConfig) handlerUsageGet(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r.Context()).UserID
+
+	quota, err := cfg.userQuota(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get quota", err)
+		return
+	}
+	storedBytes, err := cfg.db.GetStoredBytes(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	videos, err := cfg.videos.GetVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, usageResponse{
+		Storage:     usageItem{Used: storedBytes, Limit: quotaLimit(quota.maxStorageBytes)},
+		Videos:      usageItem{Used: int64(len(videos)), Limit: quotaLimit(int64(quota.maxVideos))},
+		MaxFileSize: quotaLimit(quota.maxFileSize),
+	})
+}
@@ -33,7 +33,7 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := cfg.db.CreateUser(database.CreateUserParams{
+	user, err := cfg.users.CreateUser(database.CreateUserParams{
 		Email:    params.Email,
 		Password: hashedPassword,
 	})
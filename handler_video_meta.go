@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
@@ -14,66 +13,59 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := principalFrom(r.Context()).UserID
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 	params.UserID = userID
-
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	quotaErr, err := cfg.checkVideoQuota(userID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check quota", err)
 		return
 	}
-
-	respondWithJSON(w, http.StatusCreated, video)
-}
-
-func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	if quotaErr != nil {
+		respondWithQuotaError(w, quotaErr)
+		return
+	}
+	if params.Visibility == "" {
+		params.Visibility = database.VideoVisibilityPublic
+	}
+	if !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be public, unlisted or private", nil)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	video, err := cfg.videos.CreateVideo(params.CreateVideoParams)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	resp, err := cfg.videoResponse(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.pathVideo(w, r)
+	if !ok {
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+	// The worker would otherwise publish files for a video that's gone
+	if video.Status == database.VideoStatusProcessing {
+		respondWithError(w, http.StatusConflict, "Video can't be deleted while it is processing", nil)
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
-	if err != nil {
+	if err := cfg.deleteVideo(video); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
@@ -82,39 +74,108 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 }
 
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+	type response struct {
+		videoResponse
+		Metadata *database.VideoMetadata `json:"metadata"`
+	}
+
+	video, ok := cfg.pathVisibleVideo(w, r)
+	if !ok {
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	metadata, err := cfg.db.GetVideoMetadata(video.ID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video metadata", err)
 		return
 	}
-	
-	respondWithJSON(w, http.StatusOK, video)
+	resp, err := cfg.videoResponse(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		videoResponse: resp,
+		Metadata:      metadata,
+	})
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	userID := principalFrom(r.Context()).UserID
+
+	videos, err := cfg.videos.GetVideos(userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	resp, err := cfg.videoResponses(r.Context(), videos)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerVideoVisibilityUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Visibility database.VideoVisibility `json:"visibility"`
+	}
+
+	video, ok := cfg.pathVideo(w, r)
+	if !ok {
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be public, unlisted or private", nil)
+		return
+	}
+
+	// Objects of private videos live under their own prefix, so they move
+	// whenever a video becomes private or stops being private
+	wasPrivate := video.Visibility == database.VideoVisibilityPrivate
+	video.Visibility = params.Visibility
+	var orphans []database.CreateBlobDeletionParams
+	if wasPrivate != (video.Visibility == database.VideoVisibilityPrivate) {
+		// A job that is still running would store its files under the old
+		// prefix
+		if video.Status == database.VideoStatusProcessing {
+			respondWithError(w, http.StatusConflict, "Video is still processing, try again once it's done", nil)
+			return
+		}
+		var err error
+		video, orphans, err = cfg.moveVideoObjects(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't move video files", err)
+			return
+		}
+	}
+	if err := cfg.videos.UpdateVideo(video, orphans...); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if len(orphans) > 0 {
+		cfg.wakeBlobCleanup()
+	}
+	resp, err := cfg.videoResponse(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// isVideoOwner reports whether the request was authenticated as the owner
+// of video.
+func (cfg *apiConfig) isVideoOwner(r *http.Request, video database.Video) bool {
+	userID := principalFrom(r.Context()).UserID
+	return userID != uuid.Nil && userID == video.UserID
 }
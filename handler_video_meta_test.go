@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// newTestConfig keeps videos, users and refresh tokens in a MemoryStore so
+// tests can inspect them, and everything else in a scratch SQLite database.
+func newTestConfig(t *testing.T) (*apiConfig, *database.MemoryStore) {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store := database.NewMemoryStore()
+	return &apiConfig{
+		db:             db,
+		videos:         store,
+		users:          store,
+		refreshTokens:  store,
+		jwtKeys:        testJWTKeys(t),
+		videoStore:     storage.NewMemoryStore("http://localhost:8091/memory/videos"),
+		thumbnailStore: storage.NewMemoryStore("http://localhost:8091/assets"),
+		cleanupWake:    make(chan struct{}, 1),
+	}, store
+}
+
+func testJWTKeys(t *testing.T) *auth.KeySet {
+	t.Helper()
+	keys, err := auth.NewKeySet(auth.NewSecretKey("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// newTestUser creates a user in cfg.users and returns its ID and an access
+// token for it.
+func newTestUser(t *testing.T, cfg *apiConfig, email string) (uuid.UUID, string) {
+	t.Helper()
+	user, err := cfg.users.CreateUser(database.CreateUserParams{Email: email, Password: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.MakeJWT(user.ID, auth.RoleUser, cfg.jwtKeys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID, token
+}
+
+func TestHandlerVideoMetaDelete(t *testing.T) {
+	cfg, store := newTestConfig(t)
+
+	userID, token := newTestUser(t, cfg, "a@example.com")
+	video, err := store.CreateVideo(database.CreateVideoParams{Title: "t", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoKey := "landscape/abc.mp4"
+	hlsKey := "landscape/abc/master.m3u8"
+	thumbnailKey := "thumb.jpg"
+	video.VideoKey, video.HLSKey, video.ThumbnailKey = &videoKey, &hlsKey, &thumbnailKey
+	if err := store.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetVideoStatus(video.ID, database.VideoStatusProcessing, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	deleteVideo := func() int {
+		r := httptest.NewRequest(http.MethodDelete, "/api/videos/"+video.ID.String(), nil)
+		r.SetPathValue("videoID", video.ID.String())
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoMetaDelete).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := deleteVideo(); code != http.StatusConflict {
+		t.Errorf("deleting a processing video: got status %d, want %d", code, http.StatusConflict)
+	}
+
+	if err := store.SetVideoStatus(video.ID, database.VideoStatusFailed, "ffmpeg failed"); err != nil {
+		t.Fatal(err)
+	}
+	if code := deleteVideo(); code != http.StatusNoContent {
+		t.Errorf("deleting a failed video: got status %d, want %d", code, http.StatusNoContent)
+	}
+	if got, _ := store.GetVideo(video.ID); got.ID != uuid.Nil {
+		t.Error("video still exists after delete")
+	}
+
+	want := map[database.CreateBlobDeletionParams]bool{
+		{Store: blobStoreVideos, Key: "landscape/abc.mp4"}: true,
+		{Store: blobStoreVideos, Key: "landscape/abc/"}:    true,
+		{Store: blobStoreThumbnails, Key: "thumb.jpg"}:     true,
+	}
+	orphans := store.Orphans()
+	for _, orphan := range orphans {
+		delete(want, orphan)
+	}
+	if len(want) > 0 {
+		t.Errorf("delete queued %+v, missing %+v", orphans, want)
+	}
+}
+
+func TestHandlerVideoGetRespectsVisibility(t *testing.T) {
+	cfg, store := newTestConfig(t)
+	cfg.signedURLExpiry = time.Hour
+
+	ownerID, ownerToken := newTestUser(t, cfg, "owner@example.com")
+	_, otherToken := newTestUser(t, cfg, "other@example.com")
+	video, err := store.CreateVideo(database.CreateVideoParams{Title: "t", UserID: ownerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoKey := "landscape/abc.mp4"
+	hlsKey := "landscape/abc/master.m3u8"
+	video.VideoKey, video.HLSKey = &videoKey, &hlsKey
+	if err := store.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	getVideo := func(token string) (int, videoResponse) {
+		r := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID.String(), nil)
+		r.SetPathValue("videoID", video.ID.String())
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		cfg.optionalAuth(auth.ScopeVideosRead, cfg.handlerVideoGet).ServeHTTP(w, r)
+		var resp videoResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := getVideo("")
+	if code != http.StatusOK {
+		t.Fatalf("public video: got status %d, want %d", code, http.StatusOK)
+	}
+	if resp.VideoURL == nil || *resp.VideoURL != cfg.videoStore.URL(videoKey) || resp.HLSURL == nil || resp.URLsExpireAt != nil {
+		t.Errorf("public video got URLs %v, %v expiring %v", resp.VideoURL, resp.HLSURL, resp.URLsExpireAt)
+	}
+
+	video.Visibility = database.VideoVisibilityPrivate
+	if err := store.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"", otherToken} {
+		if code, _ := getVideo(token); code != http.StatusNotFound {
+			t.Errorf("private video for a non-owner: got status %d, want %d", code, http.StatusNotFound)
+		}
+	}
+	code, resp = getVideo(ownerToken)
+	if code != http.StatusOK {
+		t.Fatalf("private video for its owner: got status %d, want %d", code, http.StatusOK)
+	}
+	if resp.VideoURL == nil || resp.URLsExpireAt == nil || resp.HLSURL != nil {
+		t.Errorf("private video got URLs %v, %v expiring %v", resp.VideoURL, resp.HLSURL, resp.URLsExpireAt)
+	}
+}
+
+func TestHandlerVideoVisibilityUpdateMovesObjects(t *testing.T) {
+	ctx := context.Background()
+	cfg, _ := newTestConfig(t)
+	cfg.videos, cfg.users = cfg.db, cfg.db
+	cfg.signedURLExpiry = time.Hour
+	cfg.urlSigner = storage.NewURLSigner([]byte("secret"))
+	videoStore := cfg.videoStore.(*storage.MemoryStore)
+	videoStore.UseURLSigning(cfg.urlSigner)
+	cfg.thumbnailStore.(*storage.MemoryStore).UseURLSigning(cfg.urlSigner)
+
+	userID, token := newTestUser(t, cfg, "a@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "t", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoKey := "landscape/abc.mp4"
+	hlsKey := "landscape/abc/master.m3u8"
+	thumbnailKey := "thumb-1280.jpg"
+	video.VideoKey, video.HLSKey, video.ThumbnailKey = &videoKey, &hlsKey, &thumbnailKey
+	video.ThumbnailVariants = database.ThumbnailVariants{
+		{Key: thumbnailKey, Width: 1280, Height: 720, ContentType: "image/jpeg"},
+		{Key: "thumb-1280.webp", Width: 1280, Height: 720, ContentType: "image/webp"},
+	}
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	candidate, err := cfg.db.CreateThumbnailCandidate(database.CreateThumbnailCandidateParams{
+		VideoID:  video.ID,
+		Key:      thumbnailKey,
+		Variants: video.ThumbnailVariants,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{videoKey, hlsKey, "landscape/abc/720p/segment0.ts"} {
+		if err := cfg.putObject(ctx, blobStoreVideos, userID, key, strings.NewReader("video"), "video/mp4"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range video.ThumbnailVariants.Keys() {
+		if err := cfg.putObject(ctx, blobStoreThumbnails, userID, key, strings.NewReader("image"), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setVisibility := func(visibility database.VideoVisibility) (int, videoResponse) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPut, "/api/videos/"+video.ID.String()+"/visibility", strings.NewReader(`{"visibility":"`+string(visibility)+`"}`))
+		r.SetPathValue("videoID", video.ID.String())
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoVisibilityUpdate).ServeHTTP(w, r)
+		var resp videoResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	fetch := func(rawURL string) int {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, rawURL, nil)
+		w := httptest.NewRecorder()
+		http.StripPrefix("/memory/videos", cfg.urlSigner.Protect(videoStore)).ServeHTTP(w, r)
+		return w.Code
+	}
+	// checkKeys checks that the video, its candidate and the objects they
+	// point at all sit under prefix
+	checkKeys := func(prefix string) {
+		t.Helper()
+		got, err := cfg.db.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		candidate, err := cfg.db.GetThumbnailCandidate(candidate.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := map[string]string{
+			prefix + videoKey: blobStoreVideos,
+			prefix + hlsKey:   blobStoreVideos,
+			prefix + "landscape/abc/720p/segment0.ts": blobStoreVideos,
+			prefix + thumbnailKey:                     blobStoreThumbnails,
+			prefix + "thumb-1280.webp":                blobStoreThumbnails,
+		}
+		for key, storeName := range keys {
+			store, _ := cfg.blobStoreByName(storeName)
+			if _, err := store.Stat(ctx, key); err != nil {
+				t.Errorf("%s/%s wasn't stored: %v", storeName, key, err)
+			}
+		}
+		if *got.VideoKey != prefix+videoKey || *got.HLSKey != prefix+hlsKey || *got.ThumbnailKey != prefix+thumbnailKey ||
+			got.ThumbnailVariants[1].Key != prefix+"thumb-1280.webp" {
+			t.Errorf("video points at %s, %s, %s, %+v", *got.VideoKey, *got.HLSKey, *got.ThumbnailKey, got.ThumbnailVariants)
+		}
+		if candidate.Key != prefix+thumbnailKey || candidate.Variants[1].Key != prefix+"thumb-1280.webp" {
+			t.Errorf("candidate points at %s, %+v", candidate.Key, candidate.Variants)
+		}
+	}
+	// checkOrphans checks that the objects under prefix are queued for
+	// deletion, so links handed out before the change stop working
+	checkOrphans := func(prefix string) {
+		t.Helper()
+		deletions, err := cfg.db.GetDueBlobDeletions(time.Now().Add(time.Minute), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[database.CreateBlobDeletionParams]bool{
+			{Store: blobStoreVideos, Key: prefix + videoKey}:              true,
+			{Store: blobStoreVideos, Key: prefix + "landscape/abc/"}:      true,
+			{Store: blobStoreThumbnails, Key: prefix + thumbnailKey}:      true,
+			{Store: blobStoreThumbnails, Key: prefix + "thumb-1280.webp"}: true,
+		}
+		for _, deletion := range deletions {
+			delete(want, database.CreateBlobDeletionParams{Store: deletion.Store, Key: deletion.Key})
+			if err := cfg.db.CompleteBlobDeletion(deletion.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(want) > 0 {
+			t.Errorf("missing deletions %+v", want)
+		}
+	}
+
+	code, resp := setVisibility(database.VideoVisibilityPrivate)
+	if code != http.StatusOK {
+		t.Fatalf("making a video private: got status %d", code)
+	}
+	checkKeys(storage.PrivatePrefix)
+	checkOrphans("")
+	if resp.VideoURL == nil {
+		t.Fatal("private video has no URL")
+	}
+	if code := fetch(*resp.VideoURL); code != http.StatusOK {
+		t.Errorf("fetching a private video through its signed URL: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := fetch(videoStore.URL(storage.PrivatePrefix + videoKey)); code != http.StatusForbidden {
+		t.Errorf("fetching a private video without a signature: got status %d, want %d", code, http.StatusForbidden)
+	}
+
+	// Unlisted videos are reachable by anyone with the link, like public ones
+	if code, _ := setVisibility(database.VideoVisibilityUnlisted); code != http.StatusOK {
+		t.Fatalf("making a video unlisted: got status %d", code)
+	}
+	checkKeys("")
+	checkOrphans(storage.PrivatePrefix)
+
+	if err := cfg.db.SetVideoStatus(video.ID, database.VideoStatusProcessing, ""); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := setVisibility(database.VideoVisibilityPrivate); code != http.StatusConflict {
+		t.Errorf("making a processing video private: got status %d, want %d", code, http.StatusConflict)
+	}
+	if code, _ := setVisibility(database.VideoVisibilityPublic); code != http.StatusOK {
+		t.Errorf("making a processing video public: got status %d, want %d", code, http.StatusOK)
+	}
+}
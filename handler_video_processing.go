@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoProcessingGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.pathVideo(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := cfg.db.GetLatestProcessingJob(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing status", err)
+		return
+	}
+	if job.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No video has been uploaded yet", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
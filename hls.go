@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	hlsSegmentTypeFMP4 = "fmp4"
+	hlsSegmentTypeTS   = "ts"
+
+	hlsMasterPlaylist = "master.m3u8"
+	hlsMediaPlaylist  = "index.m3u8"
+)
+
+// Default bitrates in kbps for the well known rungs of the ladder
+var hlsDefaultBitrates = map[int]int{
+	2160: 14000,
+	1440: 8000,
+	1080: 5000,
+	720:  2800,
+	480:  1400,
+	360:  800,
+	240:  400,
+}
+
+type hlsRendition struct {
+	Name         string
+	Height       int
+	VideoBitrate int
+}
+
+type hlsConfig struct {
+	renditions     []hlsRendition
+	segmentType    string
+	segmentSeconds int
+}
+
+func (h hlsConfig) enabled() bool {
+	return len(h.renditions) > 0
+}
+
+// parseHLSRenditions parses a ladder such as "1080p,720p:2500,480p". The
+// optional number after the colon overrides the video bitrate in kbps.
+func parseHLSRenditions(ladder string) ([]hlsRendition, error) {
+	renditions := []hlsRendition{}
+	for _, rung := range strings.Split(ladder, ",") {
+		rung = strings.TrimSpace(rung)
+		if rung == "" {
+			continue
+		}
+		name, bitrateString, hasBitrate := strings.Cut(rung, ":")
+		height, err := strconv.Atoi(strings.TrimSuffix(name, "p"))
+		if err != nil || height <= 0 || !strings.HasSuffix(name, "p") {
+			return nil, fmt.Errorf("invalid rendition %q", rung)
+		}
+
+		bitrate, ok := hlsDefaultBitrates[height]
+		if hasBitrate {
+			bitrate, err = strconv.Atoi(bitrateString)
+			if err != nil || bitrate <= 0 {
+				return nil, fmt.Errorf("invalid bitrate in rendition %q", rung)
+			}
+		} else if !ok {
+			// Scale linearly from the 720p rung for unusual heights
+			bitrate = hlsDefaultBitrates[720] * height / 720
+		}
+
+		renditions = append(renditions, hlsRendition{
+			Name:         name,
+			Height:       height,
+			VideoBitrate: bitrate,
+		})
+	}
+	return renditions, nil
+}
+
+// selectHLSRenditions drops renditions that would upscale the source. The
+// height of a rendition refers to the short side so portrait videos get the
+// same ladder. The smallest rendition is always kept.
+func selectHLSRenditions(renditions []hlsRendition, width, height int) []hlsRendition {
+	shortSide := min(width, height)
+	selected := []hlsRendition{}
+	var smallest *hlsRendition
+	for i, r := range renditions {
+		if r.Height <= shortSide {
+			selected = append(selected, r)
+		}
+		if smallest == nil || r.Height < smallest.Height {
+			smallest = &renditions[i]
+		}
+	}
+	if len(selected) == 0 && smallest != nil {
+		selected = append(selected, *smallest)
+	}
+	return selected
+}
+
+// renditionSize returns the output dimensions of a rendition, keeping the
+// source aspect ratio and rounding to even numbers as x264 requires.
+func renditionSize(r hlsRendition, width, height int) (int, int) {
+	even := func(n int) int { return n + n%2 }
+	if width >= height {
+		return even(r.Height * width / height), r.Height
+	}
+	return r.Height, even(r.Height * height / width)
+}
+
+// packageVideoForHLS transcodes filePath into every rendition and writes
+// the media playlists and the master playlist under outputDir.
+func (h hlsConfig) packageVideoForHLS(filePath, outputDir string) error {
+	width, height, err := getVideoDimensions(filePath)
+	if err != nil {
+		return err
+	}
+
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n")
+	if h.segmentType == hlsSegmentTypeFMP4 {
+		master.WriteString("#EXT-X-VERSION:7\n")
+	} else {
+		master.WriteString("#EXT-X-VERSION:3\n")
+	}
+	master.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	for _, r := range selectHLSRenditions(h.renditions, width, height) {
+		w, ht := renditionSize(r, width, height)
+		if err := h.transcodeRendition(filePath, filepath.Join(outputDir, r.Name), r, w, ht); err != nil {
+			return err
+		}
+		// Audio is encoded at 128 kbps in every rendition
+		bandwidth := (r.VideoBitrate + 128) * 1000
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n", bandwidth, w, ht)
+		fmt.Fprintf(&master, "%s/%s\n", r.Name, hlsMediaPlaylist)
+	}
+
+	return os.WriteFile(filepath.Join(outputDir, hlsMasterPlaylist), []byte(master.String()), 0644)
+}
+
+func (h hlsConfig) transcodeRendition(filePath, dir string, r hlsRendition, width, height int) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("ffmpeg", h.renditionArgs(absPath, r, width, height)...)
+	// Run inside the rendition directory so playlists reference segments
+	// with relative paths
+	cmd.Dir = dir
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = &b
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error creating %s rendition: %w\n%s", r.Name, err, b.String())
+	}
+	return nil
+}
+
+// renditionArgs builds the ffmpeg arguments that transcode absPath into one
+// rendition, run from inside the rendition's directory.
+func (h hlsConfig) renditionArgs(absPath string, r hlsRendition, width, height int) []string {
+	// HLS_SEGMENT_TYPE says "ts", ffmpeg calls MPEG-TS segments "mpegts"
+	segmentType, segmentExt := "mpegts", ".ts"
+	if h.segmentType == hlsSegmentTypeFMP4 {
+		segmentType, segmentExt = "fmp4", ".m4s"
+	}
+
+	args := []string{
+		"-i", absPath,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", fmt.Sprintf("scale=%d:%d", width, height),
+		"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "high",
+		"-b:v", fmt.Sprintf("%dk", r.VideoBitrate),
+		"-maxrate", fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+		"-bufsize", fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+		// Keyframes on segment boundaries keep renditions switchable
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", h.segmentSeconds),
+		"-sc_threshold", "0",
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(h.segmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", segmentType,
+		"-hls_segment_filename", "segment_%04d" + segmentExt,
+	}
+	if h.segmentType == hlsSegmentTypeFMP4 {
+		args = append(args, "-hls_fmp4_init_filename", "init.mp4")
+	}
+	return append(args, hlsMediaPlaylist)
+}
+
+// uploadHLSTree stores every file under dir in the video store below
+// keyPrefix and returns the key of the master playlist.
+func (cfg *apiConfig) uploadHLSTree(ctx context.Context, userID uuid.UUID, dir, keyPrefix string) (string, error) {
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		key := path.Join(keyPrefix, filepath.ToSlash(rel))
+		return cfg.putObject(ctx, blobStoreVideos, userID, key, file, hlsContentType(key))
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload HLS files: %w", err)
+	}
+	return path.Join(keyPrefix, hlsMasterPlaylist), nil
+}
+
+func hlsContentType(key string) string {
+	switch path.Ext(key) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".ts":
+		return "video/mp2t"
+	}
+	return mime.TypeByExtension(path.Ext(key))
+}
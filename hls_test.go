@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectHLSRenditions(t *testing.T) {
+	ladder, err := parseHLSRenditions("1080p,720p:2500,480p,360p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ladder[1].VideoBitrate != 2500 || ladder[2].VideoBitrate != hlsDefaultBitrates[480] {
+		t.Errorf("unexpected bitrates: %+v", ladder)
+	}
+
+	testCases := []struct {
+		width, height int
+		want          []string
+	}{
+		{1920, 1080, []string{"1080p", "720p", "480p", "360p"}},
+		{1280, 720, []string{"720p", "480p", "360p"}},
+		{720, 1280, []string{"720p", "480p", "360p"}}, // portrait uses the short side
+		{320, 240, []string{"360p"}},                  // never leave the ladder empty
+	}
+	for _, tc := range testCases {
+		got := selectHLSRenditions(ladder, tc.width, tc.height)
+		if len(got) != len(tc.want) {
+			t.Errorf("%dx%d: got %+v, want %v", tc.width, tc.height, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i].Name != tc.want[i] {
+				t.Errorf("%dx%d: got %+v, want %v", tc.width, tc.height, got, tc.want)
+			}
+		}
+	}
+
+	if w, h := renditionSize(ladder[1], 1080, 1920); w != 720 || h != 1280 {
+		t.Errorf("portrait 720p rendition size = %dx%d", w, h)
+	}
+
+	if _, err := parseHLSRenditions("720"); err == nil {
+		t.Error("expected error for rendition without p suffix")
+	}
+}
+
+func TestRenditionArgs(t *testing.T) {
+	r := hlsRendition{Name: "720p", Height: 720, VideoBitrate: 2800}
+	testCases := []struct {
+		segmentType string
+		want        map[string]string
+		init        bool
+	}{
+		{hlsSegmentTypeTS, map[string]string{"-hls_segment_type": "mpegts", "-hls_segment_filename": "segment_%04d.ts"}, false},
+		{hlsSegmentTypeFMP4, map[string]string{"-hls_segment_type": "fmp4", "-hls_segment_filename": "segment_%04d.m4s", "-hls_fmp4_init_filename": "init.mp4"}, true},
+	}
+	for _, tc := range testCases {
+		h := hlsConfig{segmentType: tc.segmentType, segmentSeconds: 6}
+		args := h.renditionArgs("/tmp/video.mp4", r, 1280, 720)
+		if args[len(args)-1] != hlsMediaPlaylist {
+			t.Errorf("%s: args end in %q, want the media playlist", tc.segmentType, args[len(args)-1])
+		}
+		values := map[string]string{}
+		for i := 0; i+1 < len(args); i++ {
+			if strings.HasPrefix(args[i], "-") {
+				values[args[i]] = args[i+1]
+			}
+		}
+		for flag, want := range tc.want {
+			if values[flag] != want {
+				t.Errorf("%s: %s = %q, want %q", tc.segmentType, flag, values[flag], want)
+			}
+		}
+		if _, ok := values["-hls_fmp4_init_filename"]; ok != tc.init {
+			t.Errorf("%s: init segment set = %t, want %t", tc.segmentType, ok, tc.init)
+		}
+		if values["-vf"] != "scale=1280:720" || values["-b:v"] != "2800k" || values["-hls_time"] != "6" {
+			t.Errorf("%s: args %v", tc.segmentType, args)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to search for
+const apiKeyPrefix = "tubely_"
+
+// Scopes limit what an API key may do. Access JWTs have all of them.
+const (
+	ScopeVideosRead  = "videos:read"
+	ScopeVideosWrite = "videos:write"
+)
+
+var Scopes = []string{ScopeVideosRead, ScopeVideosWrite}
+
+// MakeAPIKey returns a new random API key.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(key), nil
+}
+
+// HashAPIKey returns what is stored in place of an API key. The keys are
+// random enough that a fast unsalted hash is safe and can be looked up.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyPrefix returns the start of a key that is kept to tell keys apart.
+func APIKeyPrefix(key string) string {
+	return key[:min(len(key), len(apiKeyPrefix)+8)]
+}
+
+// ParseScopes checks a list of scopes and removes duplicates.
+func ParseScopes(scopes []string) ([]string, error) {
+	parsed := []string{}
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !slices.Contains(Scopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(parsed, scope) {
+			parsed = append(parsed, scope)
+		}
+	}
+	return parsed, nil
+}
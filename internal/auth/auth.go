@@ -34,50 +34,83 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// accessClaims are the claims of an access JWT
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Role Role `json:"role,omitempty"`
+}
+
+// AccessToken is what a valid access JWT says about its bearer
+type AccessToken struct {
+	UserID uuid.UUID
+	Role   Role
+}
+
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	role Role,
+	keys *KeySet,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	return keys.sign(accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Role: role,
 	})
-	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+func ValidateJWT(tokenString string, keys *KeySet) (uuid.UUID, error) {
+	access, err := ParseAccessToken(tokenString, keys)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return access.UserID, nil
+}
+
+// ParseAccessToken validates an access JWT and returns its claims. Tokens
+// issued before roles existed carry none and are treated as RoleUser.
+func ParseAccessToken(tokenString string, keys *KeySet) (AccessToken, error) {
+	claimsStruct := accessClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.keyFunc,
+		jwt.WithValidMethods(keys.methods()),
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return AccessToken{}, errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return AccessToken{}, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	role := RoleUser
+	if claimsStruct.Role != "" {
+		role, err = ParseRole(string(claimsStruct.Role))
+		if err != nil {
+			return AccessToken{}, err
+		}
 	}
-	return id, nil
+	return AccessToken{UserID: id, Role: role}, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSAKeyBits is the smallest RSA key accepted for RS256
+const minRSAKeyBits = 2048
+
+// Key is a key access JWTs are signed or verified with. Asymmetric keys are
+// named by their RFC 7638 thumbprint, which goes in the kid header of the
+// tokens they sign.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// private is nil for a key that only verifies
+	private any
+	public  any
+}
+
+// NewSecretKey returns an HS256 key for a shared secret. It has no ID, as
+// tokens signed with JWT_SECRET never carried a kid.
+func NewSecretKey(secret string) Key {
+	return Key{Method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)}
+}
+
+// LoadKey reads a PEM key from a file, see ParseKey.
+func LoadKey(path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	key, err := ParseKey(data)
+	if err != nil {
+		return Key{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// ParseKey parses an RSA or Ed25519 key in PEM form. Private keys may be
+// PKCS #8 or, for RSA, PKCS #1 and can sign; public keys are PKIX and can
+// only verify.
+func ParseKey(data []byte) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, errors.New("no PEM data found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return Key{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	var key Key
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.private = parsed
+		parsed = signer.Public()
+	}
+	switch public := parsed.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < minRSAKeyBits {
+			return Key{}, fmt.Errorf("RSA keys must have at least %d bits", minRSAKeyBits)
+		}
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return Key{}, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", public)
+	}
+	key.public = parsed
+
+	thumbprint, err := json.Marshal(key.jwk().thumbprintMembers())
+	if err != nil {
+		return Key{}, err
+	}
+	sum := sha256.Sum256(thumbprint)
+	key.ID = base64.RawURLEncoding.EncodeToString(sum[:])
+	return key, nil
+}
+
+// KeySet is the key new access JWTs are signed with and every key that is
+// still accepted when verifying them. Keeping the previous signing key in
+// the set lets tokens it signed live out their lifetime after a rotation.
+type KeySet struct {
+	signing Key
+	// keys starts with the signing key
+	keys []Key
+}
+
+// NewKeySet returns a set signing with signing and also verifying with
+// verification.
+func NewKeySet(signing Key, verification ...Key) (*KeySet, error) {
+	if signing.private == nil {
+		return nil, errors.New("signing key must be a private key")
+	}
+	ks := &KeySet{signing: signing}
+	for _, key := range append([]Key{signing}, verification...) {
+		if _, ok := ks.key(key.ID); ok {
+			if key.ID == "" {
+				return nil, errors.New("only one shared secret may be used")
+			}
+			continue
+		}
+		ks.keys = append(ks.keys, key)
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) key(id string) (Key, bool) {
+	for _, key := range ks.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// sign signs claims with the signing key
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	if ks.signing.ID != "" {
+		token.Header["kid"] = ks.signing.ID
+	}
+	return token.SignedString(ks.signing.private)
+}
+
+// keyFunc picks the key a token names. A token has to use that key's own
+// algorithm, so a public key can never be mistaken for an HMAC secret.
+func (ks *KeySet) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.key(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q is for %s, not %s", kid, key.Method.Alg(), token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// methods returns the algorithms of the keys in the set
+func (ks *KeySet) methods() []string {
+	methods := []string{}
+	for _, key := range ks.keys {
+		methods = append(methods, key.Method.Alg())
+	}
+	return methods
+}
+
+// JWK is a public key in JSON Web Key form
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// thumbprintMembers returns the members RFC 7638 hashes, which
+// encoding/json writes in the lexicographic order it requires.
+func (k JWK) thumbprintMembers() map[string]string {
+	if k.KeyType == "RSA" {
+		return map[string]string{"e": k.E, "kty": k.KeyType, "n": k.N}
+	}
+	return map[string]string{"crv": k.Curve, "kty": k.KeyType, "x": k.X}
+}
+
+func (k Key) jwk() JWK {
+	jwk := JWK{ID: k.ID, Use: "sig", Algorithm: k.Method.Alg()}
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// JWKS returns the public keys in the set, for other services to verify
+// access JWTs with. A shared secret is never included.
+func (ks *KeySet) JWKS() []JWK {
+	keys := []JWK{}
+	for _, key := range ks.keys {
+		if key.Method == jwt.SigningMethodHS256 {
+			continue
+		}
+		keys = append(keys, key.jwk())
+	}
+	return keys
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func pemKey(t *testing.T, key any) []byte {
+	t.Helper()
+	var block *pem.Block
+	switch key := key.(type) {
+	case ed25519.PublicKey, *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	return pem.EncodeToMemory(block)
+}
+
+func TestParseKeyThumbprint(t *testing.T) {
+	// The example key of RFC 7638 section 3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(pemKey(t, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; key.ID != want {
+		t.Errorf("ID = %q, want %q", key.ID, want)
+	}
+	if key.Method != jwt.SigningMethodRS256 || key.private != nil {
+		t.Errorf("got a %s key, private %t", key.Method.Alg(), key.private != nil)
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func(key any) Key {
+		t.Helper()
+		parsed, err := ParseKey(pemKey(t, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	oldKey, newKey := parse(rsaKey), parse(edPrivate)
+	if newKey.ID != parse(edPublic).ID {
+		t.Error("a private key and its public key should have the same ID")
+	}
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseKey(pemKey(t, small)); err == nil {
+		t.Error("a 1024 bit RSA key should be refused")
+	}
+
+	userID := uuid.New()
+	before, err := NewKeySet(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := MakeJWT(userID, RoleUser, before, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After rotating, the old key only verifies and the new one signs
+	if _, err := NewKeySet(parse(edPublic)); err == nil {
+		t.Error("a public key shouldn't be able to sign")
+	}
+	after, err := NewKeySet(newKey, parse(&rsaKey.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := MakeJWT(userID, RoleAdmin, after, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if got, err := ValidateJWT(token, after); err != nil || got != userID {
+			t.Errorf("validating a token after rotation: got %s, %v", got, err)
+		}
+	}
+	if _, err := ValidateJWT(newToken, before); err == nil {
+		t.Error("a token signed with a key the set doesn't have should be refused")
+	}
+	if jwks := after.JWKS(); len(jwks) != 2 || jwks[0].ID != newKey.ID || jwks[0].Algorithm != "EdDSA" || jwks[1].Algorithm != "RS256" {
+		t.Errorf("JWKS = %+v", jwks)
+	}
+
+	// A token can't pick an algorithm the key it names isn't for, such as
+	// HMAC with the public key as the secret
+	claims := accessClaims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		Subject:   userID.String(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = oldKey.ID
+	forgedToken, err := forged.SignedString(pemKey(t, &rsaKey.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(forgedToken, after); err == nil {
+		t.Error("an HS256 token naming an RSA key should be refused")
+	}
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	unsignedToken, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(unsignedToken, after); err == nil {
+		t.Error("an unsigned token should be refused")
+	}
+
+	// A shared secret kept for verification still accepts its tokens,
+	// which carry no kid, but isn't published
+	secretKeys, err := NewKeySet(NewSecretKey("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretToken, err := MakeJWT(userID, RoleUser, secretKeys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(secretToken, after); err == nil {
+		t.Error("an HS256 token should be refused by a set without the secret")
+	}
+	migrating, err := NewKeySet(newKey, NewSecretKey("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(secretToken, migrating); err != nil {
+		t.Errorf("validating an HS256 token while migrating: %v", err)
+	}
+	if jwks := migrating.JWKS(); len(jwks) != 1 {
+		t.Errorf("JWKS = %+v, want only the signing key", jwks)
+	}
+}
@@ -0,0 +1,33 @@
+package auth
+
+import "fmt"
+
+// Role is what a user is allowed to do. Each role can do everything the
+// ones before it can.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// Includes reports whether the role has at least the rights of required
+func (r Role) Includes(required Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank >= roleRanks[required]
+}
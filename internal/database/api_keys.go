@@ -0,0 +1,158 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey is a long-lived credential of a user. The key itself is only
+// known when it is created.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID    uuid.UUID  `json:"user_id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	KeyHash   string     `json:"-"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+const apiKeyColumns = `
+	id,
+	created_at,
+	user_id,
+	name,
+	prefix,
+	key_hash,
+	scopes,
+	expires_at,
+	last_used_at,
+	revoked_at
+`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	key.Scopes = strings.Fields(scopes)
+	return key, err
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	var expiresAt *time.Time
+	if params.ExpiresAt != nil {
+		utc := params.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+	_, err := c.exec(
+		query,
+		id,
+		params.UserID,
+		params.Name,
+		params.Prefix,
+		params.KeyHash,
+		strings.Join(params.Scopes, " "),
+		expiresAt,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return c.GetAPIKey(id)
+}
+
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = ?`
+	key, err := scanAPIKey(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash finds the key a client presented. Revoked and expired
+// keys are returned too, it's up to the caller to turn them away.
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = ?`
+	key, err := scanAPIKey(c.queryRow(query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// GetUserAPIKeys returns every key of a user, newest first.
+func (c Client) GetUserAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = ? ORDER BY created_at DESC, name`
+	rows, err := c.query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (c Client) RevokeAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP)
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+func (c Client) TouchAPIKey(id uuid.UUID) error {
+	_, err := c.exec(`UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BlobDeletion is an outbox entry for a stored object that is no longer
+// referenced. Entries are written in the same transaction as the change that
+// orphaned the object and removed once the object is gone, so cleanup
+// survives restarts and storage outages.
+type BlobDeletion struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Attempts  int       `json:"attempts"`
+	LastError *string   `json:"error"`
+	RunAfter  time.Time `json:"run_after"`
+	CreateBlobDeletionParams
+}
+
+type CreateBlobDeletionParams struct {
+	// Store names the blob store the object lives in, e.g. "videos"
+	Store string `json:"store"`
+	// Key is an object key, or a prefix ending in "/" to delete everything
+	// under it
+	Key string `json:"key"`
+}
+
+const blobDeletionColumns = `
+	id,
+	created_at,
+	store,
+	object_key,
+	attempts,
+	last_error,
+	run_after
+`
+
+// inTx runs fn in a transaction, committing only if it returns nil.
+func (c Client) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) queueBlobDeletions(tx *sql.Tx, blobs []CreateBlobDeletionParams) error {
+	query := c.rebind(`
+	INSERT INTO blob_deletions (
+		id,
+		created_at,
+		store,
+		object_key,
+		attempts,
+		run_after
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, 0, ?)
+	`)
+	for _, blob := range blobs {
+		if _, err := tx.Exec(query, uuid.New(), blob.Store, blob.Key, time.Now().UTC()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueBlobDeletions adds objects to the outbox outside of any other change.
+func (c Client) QueueBlobDeletions(blobs ...CreateBlobDeletionParams) error {
+	return c.inTx(func(tx *sql.Tx) error {
+		return c.queueBlobDeletions(tx, blobs)
+	})
+}
+
+// GetDueBlobDeletions returns up to limit entries whose next attempt is due.
+func (c Client) GetDueBlobDeletions(now time.Time, limit int) ([]BlobDeletion, error) {
+	query := `SELECT ` + blobDeletionColumns + `
+	FROM blob_deletions
+	WHERE run_after <= ?
+	ORDER BY run_after
+	LIMIT ?
+	`
+	rows, err := c.query(query, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []BlobDeletion{}
+	for rows.Next() {
+		var d BlobDeletion
+		if err := rows.Scan(
+			&d.ID,
+			&d.CreatedAt,
+			&d.Store,
+			&d.Key,
+			&d.Attempts,
+			&d.LastError,
+			&d.RunAfter,
+		); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+// CompleteBlobDeletion removes an entry once its object has been deleted.
+func (c Client) CompleteBlobDeletion(id uuid.UUID) error {
+	_, err := c.exec(`DELETE FROM blob_deletions WHERE id = ?`, id)
+	return err
+}
+
+func (c Client) RetryBlobDeletion(id uuid.UUID, deleteErr string, runAfter time.Time) error {
+	query := `
+	UPDATE blob_deletions
+	SET
+		attempts = attempts + 1,
+		last_error = ?,
+		run_after = ?
+	WHERE id = ?
+	`
+	_, err := c.exec(query, deleteErr, runAfter.UTC(), id)
+	return err
+}
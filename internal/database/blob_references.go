@@ -0,0 +1,93 @@
+package database
+
+import "time"
+
+// BlobReferences lists every stored object the database points at, so
+// objects nothing points at any more can be found and removed. Like the
+// columns they come from, values may be full URLs for older rows.
+type BlobReferences struct {
+	// VideoKeys include archived originals
+	VideoKeys []string
+	// HLSKeys are master playlists, each standing for the whole rendition
+	// tree in its directory
+	HLSKeys []string
+	// ThumbnailKeys include every thumbnail candidate and resized variant
+	ThumbnailKeys []string
+	// DirectUploadKeys are the staged objects of direct uploads that haven't
+	// been completed yet and haven't expired by now
+	DirectUploadKeys []string
+}
+
+func (c Client) GetBlobReferences(now time.Time) (BlobReferences, error) {
+	refs := BlobReferences{}
+	queries := []struct {
+		dst   *[]string
+		query string
+	}{
+		{&refs.VideoKeys, `
+		SELECT video_key FROM videos WHERE video_key IS NOT NULL
+		UNION
+		SELECT original_key FROM videos WHERE original_key IS NOT NULL
+		`},
+		{&refs.HLSKeys, `SELECT hls_key FROM videos WHERE hls_key IS NOT NULL`},
+		{&refs.ThumbnailKeys, `
+		SELECT thumbnail_key FROM videos WHERE thumbnail_key IS NOT NULL
+		UNION
+		SELECT object_key FROM thumbnail_candidates
+		`},
+	}
+	for _, q := range queries {
+		values, err := c.queryStrings(q.query)
+		if err != nil {
+			return BlobReferences{}, err
+		}
+		*q.dst = values
+	}
+	// Uploads past their expiry are left to the sweep, and whatever they
+	// staged is an orphan
+	directUploadKeys, err := c.queryStrings(`
+	SELECT object_key FROM direct_uploads
+	WHERE completed_at IS NULL AND expires_at >= ?
+	`, now.UTC())
+	if err != nil {
+		return BlobReferences{}, err
+	}
+	refs.DirectUploadKeys = directUploadKeys
+
+	variantQuery := `
+	SELECT thumbnail_variants FROM videos WHERE thumbnail_variants IS NOT NULL
+	UNION
+	SELECT variants FROM thumbnail_candidates WHERE variants IS NOT NULL
+	`
+	rows, err := c.query(variantQuery)
+	if err != nil {
+		return BlobReferences{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var variants ThumbnailVariants
+		if err := rows.Scan(&variants); err != nil {
+			return BlobReferences{}, err
+		}
+		refs.ThumbnailKeys = append(refs.ThumbnailKeys, variants.Keys()...)
+	}
+	return refs, rows.Err()
+}
+
+func (c Client) queryStrings(query string, args ...any) ([]string, error) {
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+	}
+	return values, rows.Err()
+}
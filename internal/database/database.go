@@ -3,87 +3,172 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite"
+	dialectPostgres dialect = "postgres"
+)
+
 type Client struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
+// NewClient opens the database and applies any pending migrations.
+func NewClient(dsn string) (Client, error) {
+	c, err := OpenClient(dsn)
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	if _, err := c.MigrateUp(); err != nil {
+		return Client{}, fmt.Errorf("couldn't migrate database: %w", err)
+	}
+	return c, nil
+}
+
+// OpenClient opens the database without touching its schema. A
+// postgres:// or postgresql:// URL selects Postgres; anything else is a
+// SQLite file path, optionally prefixed with sqlite://.
+func OpenClient(dsn string) (Client, error) {
+	driver, source, d := parseDSN(dsn)
+	db, err := sql.Open(driver, source)
 	if err != nil {
 		return Client{}, err
 	}
-	return c, nil
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return Client{}, err
+	}
+	return Client{db: db, dialect: d}, nil
+}
 
+func parseDSN(dsn string) (driver, source string, d dialect) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return "postgres", dsn, dialectPostgres
+	}
+	for _, prefix := range []string{"sqlite3://", "sqlite://"} {
+		if strings.HasPrefix(dsn, prefix) {
+			return "sqlite3", strings.TrimPrefix(dsn, prefix), dialectSQLite
+		}
+	}
+	return "sqlite3", dsn, dialectSQLite
 }
 
-func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
-	if err != nil {
-		return err
+// Close releases the underlying database handle.
+func (c Client) Close() error {
+	return c.db.Close()
+}
+
+// rebind rewrites the ? placeholders the queries are written with into the
+// $1, $2, ... form Postgres expects.
+func (c Client) rebind(query string) string {
+	if c.dialect != dialectPostgres {
+		return query
 	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
+
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (c Client) exec(query string, args ...any) (sql.Result, error) {
+	return c.db.Exec(c.rebind(query), args...)
+}
+
+func (c Client) query(query string, args ...any) (*sql.Rows, error) {
+	return c.db.Query(c.rebind(query), args...)
+}
+
+func (c Client) queryRow(query string, args ...any) *sql.Row {
+	return c.db.QueryRow(c.rebind(query), args...)
+}
+
+// ensureColumn adds a column to a table created by an older version of the
+// schema, since CREATE TABLE IF NOT EXISTS leaves existing tables untouched.
+func (c *Client) ensureColumn(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
-	if err != nil {
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
 		return err
 	}
-	return nil
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
 }
 
 func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
-		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
+	if _, err := c.exec("DELETE FROM blob_deletions"); err != nil {
+		return fmt.Errorf("failed to reset table blob_deletions: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
-		return fmt.Errorf("failed to reset table users: %w", err)
+	if _, err := c.exec("DELETE FROM video_metadata"); err != nil {
+		return fmt.Errorf("failed to reset table video_metadata: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
+	if _, err := c.exec("DELETE FROM thumbnail_candidates"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnail_candidates: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM direct_uploads"); err != nil {
+		return fmt.Errorf("failed to reset table direct_uploads: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM tus_uploads"); err != nil {
+		return fmt.Errorf("failed to reset table tus_uploads: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM processing_jobs"); err != nil {
+		return fmt.Errorf("failed to reset table processing_jobs: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM stored_objects"); err != nil {
+		return fmt.Errorf("failed to reset table stored_objects: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM refresh_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.exec("DELETE FROM users"); err != nil {
+		return fmt.Errorf("failed to reset table users: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DirectUpload tracks an object a client is uploading straight to the video
+// store through presigned URLs.
+type DirectUpload struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreateDirectUploadParams
+}
+
+type CreateDirectUploadParams struct {
+	UserID    uuid.UUID `json:"user_id"`
+	VideoID   uuid.UUID `json:"video_id"`
+	ObjectKey string    `json:"object_key"`
+	// MultipartUploadID is empty for single PUT uploads
+	MultipartUploadID string    `json:"-"`
+	Size              int64     `json:"size"`
+	ContentType       string    `json:"content_type"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+const directUploadColumns = `
+	id,
+	created_at,
+	updated_at,
+	user_id,
+	video_id,
+	object_key,
+	multipart_upload_id,
+	size,
+	content_type,
+	expires_at,
+	completed_at
+`
+
+// CreateDirectUpload records an upload under id, which the caller picks so that
+// its video can point at the upload first, see StartVideoUpload.
+func (c Client) CreateDirectUpload(id uuid.UUID, params CreateDirectUploadParams) (DirectUpload, error) {
+	query := `
+	INSERT INTO direct_uploads (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		video_id,
+		object_key,
+		multipart_upload_id,
+		size,
+		content_type,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.exec(
+		query,
+		id,
+		params.UserID,
+		params.VideoID,
+		params.ObjectKey,
+		params.MultipartUploadID,
+		params.Size,
+		params.ContentType,
+		params.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return DirectUpload{}, err
+	}
+
+	return c.GetDirectUpload(id)
+}
+
+func scanDirectUpload(row interface{ Scan(...any) error }) (DirectUpload, error) {
+	var upload DirectUpload
+	err := row.Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.UserID,
+		&upload.VideoID,
+		&upload.ObjectKey,
+		&upload.MultipartUploadID,
+		&upload.Size,
+		&upload.ContentType,
+		&upload.ExpiresAt,
+		&upload.CompletedAt,
+	)
+	return upload, err
+}
+
+func (c Client) GetDirectUpload(id uuid.UUID) (DirectUpload, error) {
+	query := `SELECT ` + directUploadColumns + ` FROM direct_uploads WHERE id = ?`
+	upload, err := scanDirectUpload(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DirectUpload{}, nil
+		}
+		return DirectUpload{}, err
+	}
+	return upload, nil
+}
+
+// GetVideoDirectUploads returns every direct upload started for a video.
+func (c Client) GetVideoDirectUploads(videoID uuid.UUID) ([]DirectUpload, error) {
+	query := `SELECT ` + directUploadColumns + ` FROM direct_uploads WHERE video_id = ? ORDER BY created_at`
+	rows, err := c.query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []DirectUpload{}
+	for rows.Next() {
+		upload, err := scanDirectUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func (c Client) CompleteDirectUpload(id uuid.UUID) error {
+	query := `
+	UPDATE direct_uploads
+	SET
+		completed_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+// GetExpiredDirectUploads returns unfinished uploads whose expiry has
+// passed.
+func (c Client) GetExpiredDirectUploads(now time.Time) ([]DirectUpload, error) {
+	query := `SELECT ` + directUploadColumns + `
+	FROM direct_uploads
+	WHERE completed_at IS NULL AND expires_at < ?
+	`
+	rows, err := c.query(query, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []DirectUpload{}
+	for rows.Next() {
+		upload, err := scanDirectUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func (c Client) DeleteDirectUpload(id uuid.UUID) error {
+	query := `
+	DELETE FROM direct_uploads
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
@@ -0,0 +1,398 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateEmail is returned by MemoryStore.CreateUser, mirroring the
+// UNIQUE constraint on users.email.
+var ErrDuplicateEmail = errors.New("a user with that email already exists")
+
+// MemoryStore implements Store in memory with the same semantics as Client:
+// lookups that find nothing return zero values rather than errors, and
+// timestamps have the one-second resolution of CURRENT_TIMESTAMP.
+type MemoryStore struct {
+	mu            sync.Mutex
+	videos        map[uuid.UUID]Video
+	videoOrder    []uuid.UUID
+	users         map[uuid.UUID]User
+	refreshTokens map[string]RefreshToken
+	orphans       []CreateBlobDeletionParams
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		videos:        map[uuid.UUID]Video{},
+		users:         map[uuid.UUID]User{},
+		refreshTokens: map[string]RefreshToken{},
+	}
+}
+
+func memoryNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func (s *MemoryStore) GetVideos(userID uuid.UUID) ([]Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	videos := []Video{}
+	// Newest first, like ORDER BY created_at DESC.
+	for i := len(s.videoOrder) - 1; i >= 0; i-- {
+		video, ok := s.videos[s.videoOrder[i]]
+		if ok && video.UserID == userID {
+			videos = append(videos, video)
+		}
+	}
+	sort.SliceStable(videos, func(i, j int) bool {
+		return videos[i].CreatedAt.After(videos[j].CreatedAt)
+	})
+	return videos, nil
+}
+
+func (s *MemoryStore) CreateVideo(params CreateVideoParams) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memoryNow()
+	if params.Visibility == "" {
+		params.Visibility = VideoVisibilityPublic
+	}
+	video := Video{
+		ID:                uuid.New(),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		VideoState:        VideoState{Status: VideoStatusDraft},
+		CreateVideoParams: params,
+	}
+	s.videos[video.ID] = video
+	s.videoOrder = append(s.videoOrder, video.ID)
+	return video, nil
+}
+
+func (s *MemoryStore) GetVideo(id uuid.UUID) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.videos[id], nil
+}
+
+// UpdateVideo leaves created_at, updated_at and the status alone, as
+// Client does.
+func (s *MemoryStore) UpdateVideo(video Video, orphans ...CreateBlobDeletionParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orphans = append(s.orphans, orphans...)
+	existing, ok := s.videos[video.ID]
+	if !ok {
+		return nil
+	}
+	video.CreatedAt = existing.CreatedAt
+	video.UpdatedAt = existing.UpdatedAt
+	video.VideoState = existing.VideoState
+	s.videos[video.ID] = video
+	return nil
+}
+
+func (s *MemoryStore) SetVideoStatus(id uuid.UUID, status VideoStatus, reason string) error {
+	return s.setVideoStatus(id, status, reason, nil)
+}
+
+func (s *MemoryStore) StartVideoUpload(id, uploadID uuid.UUID) error {
+	return s.setVideoStatus(id, VideoStatusUploading, "", &uploadID)
+}
+
+func (s *MemoryStore) FailVideoUpload(id, uploadID uuid.UUID, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	video, ok := s.videos[id]
+	if !ok || video.Status != VideoStatusUploading || video.UploadID == nil || *video.UploadID != uploadID {
+		return nil
+	}
+	now := memoryNow()
+	video.Status = VideoStatusFailed
+	video.FailedAt = &now
+	video.FailureReason = &reason
+	s.videos[id] = video
+	return nil
+}
+
+func (s *MemoryStore) setVideoStatus(id uuid.UUID, status VideoStatus, reason string, uploadID *uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(videoStatusSources[status]) == 0 {
+		return fmt.Errorf("%w: can't move to %q", ErrInvalidVideoTransition, status)
+	}
+	video, ok := s.videos[id]
+	if !ok {
+		return nil
+	}
+	if !video.Status.CanTransition(status) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidVideoTransition, video.Status, status)
+	}
+
+	now := memoryNow()
+	video.Status = status
+	video.FailureReason = nil
+	switch status {
+	case VideoStatusUploading:
+		video.UploadingAt = &now
+		video.UploadID = uploadID
+	case VideoStatusProcessing:
+		video.ProcessingAt = &now
+	case VideoStatusReady:
+		video.ReadyAt = &now
+	case VideoStatusFailed:
+		video.FailedAt = &now
+		video.FailureReason = &reason
+	}
+	s.videos[id] = video
+	return nil
+}
+
+func (s *MemoryStore) DeleteVideo(id uuid.UUID, orphans ...CreateBlobDeletionParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orphans = append(s.orphans, orphans...)
+	delete(s.videos, id)
+	for i, videoID := range s.videoOrder {
+		if videoID == id {
+			s.videoOrder = append(s.videoOrder[:i], s.videoOrder[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Orphans returns the objects UpdateVideo and DeleteVideo were asked to
+// queue for deletion. MemoryStore has no outbox worker, so tests inspect
+// them here.
+func (s *MemoryStore) Orphans() []CreateBlobDeletionParams {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]CreateBlobDeletionParams(nil), s.orphans...)
+}
+
+func (s *MemoryStore) GetUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := []User{}
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].Email < users[j].Email
+	})
+	return users, nil
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, nil
+}
+
+func (s *MemoryStore) GetUserByRefreshToken(token string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.refreshTokens[token]
+	if !ok || rt.RevokedAt != nil || !rt.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	user, ok := s.users[rt.UserID]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) CreateUser(params CreateUserParams) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == params.Email {
+			return nil, ErrDuplicateEmail
+		}
+	}
+
+	now := memoryNow()
+	user := User{
+		ID:               uuid.New(),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		Role:             "user",
+		CreateUserParams: params,
+	}
+	s.users[user.ID] = user
+	return &user, nil
+}
+
+func (s *MemoryStore) GetUser(id uuid.UUID) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) SetUserRole(id uuid.UUID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	user.Role, user.UpdatedAt = role, memoryNow()
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) SetUserDisabled(id uuid.UUID, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	now := memoryNow()
+	if !disabled {
+		user.DisabledAt = nil
+	} else if user.DisabledAt == nil {
+		user.DisabledAt = &now
+	}
+	user.UpdatedAt = now
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, id)
+	return nil
+}
+
+func (s *MemoryStore) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createRefreshToken(params)
+}
+
+func (s *MemoryStore) createRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	if _, ok := s.refreshTokens[params.Token]; ok {
+		return RefreshToken{}, errors.New("refresh token already exists")
+	}
+
+	now := memoryNow()
+	params.ExpiresAt = params.ExpiresAt.UTC()
+	if params.FamilyID == "" {
+		params.FamilyID = params.Token
+	}
+	rt := RefreshToken{
+		CreateRefreshTokenParams: params,
+		CreatedAt:                now,
+		UpdatedAt:                now,
+	}
+	s.refreshTokens[rt.Token] = rt
+	return rt, nil
+}
+
+func (s *MemoryStore) RotateRefreshToken(token string, next CreateRefreshTokenParams) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.refreshTokens[token]
+	if !ok || rt.RevokedAt != nil {
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+	created, err := s.createRefreshToken(next)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	now := memoryNow()
+	rt.RevokedAt, rt.UpdatedAt, rt.ReplacedBy = &now, now, &created.Token
+	s.refreshTokens[token] = rt
+	return created, nil
+}
+
+func (s *MemoryStore) RevokeRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.refreshTokens[token]
+	if !ok {
+		return nil
+	}
+	now := memoryNow()
+	rt.RevokedAt = &now
+	s.refreshTokens[token] = rt
+	return nil
+}
+
+func (s *MemoryStore) RevokeRefreshTokenFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memoryNow()
+	for token, rt := range s.refreshTokens {
+		if rt.FamilyID == familyID && rt.RevokedAt == nil {
+			rt.RevokedAt, rt.UpdatedAt = &now, now
+			s.refreshTokens[token] = rt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memoryNow()
+	for token, rt := range s.refreshTokens {
+		if rt.UserID == userID && rt.RevokedAt == nil {
+			rt.RevokedAt, rt.UpdatedAt = &now, now
+			s.refreshTokens[token] = rt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetRefreshToken(token string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshTokens[token], nil
+}
+
+func (s *MemoryStore) DeleteRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.refreshTokens, token)
+	return nil
+}
@@ -0,0 +1,354 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// Migration is one numbered schema change, read from
+// migrations/<dialect>/<version>_<name>.up.sql and its matching .down.sql.
+// Each dialect has its own copy of every version so both stay in step.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationState reports whether a migration has been applied.
+type MigrationState struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+func loadMigrations(d dialect) ([]Migration, error) {
+	dir := "migrations/" + string(d) + "/"
+	names, err := fs.Glob(migrationFiles, dir+"*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, name := range names {
+		base := strings.TrimPrefix(name, dir)
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>.up.sql or .down.sql", base)
+		}
+		versionStr, migrationName, ok := strings.Cut(stem, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: missing name", base)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version", base)
+		}
+
+		data, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = m
+		} else if m.Name != migrationName {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, migrationName)
+		}
+		if direction == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// ensureMigrationsTable creates schema_migrations. Databases created before
+// migrations existed are brought up to the shape 0001 expects first, so that
+// its CREATE TABLE IF NOT EXISTS statements adopt them as they are.
+func (c Client) ensureMigrationsTable() error {
+	tracked, err := c.tableExists("schema_migrations")
+	if err != nil {
+		return err
+	}
+	if tracked {
+		return nil
+	}
+
+	timestampType := "TIMESTAMPTZ"
+	if c.dialect == dialectSQLite {
+		timestampType = "TIMESTAMP"
+
+		// Postgres support arrived with migrations, so only SQLite
+		// databases can predate them.
+		legacy, err := c.tableExists("videos")
+		if err != nil {
+			return err
+		}
+		if legacy {
+			if err := c.ensureColumn("videos", "hls_url", "TEXT"); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = c.exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at ` + timestampType + ` DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	return err
+}
+
+func (c Client) tableExists(table string) (bool, error) {
+	query := "SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if c.dialect == dialectPostgres {
+		query = "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	}
+
+	var name string
+	err := c.queryRow(query, table).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SchemaVersion returns the version of the latest applied migration, or 0
+// for an empty database.
+func (c Client) SchemaVersion() (int, error) {
+	if err := c.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := c.queryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// MigrationStatus lists every known migration and when it was applied.
+func (c Client) MigrationStatus() ([]MigrationState, error) {
+	migrations, err := loadMigrations(c.dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := applied[m.Version]; ok {
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// MigrateUp applies every pending migration and returns how many ran.
+func (c Client) MigrateUp() (int, error) {
+	migrations, err := loadMigrations(c.dialect)
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return c.MigrateTo(migrations[len(migrations)-1].Version)
+}
+
+// MigrateDown rolls back the latest steps migrations and returns how many ran.
+func (c Client) MigrateDown(steps int) (int, error) {
+	current, err := c.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	migrations, err := loadMigrations(c.dialect)
+	if err != nil {
+		return 0, err
+	}
+
+	target := 0
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if migrations[i].Version > current {
+			continue
+		}
+		if steps == 0 {
+			target = migrations[i].Version
+			break
+		}
+		steps--
+	}
+	return c.MigrateTo(target)
+}
+
+// migrationLockID keys the Postgres advisory lock held while migrating
+const migrationLockID = 0x7475626c79
+
+// MigrateTo applies or rolls back migrations until the schema is at version.
+// Version 0 rolls back everything. Each migration runs in its own transaction
+// together with its schema_migrations row. On Postgres, instances sharing the
+// database take turns, so ones starting together don't both apply the same
+// migration; the later ones find it applied once they get the lock.
+func (c Client) MigrateTo(version int) (count int, err error) {
+	err = c.withMigrationLock(func() error {
+		count, err = c.migrateTo(version)
+		return err
+	})
+	return count, err
+}
+
+// withMigrationLock runs fn holding a session advisory lock, on a connection
+// of its own since the pool could otherwise release it on another one.
+// SQLite databases aren't shared between instances, so there fn just runs.
+func (c Client) withMigrationLock(fn func() error) error {
+	if c.dialect != dialectPostgres {
+		return fn()
+	}
+
+	ctx := context.Background()
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("couldn't take the migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+	return fn()
+}
+
+func (c Client) migrateTo(version int) (int, error) {
+	migrations, err := loadMigrations(c.dialect)
+	if err != nil {
+		return 0, err
+	}
+	known := version == 0
+	for _, m := range migrations {
+		if m.Version == version {
+			known = true
+		}
+	}
+	if !known {
+		return 0, fmt.Errorf("unknown migration version %d", version)
+	}
+
+	current, err := c.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	if version >= current {
+		for _, m := range migrations {
+			if m.Version <= current || m.Version > version {
+				continue
+			}
+			if err := c.applyMigration(m); err != nil {
+				return count, err
+			}
+			count++
+		}
+		return count, nil
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= version {
+			continue
+		}
+		if err := c.revertMigration(m); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (c Client) applyMigration(m Migration) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.up); err != nil {
+		return fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.Exec(c.rebind("INSERT INTO schema_migrations (version, name) VALUES (?, ?)"), m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) revertMigration(m Migration) error {
+	if m.down == "" {
+		return fmt.Errorf("migration %d_%s can't be rolled back: no down file", m.Version, m.Name)
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.down); err != nil {
+		return fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.Exec(c.rebind("DELETE FROM schema_migrations WHERE version = ?"), m.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,238 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestClient(t *testing.T) Client {
+	t.Helper()
+	c, err := OpenClient(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// testDialects lists SQLite, plus Postgres when TUBELY_TEST_POSTGRES_URL
+// points at a scratch database.
+func testDialects() []dialect {
+	dialects := []dialect{dialectSQLite}
+	if os.Getenv("TUBELY_TEST_POSTGRES_URL") != "" {
+		dialects = append(dialects, dialectPostgres)
+	}
+	return dialects
+}
+
+// testClient returns an empty, unmigrated database of the given dialect.
+func testClient(t *testing.T, d dialect) Client {
+	t.Helper()
+	if d == dialectSQLite {
+		return openTestClient(t)
+	}
+
+	c, err := OpenClient(os.Getenv("TUBELY_TEST_POSTGRES_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if _, err := c.MigrateTo(0); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func latestVersion(t *testing.T, d dialect) int {
+	t.Helper()
+	migrations, err := loadMigrations(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.down == "" {
+			t.Errorf("%s migration %d_%s has no down file", d, m.Version, m.Name)
+		}
+		if m.Version != i+1 {
+			t.Errorf("%s migration versions should be contiguous, got %d at position %d", d, m.Version, i)
+		}
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	sqlite, err := loadMigrations(dialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgres, err := loadMigrations(dialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("sqlite has %d migrations, postgres has %d", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("migration %d: sqlite %d_%s, postgres %d_%s", i, sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	for _, d := range testDialects() {
+		t.Run(string(d), func(t *testing.T) {
+			testMigrateRoundTrip(t, testClient(t, d))
+		})
+	}
+}
+
+func testMigrateRoundTrip(t *testing.T, c Client) {
+	latest := latestVersion(t, c.dialect)
+
+	n, err := c.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != latest {
+		t.Errorf("applied %d migrations, want %d", n, latest)
+	}
+	if n, err := c.MigrateUp(); err != nil || n != 0 {
+		t.Errorf("second MigrateUp = %d, %v; want 0, nil", n, err)
+	}
+
+	if _, err := c.MigrateDown(1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.SchemaVersion(); v != latest-1 {
+		t.Errorf("version after down = %d, want %d", v, latest-1)
+	}
+
+	if _, err := c.MigrateTo(0); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := c.tableExists("videos"); exists {
+		t.Error("videos table should be dropped at version 0")
+	}
+
+	if _, err := c.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	states, err := c.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range states {
+		if s.AppliedAt == nil {
+			t.Errorf("migration %d_%s not applied", s.Version, s.Name)
+		}
+	}
+
+	if _, err := c.MigrateTo(latest + 1); err == nil {
+		t.Error("expected error migrating to an unknown version")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := "SELECT id FROM videos WHERE title = '?' AND user_id = ? AND id = ?"
+
+	sqlite := Client{dialect: dialectSQLite}
+	if got := sqlite.rebind(query); got != query {
+		t.Errorf("sqlite rebind changed the query: %q", got)
+	}
+
+	postgres := Client{dialect: dialectPostgres}
+	want := "SELECT id FROM videos WHERE title = '?' AND user_id = $1 AND id = $2"
+	if got := postgres.rebind(query); got != want {
+		t.Errorf("postgres rebind = %q, want %q", got, want)
+	}
+}
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		dsn     string
+		driver  string
+		source  string
+		dialect dialect
+	}{
+		{"./tubely.db", "sqlite3", "./tubely.db", dialectSQLite},
+		{"sqlite://./tubely.db", "sqlite3", "./tubely.db", dialectSQLite},
+		{"postgres://u:p@db:5432/tubely?sslmode=disable", "postgres", "postgres://u:p@db:5432/tubely?sslmode=disable", dialectPostgres},
+		{"postgresql://db/tubely", "postgres", "postgresql://db/tubely", dialectPostgres},
+	}
+	for _, tt := range tests {
+		driver, source, d := parseDSN(tt.dsn)
+		if driver != tt.driver || source != tt.source || d != tt.dialect {
+			t.Errorf("parseDSN(%q) = %q, %q, %q", tt.dsn, driver, source, d)
+		}
+	}
+}
+
+func TestMigrateAdoptsLegacySchema(t *testing.T) {
+	c := openTestClient(t)
+
+	// The videos table as the old autoMigrate created it, before hls_url.
+	_, err := c.db.Exec(`
+	CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		password TEXT NOT NULL,
+		email TEXT UNIQUE NOT NULL
+	);
+	CREATE TABLE videos (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT,
+		thumbnail_url TEXT,
+		video_url TEXT TEXT,
+		user_id INTEGER,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	INSERT INTO users (id, password, email) VALUES ('4d1a4a2e-6a3c-4e8e-9d4c-1f0c2b5e7a10', 'x', 'a@example.com');
+	INSERT INTO videos (id, title, user_id) VALUES ('9b2f7c1e-3a5d-4f6b-8c7e-2d1a0b9c8e7f', 'kept', '4d1a4a2e-6a3c-4e8e-9d4c-1f0c2b5e7a10');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	var title, userID, userIDType string
+	err = c.db.QueryRow("SELECT title, user_id, typeof(user_id) FROM videos").Scan(&title, &userID, &userIDType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title != "kept" || userID != "4d1a4a2e-6a3c-4e8e-9d4c-1f0c2b5e7a10" || userIDType != "text" {
+		t.Errorf("got video %q owned by %q (%s)", title, userID, userIDType)
+	}
+}
+
+func TestMigrateStopsOnVideosWithoutOwner(t *testing.T) {
+	c := openTestClient(t)
+	if _, err := c.MigrateTo(1); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.db.Exec(`INSERT INTO videos (id, title) VALUES ('9b2f7c1e-3a5d-4f6b-8c7e-2d1a0b9c8e7f', 'orphaned')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.MigrateUp()
+	if err == nil || !strings.Contains(err.Error(), "videos_without_user_id") {
+		t.Fatalf("MigrateUp = %v, want an error naming videos without a user_id", err)
+	}
+	if v, _ := c.SchemaVersion(); v != 1 {
+		t.Errorf("version after a failed migration = %d, want 1", v)
+	}
+	var count int
+	if err := c.db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&count); err != nil || count != 1 {
+		t.Errorf("videos after a failed migration = %d, %v, want 1", count, err)
+	}
+}
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS video_metadata;
+DROP TABLE IF EXISTS thumbnail_candidates;
+DROP TABLE IF EXISTS direct_uploads;
+DROP TABLE IF EXISTS tus_uploads;
+DROP TABLE IF EXISTS processing_jobs;
+DROP TABLE IF EXISTS videos;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,109 @@
+-- Postgres starts from the schema SQLite had when migrations were
+-- introduced, with types Postgres is strict about spelled out: user ids are
+-- TEXT to match users.id, timestamps carry a time zone because
+-- CURRENT_TIMESTAMP is otherwise in the session's zone, and byte counts are
+-- BIGINT.
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMPTZ,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	hls_url TEXT,
+	user_id TEXT,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS processing_jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	source_path TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	last_error TEXT,
+	run_after TIMESTAMPTZ NOT NULL,
+	started_at TIMESTAMPTZ,
+	finished_at TIMESTAMPTZ,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tus_uploads (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	length BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	content_type TEXT NOT NULL,
+	metadata TEXT NOT NULL,
+	staging_path TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	completed_at TIMESTAMPTZ,
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS direct_uploads (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	multipart_upload_id TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	content_type TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	completed_at TIMESTAMPTZ,
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS thumbnail_candidates (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	url TEXT NOT NULL,
+	timestamp DOUBLE PRECISION NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS video_metadata (
+	video_id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	container TEXT NOT NULL,
+	duration DOUBLE PRECISION NOT NULL,
+	bit_rate BIGINT NOT NULL,
+	size BIGINT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	streams TEXT NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
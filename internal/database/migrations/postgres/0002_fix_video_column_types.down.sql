@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS videos_user_id_idx;
+ALTER TABLE videos ALTER COLUMN user_id DROP NOT NULL;
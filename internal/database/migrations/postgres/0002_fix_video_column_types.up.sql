@@ -0,0 +1,5 @@
+-- user_id was already TEXT here; this brings the remaining constraint and
+-- index in line with the SQLite migration of the same version.
+
+ALTER TABLE videos ALTER COLUMN user_id SET NOT NULL;
+CREATE INDEX videos_user_id_idx ON videos(user_id);
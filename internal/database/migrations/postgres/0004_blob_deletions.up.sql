@@ -0,0 +1,13 @@
+-- Outbox of stored objects left behind by deleted or replaced videos.
+
+CREATE TABLE blob_deletions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	store TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	run_after TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX blob_deletions_run_after_idx ON blob_deletions(run_after);
@@ -0,0 +1,8 @@
+-- Keys written since the up migration stay keys; older servers only show
+-- them as broken URLs.
+ALTER TABLE videos DROP COLUMN visibility;
+
+ALTER TABLE thumbnail_candidates RENAME COLUMN object_key TO url;
+ALTER TABLE videos RENAME COLUMN hls_key TO hls_url;
+ALTER TABLE videos RENAME COLUMN video_key TO video_url;
+ALTER TABLE videos RENAME COLUMN thumbnail_key TO thumbnail_url;
@@ -0,0 +1,4 @@
+-- Resized JPEG and WebP renditions of a thumbnail, as a JSON array. The
+-- thumbnail key still names the largest JPEG.
+ALTER TABLE videos ADD COLUMN thumbnail_variants TEXT;
+ALTER TABLE thumbnail_candidates ADD COLUMN variants TEXT;
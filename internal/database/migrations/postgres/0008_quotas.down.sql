@@ -0,0 +1,4 @@
+DROP TABLE stored_objects;
+ALTER TABLE users DROP COLUMN max_file_size;
+ALTER TABLE users DROP COLUMN max_videos;
+ALTER TABLE users DROP COLUMN max_storage_bytes;
@@ -0,0 +1,18 @@
+-- Per-user quota overrides; NULL means the server default applies
+ALTER TABLE users ADD COLUMN max_storage_bytes BIGINT;
+ALTER TABLE users ADD COLUMN max_videos INTEGER;
+ALTER TABLE users ADD COLUMN max_file_size BIGINT;
+
+-- Every object stored on a user's behalf with its size, so storage usage is
+-- a sum rather than a walk over the blob stores. Rows are added when an
+-- object is stored and removed once it has been deleted.
+CREATE TABLE stored_objects (
+	store TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (store, object_key)
+);
+
+CREATE INDEX stored_objects_user_id_idx ON stored_objects(user_id);
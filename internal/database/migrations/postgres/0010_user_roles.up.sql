@@ -0,0 +1,3 @@
+-- user, moderator or admin. Disabled users can't log in or refresh.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMPTZ;
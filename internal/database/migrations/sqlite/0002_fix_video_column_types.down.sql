@@ -0,0 +1,19 @@
+CREATE TABLE videos_old (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT TEXT,
+	hls_url TEXT,
+	user_id INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_old (id, created_at, updated_at, title, description, thumbnail_url, video_url, hls_url, user_id)
+SELECT id, created_at, updated_at, title, description, thumbnail_url, video_url, hls_url, user_id
+FROM videos;
+
+DROP TABLE videos;
+ALTER TABLE videos_old RENAME TO videos;
@@ -0,0 +1,36 @@
+-- video_url was declared "TEXT TEXT" and user_id INTEGER although it holds
+-- the TEXT id of a user. SQLite can't alter column types, so rebuild.
+
+-- Videos without an owner can't be carried over to the NOT NULL column.
+-- Rather than dropping them, stop until they are deleted or given an owner
+-- by hand, like Postgres does when it sets NOT NULL. The failed check names
+-- the problem.
+CREATE TEMP TABLE migration_preflight (
+	videos_without_user_id INTEGER
+		CONSTRAINT videos_without_user_id_must_be_deleted_or_given_an_owner
+		CHECK (videos_without_user_id = 0)
+);
+INSERT INTO migration_preflight SELECT COUNT(*) FROM videos WHERE user_id IS NULL;
+DROP TABLE migration_preflight;
+
+CREATE TABLE videos_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	hls_url TEXT,
+	user_id TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_new (id, created_at, updated_at, title, description, thumbnail_url, video_url, hls_url, user_id)
+SELECT id, created_at, updated_at, title, description, thumbnail_url, video_url, hls_url, CAST(user_id AS TEXT)
+FROM videos;
+
+DROP TABLE videos;
+ALTER TABLE videos_new RENAME TO videos;
+
+CREATE INDEX videos_user_id_idx ON videos(user_id);
@@ -0,0 +1,6 @@
+ALTER TABLE videos DROP COLUMN failed_at;
+ALTER TABLE videos DROP COLUMN ready_at;
+ALTER TABLE videos DROP COLUMN processing_at;
+ALTER TABLE videos DROP COLUMN uploading_at;
+ALTER TABLE videos DROP COLUMN failure_reason;
+ALTER TABLE videos DROP COLUMN status;
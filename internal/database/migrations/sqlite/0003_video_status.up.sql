@@ -0,0 +1,19 @@
+ALTER TABLE videos ADD COLUMN status TEXT NOT NULL DEFAULT 'draft';
+ALTER TABLE videos ADD COLUMN failure_reason TEXT;
+ALTER TABLE videos ADD COLUMN uploading_at TIMESTAMP;
+ALTER TABLE videos ADD COLUMN processing_at TIMESTAMP;
+ALTER TABLE videos ADD COLUMN ready_at TIMESTAMP;
+ALTER TABLE videos ADD COLUMN failed_at TIMESTAMP;
+
+-- Existing videos are ready once they have a URL, or processing while a job
+-- for them is still queued or running.
+UPDATE videos
+SET status = 'ready', ready_at = updated_at
+WHERE video_url IS NOT NULL;
+
+UPDATE videos
+SET status = 'processing', processing_at = CURRENT_TIMESTAMP
+WHERE id IN (
+	SELECT video_id FROM processing_jobs
+	WHERE status IN ('queued', 'running')
+);
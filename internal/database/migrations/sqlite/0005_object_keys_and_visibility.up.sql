@@ -0,0 +1,9 @@
+-- Videos and thumbnail candidates store object keys rather than URLs, so
+-- URLs can be signed when they are served. Rows written before this still
+-- hold full URLs, which the server maps back to keys when it reads them.
+ALTER TABLE videos RENAME COLUMN thumbnail_url TO thumbnail_key;
+ALTER TABLE videos RENAME COLUMN video_url TO video_key;
+ALTER TABLE videos RENAME COLUMN hls_url TO hls_key;
+ALTER TABLE thumbnail_candidates RENAME COLUMN url TO object_key;
+
+ALTER TABLE videos ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public';
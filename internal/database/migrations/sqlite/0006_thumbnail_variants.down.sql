@@ -0,0 +1,2 @@
+ALTER TABLE thumbnail_candidates DROP COLUMN variants;
+ALTER TABLE videos DROP COLUMN thumbnail_variants;
@@ -0,0 +1,3 @@
+-- The upload as it was sent, kept when it had to be transcoded and
+-- ARCHIVE_ORIGINALS is on
+ALTER TABLE videos ADD COLUMN original_key TEXT;
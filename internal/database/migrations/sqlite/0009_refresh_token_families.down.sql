@@ -0,0 +1,3 @@
+DROP INDEX refresh_tokens_family_id_idx;
+ALTER TABLE refresh_tokens DROP COLUMN replaced_by;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
@@ -0,0 +1,9 @@
+-- Refresh tokens are rotated on every use. A family is every token that
+-- descends from one login, so replaying a rotated token can revoke them
+-- all; replaced_by points at the token that took over. Tokens from before
+-- rotation start a family of their own.
+ALTER TABLE refresh_tokens ADD COLUMN family_id TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN replaced_by TEXT;
+UPDATE refresh_tokens SET family_id = token WHERE family_id IS NULL;
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens(family_id);
@@ -0,0 +1,18 @@
+-- Long-lived credentials for machine clients. Only a SHA-256 hash of each
+-- key is kept; prefix is the start of the key, to tell keys apart in lists.
+-- scopes is a space separated list.
+CREATE TABLE api_keys (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	expires_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys(user_id);
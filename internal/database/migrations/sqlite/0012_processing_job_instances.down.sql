@@ -0,0 +1 @@
+ALTER TABLE processing_jobs DROP COLUMN instance_id;
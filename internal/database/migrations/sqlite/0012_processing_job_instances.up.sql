@@ -0,0 +1,3 @@
+-- The instance whose uploads directory holds source_path. Only it can run
+-- the job, and only it requeues the job after a restart.
+ALTER TABLE processing_jobs ADD COLUMN instance_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- The upload a video in uploading status is receiving. Only that upload can
+-- fail the video, so late failures of one it replaced leave it alone.
+ALTER TABLE videos ADD COLUMN upload_id TEXT;
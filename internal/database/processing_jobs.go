@@ -0,0 +1,249 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ProcessingStatus string
+
+const (
+	ProcessingStatusQueued  ProcessingStatus = "queued"
+	ProcessingStatusRunning ProcessingStatus = "running"
+	ProcessingStatusFailed  ProcessingStatus = "failed"
+	ProcessingStatusReady   ProcessingStatus = "ready"
+)
+
+type ProcessingJob struct {
+	ID         uuid.UUID        `json:"id"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+	Status     ProcessingStatus `json:"status"`
+	Attempts   int              `json:"attempts"`
+	LastError  *string          `json:"error"`
+	RunAfter   time.Time        `json:"run_after"`
+	StartedAt  *time.Time       `json:"started_at"`
+	FinishedAt *time.Time       `json:"finished_at"`
+	CreateProcessingJobParams
+}
+
+type CreateProcessingJobParams struct {
+	VideoID     uuid.UUID `json:"video_id"`
+	SourcePath  string    `json:"-"`
+	ContentType string    `json:"content_type"`
+	MaxAttempts int       `json:"max_attempts"`
+	// InstanceID names the server whose uploads directory holds SourcePath
+	InstanceID string `json:"-"`
+}
+
+const processingJobColumns = `
+	id,
+	created_at,
+	updated_at,
+	video_id,
+	status,
+	source_path,
+	content_type,
+	attempts,
+	max_attempts,
+	last_error,
+	run_after,
+	started_at,
+	finished_at,
+	instance_id
+`
+
+func scanProcessingJob(row interface{ Scan(...any) error }) (ProcessingJob, error) {
+	var job ProcessingJob
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.VideoID,
+		&job.Status,
+		&job.SourcePath,
+		&job.ContentType,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.RunAfter,
+		&job.StartedAt,
+		&job.FinishedAt,
+		&job.InstanceID,
+	)
+	return job, err
+}
+
+func (c Client) CreateProcessingJob(params CreateProcessingJobParams) (ProcessingJob, error) {
+	id := uuid.New()
+	// created_at is set here rather than with CURRENT_TIMESTAMP, which only
+	// has whole seconds on SQLite, so that GetLatestProcessingJob can tell
+	// apart jobs created in the same second
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO processing_jobs (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		status,
+		source_path,
+		content_type,
+		attempts,
+		max_attempts,
+		run_after,
+		instance_id
+	) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?)
+	`
+	_, err := c.exec(
+		query,
+		id,
+		now,
+		now,
+		params.VideoID,
+		ProcessingStatusQueued,
+		params.SourcePath,
+		params.ContentType,
+		params.MaxAttempts,
+		now,
+		params.InstanceID,
+	)
+	if err != nil {
+		return ProcessingJob{}, err
+	}
+
+	return c.GetProcessingJob(id)
+}
+
+func (c Client) GetProcessingJob(id uuid.UUID) (ProcessingJob, error) {
+	query := `SELECT ` + processingJobColumns + ` FROM processing_jobs WHERE id = ?`
+	job, err := scanProcessingJob(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ProcessingJob{}, nil
+		}
+		return ProcessingJob{}, err
+	}
+	return job, nil
+}
+
+// GetLatestProcessingJob returns the most recently created job for a video,
+// which is the one that describes its current processing state.
+func (c Client) GetLatestProcessingJob(videoID uuid.UUID) (ProcessingJob, error) {
+	query := `SELECT ` + processingJobColumns + `
+	FROM processing_jobs
+	WHERE video_id = ?
+	ORDER BY created_at DESC, id DESC
+	LIMIT 1
+	`
+	job, err := scanProcessingJob(c.queryRow(query, videoID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ProcessingJob{}, nil
+		}
+		return ProcessingJob{}, err
+	}
+	return job, nil
+}
+
+// ClaimProcessingJob atomically moves the oldest runnable queued job of an
+// instance to running and returns it. Jobs queued before jobs had an
+// instance can be claimed by any, and then belong to it. ok is false when
+// there is nothing to do.
+func (c Client) ClaimProcessingJob(instanceID string) (job ProcessingJob, ok bool, err error) {
+	// SQLite serialises writers, but on Postgres two workers could otherwise
+	// pick the same row in the subquery and both claim it.
+	lock := ""
+	if c.dialect == dialectPostgres {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+
+	query := `
+	UPDATE processing_jobs
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		started_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP,
+		instance_id = ?
+	WHERE id = (
+		SELECT id FROM processing_jobs
+		WHERE status = ? AND run_after <= ? AND instance_id IN (?, '')
+		ORDER BY run_after, created_at
+		LIMIT 1
+		` + lock + `
+	)
+	RETURNING ` + processingJobColumns
+
+	job, err = scanProcessingJob(c.queryRow(query, ProcessingStatusRunning, instanceID, ProcessingStatusQueued, time.Now().UTC(), instanceID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ProcessingJob{}, false, nil
+		}
+		return ProcessingJob{}, false, err
+	}
+	return job, true, nil
+}
+
+func (c Client) CompleteProcessingJob(id uuid.UUID) error {
+	query := `
+	UPDATE processing_jobs
+	SET
+		status = ?,
+		last_error = NULL,
+		finished_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, ProcessingStatusReady, id)
+	return err
+}
+
+// RetryProcessingJob records a failed attempt and puts the job back in the
+// queue to be picked up again after runAfter.
+func (c Client) RetryProcessingJob(id uuid.UUID, jobErr string, runAfter time.Time) error {
+	query := `
+	UPDATE processing_jobs
+	SET
+		status = ?,
+		last_error = ?,
+		run_after = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, ProcessingStatusQueued, jobErr, runAfter.UTC(), id)
+	return err
+}
+
+func (c Client) FailProcessingJob(id uuid.UUID, jobErr string) error {
+	query := `
+	UPDATE processing_jobs
+	SET
+		status = ?,
+		last_error = ?,
+		finished_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, ProcessingStatusFailed, jobErr, id)
+	return err
+}
+
+// RequeueRunningProcessingJobs puts jobs an instance was running when it
+// stopped back in the queue. It must only be called before the instance's
+// workers start. Other instances sharing the database may still be running
+// theirs, so those are left alone.
+func (c Client) RequeueRunningProcessingJobs(instanceID string) error {
+	query := `
+	UPDATE processing_jobs
+	SET
+		status = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = ? AND instance_id = ?
+	`
+	_, err := c.exec(query, ProcessingStatusQueued, ProcessingStatusRunning, instanceID)
+	return err
+}
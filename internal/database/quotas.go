@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// UserQuota holds a user's overrides of the default quotas. Nil fields
+// fall back to the server defaults and 0 means unlimited.
+type UserQuota struct {
+	MaxStorageBytes *int64 `json:"max_storage_bytes"`
+	MaxVideos       *int   `json:"max_videos"`
+	MaxFileSize     *int64 `json:"max_file_size"`
+}
+
+type RecordStoredObjectParams struct {
+	Store  string
+	Key    string
+	UserID uuid.UUID
+	Size   int64
+}
+
+// GetUserQuota returns the user's overrides, all nil for a user that
+// doesn't exist.
+func (c Client) GetUserQuota(userID uuid.UUID) (UserQuota, error) {
+	query := `
+	SELECT max_storage_bytes, max_videos, max_file_size
+	FROM users
+	WHERE id = ?
+	`
+	var quota UserQuota
+	err := c.queryRow(query, userID.String()).Scan(&quota.MaxStorageBytes, &quota.MaxVideos, &quota.MaxFileSize)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return UserQuota{}, err
+	}
+	return quota, nil
+}
+
+func (c Client) SetUserQuota(userID uuid.UUID, quota UserQuota) error {
+	query := `
+	UPDATE users
+	SET
+		max_storage_bytes = ?,
+		max_videos = ?,
+		max_file_size = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, quota.MaxStorageBytes, quota.MaxVideos, quota.MaxFileSize, userID.String())
+	return err
+}
+
+// RecordStoredObject charges an object to a user. Storing the same key
+// again replaces the earlier size.
+func (c Client) RecordStoredObject(params RecordStoredObjectParams) error {
+	query := `
+	INSERT INTO stored_objects (store, object_key, user_id, size, created_at)
+	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (store, object_key) DO UPDATE SET
+		user_id = excluded.user_id,
+		size = excluded.size
+	`
+	_, err := c.exec(query, params.Store, params.Key, params.UserID.String(), params.Size)
+	return err
+}
+
+// DeleteStoredObject stops charging for an object once it has been deleted.
+func (c Client) DeleteStoredObject(store, key string) error {
+	_, err := c.exec(`DELETE FROM stored_objects WHERE store = ? AND object_key = ?`, store, key)
+	return err
+}
+
+// GetStoredBytes returns the total size of the objects stored for a user.
+func (c Client) GetStoredBytes(userID uuid.UUID) (int64, error) {
+	query := `SELECT COALESCE(SUM(size), 0) FROM stored_objects WHERE user_id = ?`
+	var total int64
+	err := c.queryRow(query, userID.String()).Scan(&total)
+	return total, err
+}
@@ -2,40 +2,91 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// ErrRefreshTokenReused is returned by RotateRefreshToken when the token has
+// already been rotated or revoked.
+var ErrRefreshTokenReused = errors.New("refresh token was already used")
+
 type RefreshToken struct {
 	CreateRefreshTokenParams
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	RevokedAt *time.Time `json:"revoked_at"`
+	// ReplacedBy is the token this one was rotated to
+	ReplacedBy *string `json:"replaced_by"`
 }
 
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	// FamilyID is shared by every token rotated from the same login. Empty
+	// starts a new family named after the token.
+	FamilyID string `json:"family_id"`
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
-	query := `
+	err := c.inTx(func(tx *sql.Tx) error {
+		return c.insertRefreshToken(tx, params)
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return c.GetRefreshToken(params.Token)
+}
+
+func (c Client) insertRefreshToken(tx *sql.Tx, params CreateRefreshTokenParams) error {
+	if params.FamilyID == "" {
+		params.FamilyID = params.Token
+	}
+	query := c.rebind(`
 		INSERT INTO refresh_tokens (
 			token,
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
-	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+			expires_at,
+			family_id
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`)
+	_, err := tx.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt, params.FamilyID)
+	return err
+}
+
+// RotateRefreshToken revokes token in favour of next, which joins its
+// family. Only one rotation of a token can succeed, later ones get
+// ErrRefreshTokenReused.
+func (c Client) RotateRefreshToken(token string, next CreateRefreshTokenParams) (RefreshToken, error) {
+	err := c.inTx(func(tx *sql.Tx) error {
+		query := c.rebind(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, replaced_by = ?
+		WHERE token = ? AND revoked_at IS NULL
+		`)
+		result, err := tx.Exec(query, next.Token, token)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrRefreshTokenReused
+		}
+		return c.insertRefreshToken(tx, next)
+	})
 	if err != nil {
 		return RefreshToken{}, err
 	}
 
-	return c.GetRefreshToken(params.Token)
+	return c.GetRefreshToken(next.Token)
 }
 
 func (c Client) RevokeRefreshToken(token string) error {
@@ -44,20 +95,43 @@ func (c Client) RevokeRefreshToken(token string) error {
 		SET revoked_at = CURRENT_TIMESTAMP
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.exec(query, token)
+	return err
+}
+
+// RevokeRefreshTokenFamily revokes every token that is still live in the
+// family, ending the session it belongs to.
+func (c Client) RevokeRefreshTokenFamily(familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.exec(query, familyID)
+	return err
+}
+
+// RevokeUserRefreshTokens ends every session of a user.
+func (c Client) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.exec(query, userID.String())
 	return err
 }
 
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	err := c.queryRow(query, token).
+		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &rt.FamilyID, &rt.ReplacedBy)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -78,6 +152,6 @@ func (c Client) DeleteRefreshToken(token string) error {
 		DELETE FROM refresh_tokens
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.exec(query, token)
 	return err
 }
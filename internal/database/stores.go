@@ -0,0 +1,49 @@
+package database
+
+import "github.com/google/uuid"
+
+// VideoStore is the subset of Client that handlers use for videos, so they
+// can run against MemoryStore in tests.
+type VideoStore interface {
+	GetVideos(userID uuid.UUID) ([]Video, error)
+	CreateVideo(params CreateVideoParams) (Video, error)
+	GetVideo(id uuid.UUID) (Video, error)
+	UpdateVideo(video Video, orphans ...CreateBlobDeletionParams) error
+	DeleteVideo(id uuid.UUID, orphans ...CreateBlobDeletionParams) error
+	SetVideoStatus(id uuid.UUID, status VideoStatus, reason string) error
+	StartVideoUpload(id, uploadID uuid.UUID) error
+	FailVideoUpload(id, uploadID uuid.UUID, reason string) error
+}
+
+type UserStore interface {
+	GetUsers() ([]User, error)
+	GetUserByEmail(email string) (User, error)
+	GetUserByRefreshToken(token string) (*User, error)
+	CreateUser(params CreateUserParams) (*User, error)
+	GetUser(id uuid.UUID) (*User, error)
+	SetUserRole(id uuid.UUID, role string) error
+	SetUserDisabled(id uuid.UUID, disabled bool) error
+	DeleteUser(id uuid.UUID) error
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error)
+	RotateRefreshToken(token string, next CreateRefreshTokenParams) (RefreshToken, error)
+	RevokeRefreshToken(token string) error
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID uuid.UUID) error
+	GetRefreshToken(token string) (RefreshToken, error)
+	DeleteRefreshToken(token string) error
+}
+
+// Store is everything Client and MemoryStore have in common.
+type Store interface {
+	VideoStore
+	UserStore
+	RefreshTokenStore
+}
+
+var (
+	_ Store = Client{}
+	_ Store = (*MemoryStore)(nil)
+)
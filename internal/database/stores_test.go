@@ -0,0 +1,716 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestStoreConformance runs the same checks against every Store so that
+// MemoryStore can stand in for a real database in handler tests.
+func TestStoreConformance(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+	}
+	for _, d := range testDialects() {
+		stores[string(d)] = func(t *testing.T) Store {
+			c := testClient(t, d)
+			if _, err := c.MigrateUp(); err != nil {
+				t.Fatal(err)
+			}
+			return c
+		}
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Run("videos", func(t *testing.T) { testVideoStore(t, newStore(t)) })
+			t.Run("video status", func(t *testing.T) { testVideoStatus(t, newStore(t)) })
+			t.Run("video upload failures", func(t *testing.T) { testVideoUploadFailures(t, newStore(t)) })
+			t.Run("users", func(t *testing.T) { testUserStore(t, newStore(t)) })
+			t.Run("refresh tokens", func(t *testing.T) { testRefreshTokenStore(t, newStore(t)) })
+			t.Run("refresh token rotation", func(t *testing.T) { testRefreshTokenRotation(t, newStore(t)) })
+		})
+	}
+}
+
+func createTestUser(t *testing.T, s Store, email string) *User {
+	t.Helper()
+	user, err := s.CreateUser(CreateUserParams{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func testVideoStore(t *testing.T, s Store) {
+	owner := createTestUser(t, s, "owner@example.com")
+	other := createTestUser(t, s, "other@example.com")
+
+	video, err := s.CreateVideo(CreateVideoParams{Title: "first", Description: "d", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.ID == uuid.Nil || video.CreatedAt.IsZero() || video.Title != "first" || video.UserID != owner.ID {
+		t.Errorf("CreateVideo returned %+v", video)
+	}
+	if video.VideoKey != nil || video.ThumbnailKey != nil || video.HLSKey != nil {
+		t.Error("new video should have no objects")
+	}
+	if video.Visibility != VideoVisibilityPublic {
+		t.Errorf("new video has visibility %q, want %q", video.Visibility, VideoVisibilityPublic)
+	}
+	if _, err := s.CreateVideo(CreateVideoParams{Title: "second", UserID: owner.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateVideo(CreateVideoParams{Title: "theirs", UserID: other.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	videos, err := s.GetVideos(owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 2 {
+		t.Fatalf("GetVideos returned %d videos, want 2", len(videos))
+	}
+	for _, v := range videos {
+		if v.UserID != owner.ID {
+			t.Errorf("GetVideos returned another user's video %q", v.Title)
+		}
+	}
+	for i := 1; i < len(videos); i++ {
+		if videos[i].CreatedAt.After(videos[i-1].CreatedAt) {
+			t.Error("GetVideos should return the newest first")
+		}
+	}
+
+	key := "landscape/a.mp4"
+	originalKey := "originals/a.mov"
+	video.VideoKey, video.OriginalKey = &key, &originalKey
+	video.ThumbnailVariants = ThumbnailVariants{
+		{Key: "t-640.jpg", Width: 640, Height: 360, ContentType: "image/jpeg"},
+		{Key: "t-640.webp", Width: 640, Height: 360, ContentType: "image/webp"},
+	}
+	video.Title = "renamed"
+	video.Visibility = VideoVisibilityPrivate
+	if err := s.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "renamed" || got.VideoKey == nil || *got.VideoKey != key || got.Visibility != VideoVisibilityPrivate ||
+		got.OriginalKey == nil || *got.OriginalKey != originalKey {
+		t.Errorf("GetVideo after update returned %+v", got)
+	}
+	if len(got.ThumbnailVariants) != 2 || got.ThumbnailVariants[1] != video.ThumbnailVariants[1] {
+		t.Errorf("GetVideo returned thumbnail variants %+v, want %+v", got.ThumbnailVariants, video.ThumbnailVariants)
+	}
+	if !got.CreatedAt.Equal(video.CreatedAt) {
+		t.Errorf("UpdateVideo changed created_at from %v to %v", video.CreatedAt, got.CreatedAt)
+	}
+
+	if err := s.DeleteVideo(video.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != uuid.Nil {
+		t.Error("GetVideo of a deleted video should return the zero Video")
+	}
+	if err := s.UpdateVideo(video); err != nil {
+		t.Errorf("UpdateVideo of a missing video should be a no-op, got %v", err)
+	}
+	if err := s.DeleteVideo(video.ID); err != nil {
+		t.Errorf("DeleteVideo of a missing video should be a no-op, got %v", err)
+	}
+}
+
+func testVideoStatus(t *testing.T, s Store) {
+	owner := createTestUser(t, s, "owner@example.com")
+	video, err := s.CreateVideo(CreateVideoParams{Title: "t", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Status != VideoStatusDraft {
+		t.Errorf("new video has status %q, want %q", video.Status, VideoStatusDraft)
+	}
+
+	steps := []struct {
+		status VideoStatus
+		reason string
+		ok     bool
+	}{
+		{VideoStatusReady, "", false},
+		{VideoStatusUploading, "", true},
+		{VideoStatusProcessing, "", true},
+		{VideoStatusUploading, "", false},
+		{VideoStatusFailed, "ffmpeg exited with status 1", true},
+		{VideoStatusProcessing, "", true},
+		{VideoStatusReady, "", true},
+		{VideoStatusDraft, "", false},
+	}
+	for _, step := range steps {
+		before, err := s.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.SetVideoStatus(video.ID, step.status, step.reason)
+		if step.ok != (err == nil) {
+			t.Fatalf("%s to %s: got error %v, want ok=%v", before.Status, step.status, err, step.ok)
+		}
+		if !step.ok && !errors.Is(err, ErrInvalidVideoTransition) {
+			t.Errorf("%s to %s: got %v, want ErrInvalidVideoTransition", before.Status, step.status, err)
+		}
+
+		after, err := s.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !step.ok {
+			if after.Status != before.Status {
+				t.Errorf("rejected transition changed status to %q", after.Status)
+			}
+			continue
+		}
+		if after.Status != step.status {
+			t.Errorf("status = %q, want %q", after.Status, step.status)
+		}
+		if step.status == VideoStatusFailed {
+			if after.FailureReason == nil || *after.FailureReason != step.reason || after.FailedAt == nil {
+				t.Errorf("failed video has reason %v and failed_at %v", after.FailureReason, after.FailedAt)
+			}
+		} else if after.FailureReason != nil {
+			t.Errorf("%s video kept failure reason %q", after.Status, *after.FailureReason)
+		}
+	}
+
+	got, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UploadingAt == nil || got.ProcessingAt == nil || got.ReadyAt == nil {
+		t.Errorf("missing transition timestamps: %+v", got.VideoState)
+	}
+
+	got.Title = "renamed"
+	got.Status = VideoStatusDraft
+	if err := s.UpdateVideo(got); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetVideo(video.ID); got.Status != VideoStatusReady {
+		t.Errorf("UpdateVideo changed status to %q", got.Status)
+	}
+
+	if err := s.SetVideoStatus(uuid.New(), VideoStatusUploading, ""); err != nil {
+		t.Errorf("SetVideoStatus of a missing video should be a no-op, got %v", err)
+	}
+}
+
+func testVideoUploadFailures(t *testing.T, s Store) {
+	user := createTestUser(t, s, "a@example.com")
+	video, err := s.CreateVideo(CreateVideoParams{Title: "t", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := func() VideoStatus {
+		t.Helper()
+		got, err := s.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.Status
+	}
+
+	first, second := uuid.New(), uuid.New()
+	if err := s.StartVideoUpload(video.ID, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StartVideoUpload(video.ID, second); err != nil {
+		t.Fatal(err)
+	}
+	// The first upload failing late doesn't fail the one that replaced it
+	if err := s.FailVideoUpload(video.ID, first, "upload expired"); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); got != VideoStatusUploading {
+		t.Errorf("video is %s after a replaced upload failed, want uploading", got)
+	}
+
+	// Nor the processing of the second
+	if err := s.SetVideoStatus(video.ID, VideoStatusProcessing, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, uploadID := range []uuid.UUID{first, second} {
+		if err := s.FailVideoUpload(video.ID, uploadID, "upload expired"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := status(); got != VideoStatusProcessing {
+		t.Errorf("video is %s after an upload failed while it was processing, want processing", got)
+	}
+	if err := s.SetVideoStatus(video.ID, VideoStatusReady, ""); err != nil {
+		t.Errorf("video couldn't become ready after stale upload failures: %v", err)
+	}
+
+	// The upload a video is receiving does fail it
+	third := uuid.New()
+	if err := s.StartVideoUpload(video.ID, third); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FailVideoUpload(video.ID, third, "upload expired"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != VideoStatusFailed || got.FailureReason == nil || *got.FailureReason != "upload expired" || got.FailedAt == nil {
+		t.Errorf("video is %s (%v) after its upload failed, want failed", got.Status, got.FailureReason)
+	}
+}
+
+func testUserStore(t *testing.T, s Store) {
+	user := createTestUser(t, s, "a@example.com")
+	if user.ID == uuid.Nil || user.CreatedAt.IsZero() || user.Email != "a@example.com" || user.Password != "hash" {
+		t.Errorf("CreateUser returned %+v", user)
+	}
+	if _, err := s.CreateUser(CreateUserParams{Email: "a@example.com", Password: "x"}); err == nil {
+		t.Error("CreateUser should reject a duplicate email")
+	}
+
+	byEmail, err := s.GetUserByEmail("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byEmail.ID != user.ID {
+		t.Errorf("GetUserByEmail returned %v, want %v", byEmail.ID, user.ID)
+	}
+	byID, err := s.GetUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byID == nil || byID.Email != user.Email {
+		t.Errorf("GetUser returned %+v", byID)
+	}
+
+	users, err := s.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].ID != user.ID || users[0].Email != user.Email {
+		t.Errorf("GetUsers returned %+v", users)
+	}
+
+	if user.Role != "user" || user.DisabledAt != nil {
+		t.Errorf("a new user should be an enabled user, got role %q, disabled at %v", user.Role, user.DisabledAt)
+	}
+	if err := s.SetUserRole(user.ID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUserDisabled(user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if byID, err := s.GetUser(user.ID); err != nil || byID.Role != "admin" || byID.DisabledAt == nil {
+		t.Errorf("GetUser after changing role and disabling = %+v, %v", byID, err)
+	}
+	if err := s.SetUserDisabled(user.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if byEmail, err := s.GetUserByEmail(user.Email); err != nil || byEmail.DisabledAt != nil {
+		t.Errorf("GetUserByEmail after enabling = %+v, %v", byEmail, err)
+	}
+
+	if missing, err := s.GetUserByEmail("nobody@example.com"); err != nil || missing.ID != uuid.Nil {
+		t.Errorf("GetUserByEmail of a missing user = %+v, %v", missing, err)
+	}
+	if missing, err := s.GetUser(uuid.New()); err != nil || missing != nil {
+		t.Errorf("GetUser of a missing user = %+v, %v", missing, err)
+	}
+
+	if err := s.DeleteUser(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := s.GetUser(user.ID); err != nil || deleted != nil {
+		t.Errorf("GetUser after delete = %+v, %v", deleted, err)
+	}
+}
+
+func testRefreshTokenStore(t *testing.T, s Store) {
+	user := createTestUser(t, s, "a@example.com")
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	rt, err := s.CreateRefreshToken(CreateRefreshTokenParams{Token: "tok", UserID: user.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.Token != "tok" || rt.UserID != user.ID || !rt.ExpiresAt.Equal(expiresAt) || rt.RevokedAt != nil {
+		t.Errorf("CreateRefreshToken returned %+v", rt)
+	}
+
+	owner, err := s.GetUserByRefreshToken("tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner == nil || owner.ID != user.ID {
+		t.Errorf("GetUserByRefreshToken returned %+v", owner)
+	}
+
+	if err := s.RevokeRefreshToken("tok"); err != nil {
+		t.Fatal(err)
+	}
+	rt, err = s.GetRefreshToken("tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.RevokedAt == nil {
+		t.Error("RevokeRefreshToken should set revoked_at")
+	}
+	if owner, err := s.GetUserByRefreshToken("tok"); err != nil || owner != nil {
+		t.Errorf("GetUserByRefreshToken found the owner of a revoked token: %+v, %v", owner, err)
+	}
+
+	if _, err := s.CreateRefreshToken(CreateRefreshTokenParams{Token: "old", UserID: user.ID, ExpiresAt: expiresAt.Add(-2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := s.GetUserByRefreshToken("old"); err != nil || owner != nil {
+		t.Errorf("GetUserByRefreshToken found the owner of an expired token: %+v, %v", owner, err)
+	}
+
+	if err := s.DeleteRefreshToken("tok"); err != nil {
+		t.Fatal(err)
+	}
+	if rt, err := s.GetRefreshToken("tok"); err != nil || rt.Token != "" {
+		t.Errorf("GetRefreshToken after delete = %+v, %v", rt, err)
+	}
+	if owner, err := s.GetUserByRefreshToken("tok"); err != nil || owner != nil {
+		t.Errorf("GetUserByRefreshToken after delete = %+v, %v", owner, err)
+	}
+}
+
+func testRefreshTokenRotation(t *testing.T, s Store) {
+	user := createTestUser(t, s, "a@example.com")
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	first, err := s.CreateRefreshToken(CreateRefreshTokenParams{Token: "first", UserID: user.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.FamilyID != "first" {
+		t.Errorf("a new token should start its own family, got %q", first.FamilyID)
+	}
+
+	next := CreateRefreshTokenParams{Token: "second", UserID: user.ID, ExpiresAt: expiresAt, FamilyID: first.FamilyID}
+	second, err := s.RotateRefreshToken("first", next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Token != "second" || second.FamilyID != "first" || second.RevokedAt != nil {
+		t.Errorf("RotateRefreshToken returned %+v", second)
+	}
+	first, err = s.GetRefreshToken("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.RevokedAt == nil || first.ReplacedBy == nil || *first.ReplacedBy != "second" {
+		t.Errorf("rotated token = %+v, want it revoked and replaced by second", first)
+	}
+
+	next.Token = "third"
+	if _, err := s.RotateRefreshToken("first", next); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("rotating a token twice: got %v, want ErrRefreshTokenReused", err)
+	}
+	if rt, err := s.GetRefreshToken("third"); err != nil || rt.Token != "" {
+		t.Errorf("a failed rotation stored %+v, %v", rt, err)
+	}
+
+	other, err := s.CreateRefreshToken(CreateRefreshTokenParams{Token: "other", UserID: user.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeRefreshTokenFamily("first"); err != nil {
+		t.Fatal(err)
+	}
+	if rt, err := s.GetRefreshToken("second"); err != nil || rt.RevokedAt == nil {
+		t.Errorf("RevokeRefreshTokenFamily left %+v, %v", rt, err)
+	}
+	if rt, err := s.GetRefreshToken(other.Token); err != nil || rt.RevokedAt != nil {
+		t.Errorf("RevokeRefreshTokenFamily revoked another family: %+v, %v", rt, err)
+	}
+
+	if err := s.RevokeUserRefreshTokens(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rt, err := s.GetRefreshToken(other.Token); err != nil || rt.RevokedAt == nil {
+		t.Errorf("RevokeUserRefreshTokens left %+v, %v", rt, err)
+	}
+}
+
+func TestBlobDeletionOutbox(t *testing.T) {
+	for _, d := range testDialects() {
+		t.Run(string(d), func(t *testing.T) {
+			c := testClient(t, d)
+			if _, err := c.MigrateUp(); err != nil {
+				t.Fatal(err)
+			}
+			testBlobDeletionOutbox(t, c)
+		})
+	}
+}
+
+func testBlobDeletionOutbox(t *testing.T, c Client) {
+	owner := createTestUser(t, c, "owner@example.com")
+	video, err := c.CreateVideo(CreateVideoParams{Title: "t", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := CreateBlobDeletionParams{Store: "videos", Key: "landscape/old.mp4"}
+	if err := c.UpdateVideo(video, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateThumbnailCandidate(CreateThumbnailCandidateParams{VideoID: video.ID, Key: "u.jpg", Timestamp: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteVideo(video.ID, CreateBlobDeletionParams{Store: "videos", Key: "landscape/new/"}); err != nil {
+		t.Fatal(err)
+	}
+	if candidates, err := c.GetThumbnailCandidates(video.ID); err != nil || len(candidates) != 0 {
+		t.Errorf("DeleteVideo left thumbnail candidates behind: %v, %v", candidates, err)
+	}
+
+	due, err := c.GetDueBlobDeletions(time.Now().Add(time.Second), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 {
+		t.Fatalf("got %d due deletions, want 2", len(due))
+	}
+	keys := map[string]bool{}
+	for _, d := range due {
+		keys[d.Key] = true
+	}
+	if !keys["landscape/old.mp4"] || !keys["landscape/new/"] {
+		t.Errorf("due deletions are %+v", due)
+	}
+
+	if err := c.RetryBlobDeletion(due[0].ID, "s3 unavailable", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CompleteBlobDeletion(due[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if due, err := c.GetDueBlobDeletions(time.Now().Add(time.Second), 10); err != nil || len(due) != 0 {
+		t.Errorf("after retry and complete, due deletions = %+v, %v", due, err)
+	}
+	later, err := c.GetDueBlobDeletions(time.Now().Add(2*time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(later) != 1 || later[0].Attempts != 1 || later[0].LastError == nil {
+		t.Errorf("retried deletion is %+v", later)
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	for _, d := range testDialects() {
+		t.Run(string(d), func(t *testing.T) {
+			c := testClient(t, d)
+			if _, err := c.MigrateUp(); err != nil {
+				t.Fatal(err)
+			}
+			testQuotas(t, c)
+		})
+	}
+}
+
+func testQuotas(t *testing.T, c Client) {
+	owner := createTestUser(t, c, "owner@example.com")
+	other := createTestUser(t, c, "other@example.com")
+
+	quota, err := c.GetUserQuota(owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.MaxStorageBytes != nil || quota.MaxVideos != nil || quota.MaxFileSize != nil {
+		t.Errorf("new user has quota overrides %+v", quota)
+	}
+	storage, videos := int64(5<<30), 0
+	if err := c.SetUserQuota(owner.ID, UserQuota{MaxStorageBytes: &storage, MaxVideos: &videos}); err != nil {
+		t.Fatal(err)
+	}
+	quota, err = c.GetUserQuota(owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.MaxStorageBytes == nil || *quota.MaxStorageBytes != storage || quota.MaxVideos == nil || *quota.MaxVideos != 0 || quota.MaxFileSize != nil {
+		t.Errorf("GetUserQuota after set = %+v", quota)
+	}
+
+	for _, params := range []RecordStoredObjectParams{
+		{Store: "videos", Key: "landscape/a.mp4", UserID: owner.ID, Size: 1000},
+		{Store: "thumbnails", Key: "a-1280.jpg", UserID: owner.ID, Size: 50},
+		{Store: "videos", Key: "landscape/b.mp4", UserID: other.ID, Size: 7},
+		// Storing a key again replaces its size
+		{Store: "videos", Key: "landscape/a.mp4", UserID: owner.ID, Size: 2000},
+	} {
+		if err := c.RecordStoredObject(params); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if used, err := c.GetStoredBytes(owner.ID); err != nil || used != 2050 {
+		t.Errorf("GetStoredBytes = %d, %v, want 2050", used, err)
+	}
+	if err := c.DeleteStoredObject("videos", "landscape/a.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := c.GetStoredBytes(owner.ID); err != nil || used != 50 {
+		t.Errorf("GetStoredBytes after delete = %d, %v, want 50", used, err)
+	}
+	if used, err := c.GetStoredBytes(uuid.New()); err != nil || used != 0 {
+		t.Errorf("GetStoredBytes for unknown user = %d, %v", used, err)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	for _, d := range testDialects() {
+		t.Run(string(d), func(t *testing.T) {
+			c := testClient(t, d)
+			if _, err := c.MigrateUp(); err != nil {
+				t.Fatal(err)
+			}
+			testAPIKeys(t, c)
+		})
+	}
+}
+
+func testAPIKeys(t *testing.T, c Client) {
+	owner := createTestUser(t, c, "owner@example.com")
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	key, err := c.CreateAPIKey(CreateAPIKeyParams{
+		UserID:    owner.ID,
+		Name:      "ci",
+		Prefix:    "tubely_abcd",
+		KeyHash:   "hash",
+		Scopes:    []string{"videos:read", "videos:write"},
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID == uuid.Nil || key.Name != "ci" || len(key.Scopes) != 2 || key.ExpiresAt == nil || !key.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("CreateAPIKey returned %+v", key)
+	}
+	if _, err := c.CreateAPIKey(CreateAPIKeyParams{UserID: owner.ID, Name: "again", Prefix: "p", KeyHash: "hash"}); err == nil {
+		t.Error("CreateAPIKey should reject a duplicate hash")
+	}
+
+	byHash, err := c.GetAPIKeyByHash("hash")
+	if err != nil || byHash.ID != key.ID {
+		t.Errorf("GetAPIKeyByHash = %+v, %v", byHash, err)
+	}
+	if missing, err := c.GetAPIKeyByHash("other"); err != nil || missing.ID != uuid.Nil {
+		t.Errorf("GetAPIKeyByHash of a missing key = %+v, %v", missing, err)
+	}
+
+	if err := c.TouchAPIKey(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RevokeAPIKey(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := c.GetUserAPIKeys(owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].LastUsedAt == nil || keys[0].RevokedAt == nil {
+		t.Errorf("GetUserAPIKeys returned %+v", keys)
+	}
+}
+
+func TestProcessingJobs(t *testing.T) {
+	for _, d := range testDialects() {
+		t.Run(string(d), func(t *testing.T) {
+			c := testClient(t, d)
+			if _, err := c.MigrateUp(); err != nil {
+				t.Fatal(err)
+			}
+			testProcessingJobs(t, c)
+		})
+	}
+}
+
+func testProcessingJobs(t *testing.T, c Client) {
+	owner := createTestUser(t, c, "owner@example.com")
+	video, err := c.CreateVideo(CreateVideoParams{Title: "t", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(instanceID string) ProcessingJob {
+		t.Helper()
+		job, err := c.CreateProcessingJob(CreateProcessingJobParams{
+			VideoID:     video.ID,
+			SourcePath:  "/uploads/" + instanceID,
+			ContentType: "video/mp4",
+			MaxAttempts: 3,
+			InstanceID:  instanceID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+	claim := func(instanceID string) (ProcessingJob, bool) {
+		t.Helper()
+		job, ok, err := c.ClaimProcessingJob(instanceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job, ok
+	}
+
+	// Jobs created in the same second still come back newest first
+	first := create("a")
+	second := create("b")
+	if latest, err := c.GetLatestProcessingJob(video.ID); err != nil || latest.ID != second.ID {
+		t.Errorf("GetLatestProcessingJob = %s, %v, want %s", latest.ID, err, second.ID)
+	}
+
+	// An instance only claims the jobs whose source it holds
+	if job, ok := claim("a"); !ok || job.ID != first.ID {
+		t.Fatalf("instance a claimed %s, %t, want %s", job.ID, ok, first.ID)
+	}
+	if _, ok := claim("a"); ok {
+		t.Error("instance a shouldn't claim instance b's job")
+	}
+	if job, ok := claim("b"); !ok || job.ID != second.ID {
+		t.Fatalf("instance b claimed %s, %t, want %s", job.ID, ok, second.ID)
+	}
+
+	// A restart of a requeues a's job and leaves b's running
+	if err := c.RequeueRunningProcessingJobs("a"); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := c.GetProcessingJob(first.ID); job.Status != ProcessingStatusQueued {
+		t.Errorf("a's job is %s after a restarted, want queued", job.Status)
+	}
+	if job, _ := c.GetProcessingJob(second.ID); job.Status != ProcessingStatusRunning {
+		t.Errorf("b's job is %s after a restarted, want running", job.Status)
+	}
+
+	// Jobs from before instances were recorded go to whoever claims them
+	legacy := create("")
+	if err := c.CompleteProcessingJob(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if job, ok := claim("b"); !ok || job.ID != legacy.ID || job.InstanceID != "b" {
+		t.Errorf("instance b claimed %s of %q, %t, want %s", job.ID, job.InstanceID, ok, legacy.ID)
+	}
+}
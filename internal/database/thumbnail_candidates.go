@@ -0,0 +1,121 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ThumbnailCandidate is a frame extracted from a video that its owner can
+// pick as the thumbnail.
+type ThumbnailCandidate struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateThumbnailCandidateParams
+}
+
+type CreateThumbnailCandidateParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Key in the thumbnail store, or a full URL for candidates stored before
+	// keys were
+	Key string `json:"-"`
+	// Variants are resized renditions, Key being the largest JPEG
+	Variants ThumbnailVariants `json:"-"`
+	// Timestamp is how far into the video the frame was taken, in seconds
+	Timestamp float64 `json:"timestamp"`
+}
+
+func (c Client) CreateThumbnailCandidate(params CreateThumbnailCandidateParams) (ThumbnailCandidate, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO thumbnail_candidates (
+		id,
+		created_at,
+		video_id,
+		object_key,
+		variants,
+		timestamp
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.exec(query, id, params.VideoID, params.Key, params.Variants, params.Timestamp)
+	if err != nil {
+		return ThumbnailCandidate{}, err
+	}
+
+	return c.GetThumbnailCandidate(id)
+}
+
+func (c Client) GetThumbnailCandidate(id uuid.UUID) (ThumbnailCandidate, error) {
+	query := `
+	SELECT id, created_at, video_id, object_key, variants, timestamp
+	FROM thumbnail_candidates
+	WHERE id = ?
+	`
+	var candidate ThumbnailCandidate
+	err := c.queryRow(query, id).Scan(
+		&candidate.ID,
+		&candidate.CreatedAt,
+		&candidate.VideoID,
+		&candidate.Key,
+		&candidate.Variants,
+		&candidate.Timestamp,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ThumbnailCandidate{}, nil
+		}
+		return ThumbnailCandidate{}, err
+	}
+	return candidate, nil
+}
+
+func (c Client) GetThumbnailCandidates(videoID uuid.UUID) ([]ThumbnailCandidate, error) {
+	query := `
+	SELECT id, created_at, video_id, object_key, variants, timestamp
+	FROM thumbnail_candidates
+	WHERE video_id = ?
+	ORDER BY timestamp
+	`
+	rows, err := c.query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []ThumbnailCandidate{}
+	for rows.Next() {
+		var candidate ThumbnailCandidate
+		if err := rows.Scan(
+			&candidate.ID,
+			&candidate.CreatedAt,
+			&candidate.VideoID,
+			&candidate.Key,
+			&candidate.Variants,
+			&candidate.Timestamp,
+		); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+// UpdateThumbnailCandidateKeys points a candidate at objects stored under
+// new keys.
+func (c Client) UpdateThumbnailCandidateKeys(id uuid.UUID, key string, variants ThumbnailVariants) error {
+	_, err := c.exec("UPDATE thumbnail_candidates SET object_key = ?, variants = ? WHERE id = ?", key, variants, id)
+	return err
+}
+
+// DeleteThumbnailCandidates removes a video's candidates, queueing the
+// objects that are no longer needed for deletion in the same transaction.
+func (c Client) DeleteThumbnailCandidates(videoID uuid.UUID, orphans ...CreateBlobDeletionParams) error {
+	return c.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(c.rebind("DELETE FROM thumbnail_candidates WHERE video_id = ?"), videoID); err != nil {
+			return err
+		}
+		return c.queueBlobDeletions(tx, orphans)
+	})
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// ThumbnailVariant is one resized rendition of a thumbnail.
+type ThumbnailVariant struct {
+	Key         string `json:"key"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+}
+
+// ThumbnailVariants is stored as a JSON array. Thumbnails from before
+// variants were generated have none.
+type ThumbnailVariants []ThumbnailVariant
+
+// Keys lists the objects behind the variants.
+func (v ThumbnailVariants) Keys() []string {
+	keys := make([]string, 0, len(v))
+	for _, variant := range v {
+		keys = append(keys, variant.Key)
+	}
+	return keys
+}
+
+func (v ThumbnailVariants) Value() (driver.Value, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (v *ThumbnailVariants) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), v)
+	case []byte:
+		return json.Unmarshal(src, v)
+	}
+	return fmt.Errorf("can't scan %T into ThumbnailVariants", src)
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TusUpload tracks a resumable upload whose bytes are staged on disk.
+type TusUpload struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Offset      int64      `json:"offset"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreateTusUploadParams
+}
+
+type CreateTusUploadParams struct {
+	UserID      uuid.UUID `json:"user_id"`
+	VideoID     uuid.UUID `json:"video_id"`
+	Length      int64     `json:"length"`
+	ContentType string    `json:"content_type"`
+	Metadata    string    `json:"metadata"`
+	StagingPath string    `json:"-"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+const tusUploadColumns = `
+	id,
+	created_at,
+	updated_at,
+	user_id,
+	video_id,
+	length,
+	upload_offset,
+	content_type,
+	metadata,
+	staging_path,
+	expires_at,
+	completed_at
+`
+
+func scanTusUpload(row interface{ Scan(...any) error }) (TusUpload, error) {
+	var upload TusUpload
+	err := row.Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.UserID,
+		&upload.VideoID,
+		&upload.Length,
+		&upload.Offset,
+		&upload.ContentType,
+		&upload.Metadata,
+		&upload.StagingPath,
+		&upload.ExpiresAt,
+		&upload.CompletedAt,
+	)
+	return upload, err
+}
+
+// CreateTusUpload records an upload under id, which the caller picks so that
+// its video can point at the upload first, see StartVideoUpload.
+func (c Client) CreateTusUpload(id uuid.UUID, params CreateTusUploadParams) (TusUpload, error) {
+	query := `
+	INSERT INTO tus_uploads (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		video_id,
+		length,
+		upload_offset,
+		content_type,
+		metadata,
+		staging_path,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?, ?, ?, ?)
+	`
+	_, err := c.exec(
+		query,
+		id,
+		params.UserID,
+		params.VideoID,
+		params.Length,
+		params.ContentType,
+		params.Metadata,
+		params.StagingPath,
+		params.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return TusUpload{}, err
+	}
+
+	return c.GetTusUpload(id)
+}
+
+func (c Client) GetTusUpload(id uuid.UUID) (TusUpload, error) {
+	query := `SELECT ` + tusUploadColumns + ` FROM tus_uploads WHERE id = ?`
+	upload, err := scanTusUpload(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TusUpload{}, nil
+		}
+		return TusUpload{}, err
+	}
+	return upload, nil
+}
+
+// GetVideoTusUploads returns every tus upload started for a video.
+func (c Client) GetVideoTusUploads(videoID uuid.UUID) ([]TusUpload, error) {
+	query := `SELECT ` + tusUploadColumns + ` FROM tus_uploads WHERE video_id = ? ORDER BY created_at`
+	rows, err := c.query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []TusUpload{}
+	for rows.Next() {
+		upload, err := scanTusUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func (c Client) UpdateTusUploadOffset(id uuid.UUID, offset int64) error {
+	query := `
+	UPDATE tus_uploads
+	SET
+		upload_offset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, offset, id)
+	return err
+}
+
+func (c Client) CompleteTusUpload(id uuid.UUID) error {
+	query := `
+	UPDATE tus_uploads
+	SET
+		completed_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+// GetExpiredTusUploads returns unfinished uploads whose expiry has passed.
+func (c Client) GetExpiredTusUploads(now time.Time) ([]TusUpload, error) {
+	query := `SELECT ` + tusUploadColumns + `
+	FROM tus_uploads
+	WHERE completed_at IS NULL AND expires_at < ?
+	`
+	rows, err := c.query(query, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []TusUpload{}
+	for rows.Next() {
+		upload, err := scanTusUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func (c Client) DeleteTusUpload(id uuid.UUID) error {
+	query := `
+	DELETE FROM tus_uploads
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
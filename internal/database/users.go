@@ -12,6 +12,9 @@ type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Role is one of the roles in internal/auth, "user" unless changed
+	Role       string     `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at"`
 	CreateUserParams
 }
 
@@ -20,15 +23,35 @@ type CreateUserParams struct {
 	Password string `json:"password"`
 }
 
+const userColumns = `
+	u.id,
+	u.created_at,
+	u.updated_at,
+	u.email,
+	u.password,
+	u.role,
+	u.disabled_at
+`
+
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
+	var user User
+	var id string
+	err := row.Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Role, &user.DisabledAt)
+	if err != nil {
+		return User{}, err
+	}
+	user.ID, err = uuid.Parse(id)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// GetUsers returns every user, oldest first.
 func (c Client) GetUsers() ([]User, error) {
-	query := `
-		SELECT
-			id,
-			email
-		FROM users
-	`
+	query := `SELECT ` + userColumns + ` FROM users u ORDER BY u.created_at, u.email`
 
-	rows, err := c.db.Query(query)
+	rows, err := c.query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -36,64 +59,44 @@ func (c Client) GetUsers() ([]User, error) {
 
 	users := []User{}
 	for rows.Next() {
-		var user User
-		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
-			return nil, err
-		}
-		user.ID, err = uuid.Parse(id)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
-	query := `
-		SELECT id, created_at, updated_at, email, password
-		FROM users
-		WHERE email = ?
-	`
-	var user User
-	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.email = ?`
+	user, err := scanUser(c.queryRow(query, email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
 		}
 		return User{}, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return User{}, err
-	}
 	return user, nil
 }
 
+// GetUserByRefreshToken returns the owner of a refresh token, or nil when
+// the token is unknown, revoked or expired.
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT ` + userColumns + `
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
+		WHERE rt.token = ? AND rt.revoked_at IS NULL AND rt.expires_at > ?
 	`
-
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	user, err := scanUser(c.queryRow(query, token, time.Now().UTC()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
 
 	return &user, nil
 }
@@ -107,7 +110,7 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 		VALUES
 		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
 	`
-	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
+	_, err := c.exec(query, id.String(), params.Email, params.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -116,32 +119,51 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 }
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
-	query := `
-		SELECT id, created_at, updated_at, email, password
-		FROM users
-		WHERE id = ?
-	`
-	var user User
-	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.id = ?`
+	user, err := scanUser(c.queryRow(query, id.String()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(idStr)
-	if err != nil {
-		return nil, err
-	}
 	return &user, nil
 }
 
+func (c Client) SetUserRole(id uuid.UUID, role string) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.exec(query, role, id.String())
+	return err
+}
+
+// SetUserDisabled disables or re-enables a user. Disabling an already
+// disabled user keeps the original time.
+func (c Client) SetUserDisabled(id uuid.UUID, disabled bool) error {
+	query := `
+		UPDATE users
+		SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	if !disabled {
+		query = `
+		UPDATE users
+		SET disabled_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		`
+	}
+	_, err := c.exec(query, id.String())
+	return err
+}
+
 func (c Client) DeleteUser(id uuid.UUID) error {
 	query := `
 		DELETE FROM users
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
+	_, err := c.exec(query, id.String())
 	return err
 }
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoMetadata is what ffprobe reported about a video's stored file.
+type VideoMetadata struct {
+	VideoID   uuid.UUID `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Container string    `json:"container"`
+	// Duration is in seconds
+	Duration float64 `json:"duration"`
+	BitRate  int64   `json:"bit_rate"`
+	Size     int64   `json:"size"`
+	// Width and Height are the display size, after rotation
+	Width   int           `json:"width"`
+	Height  int           `json:"height"`
+	Streams []VideoStream `json:"streams"`
+}
+
+type VideoStream struct {
+	Index         int     `json:"index"`
+	CodecType     string  `json:"codec_type"`
+	CodecName     string  `json:"codec_name"`
+	Profile       string  `json:"profile,omitempty"`
+	PixFmt        string  `json:"pix_fmt,omitempty"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	FrameRate     float64 `json:"frame_rate,omitempty"`
+	SampleRate    int     `json:"sample_rate,omitempty"`
+	Channels      int     `json:"channels,omitempty"`
+	ChannelLayout string  `json:"channel_layout,omitempty"`
+	BitRate       int64   `json:"bit_rate,omitempty"`
+	Duration      float64 `json:"duration,omitempty"`
+	Rotation      int     `json:"rotation"`
+}
+
+// UpsertVideoMetadata stores the probe results for a video, replacing any
+// from a previous upload.
+func (c Client) UpsertVideoMetadata(metadata VideoMetadata) error {
+	streams, err := json.Marshal(metadata.Streams)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO video_metadata (
+		video_id,
+		created_at,
+		updated_at,
+		container,
+		duration,
+		bit_rate,
+		size,
+		width,
+		height,
+		streams
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (video_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		container = excluded.container,
+		duration = excluded.duration,
+		bit_rate = excluded.bit_rate,
+		size = excluded.size,
+		width = excluded.width,
+		height = excluded.height,
+		streams = excluded.streams
+	`
+	_, err = c.exec(
+		query,
+		metadata.VideoID,
+		metadata.Container,
+		metadata.Duration,
+		metadata.BitRate,
+		metadata.Size,
+		metadata.Width,
+		metadata.Height,
+		string(streams),
+	)
+	return err
+}
+
+// GetVideoMetadata returns nil when the video hasn't been probed yet.
+func (c Client) GetVideoMetadata(videoID uuid.UUID) (*VideoMetadata, error) {
+	query := `
+	SELECT
+		video_id,
+		created_at,
+		updated_at,
+		container,
+		duration,
+		bit_rate,
+		size,
+		width,
+		height,
+		streams
+	FROM video_metadata
+	WHERE video_id = ?
+	`
+	var metadata VideoMetadata
+	var streams string
+	err := c.queryRow(query, videoID).Scan(
+		&metadata.VideoID,
+		&metadata.CreatedAt,
+		&metadata.UpdatedAt,
+		&metadata.Container,
+		&metadata.Duration,
+		&metadata.BitRate,
+		&metadata.Size,
+		&metadata.Width,
+		&metadata.Height,
+		&streams,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(streams), &metadata.Streams); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type VideoStatus string
+
+const (
+	VideoStatusDraft      VideoStatus = "draft"
+	VideoStatusUploading  VideoStatus = "uploading"
+	VideoStatusProcessing VideoStatus = "processing"
+	VideoStatusReady      VideoStatus = "ready"
+	VideoStatusFailed     VideoStatus = "failed"
+)
+
+// ErrInvalidVideoTransition is returned by SetVideoStatus when the video's
+// current status can't move to the requested one.
+var ErrInvalidVideoTransition = errors.New("invalid video status transition")
+
+// videoStatusSources lists, for each status, the statuses a video may move
+// to it from. A new upload may replace a ready or failed video, or one whose
+// previous upload stalled, but never one that is still processing. The
+// upload it replaces can't fail it after that, see FailVideoUpload.
+var videoStatusSources = map[VideoStatus][]VideoStatus{
+	VideoStatusUploading: {VideoStatusDraft, VideoStatusUploading, VideoStatusReady, VideoStatusFailed},
+	// Multipart uploads arrive in a single request and skip uploading
+	VideoStatusProcessing: {VideoStatusDraft, VideoStatusUploading, VideoStatusReady, VideoStatusFailed},
+	// Direct uploads are verified and published without a processing job
+	VideoStatusReady:  {VideoStatusUploading, VideoStatusProcessing},
+	VideoStatusFailed: {VideoStatusUploading, VideoStatusProcessing},
+}
+
+// CanTransition reports whether a video in status s may move to next.
+func (s VideoStatus) CanTransition(next VideoStatus) bool {
+	for _, from := range videoStatusSources[next] {
+		if from == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s VideoStatus) timestampColumn() string {
+	switch s {
+	case VideoStatusUploading:
+		return "uploading_at"
+	case VideoStatusProcessing:
+		return "processing_at"
+	case VideoStatusReady:
+		return "ready_at"
+	case VideoStatusFailed:
+		return "failed_at"
+	}
+	return ""
+}
+
+// SetVideoStatus moves a video to status, stamping the matching *_at column.
+// reason is recorded when status is failed and cleared otherwise. The check
+// and the update happen in one statement, so concurrent callers can't both
+// make the same transition. Missing videos are ignored.
+func (c Client) SetVideoStatus(id uuid.UUID, status VideoStatus, reason string) error {
+	return c.setVideoStatus(id, status, reason, nil)
+}
+
+// StartVideoUpload moves a video to uploading for the upload uploadID, like
+// SetVideoStatus. Only that upload can fail the video from then on.
+func (c Client) StartVideoUpload(id, uploadID uuid.UUID) error {
+	return c.setVideoStatus(id, VideoStatusUploading, "", &uploadID)
+}
+
+// FailVideoUpload marks a video failed with reason because the upload
+// uploadID failed or was abandoned. A video that has moved on, to processing
+// or to a newer upload, is left alone, so a late failure of an upload can't
+// override what replaced it.
+func (c Client) FailVideoUpload(id, uploadID uuid.UUID, reason string) error {
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		failure_reason = ?,
+		failed_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ? AND upload_id = ?
+	`
+	_, err := c.exec(query, VideoStatusFailed, reason, id, VideoStatusUploading, uploadID)
+	return err
+}
+
+// setVideoStatus makes a transition, recording uploadID as the upload a
+// video moving to uploading is receiving.
+func (c Client) setVideoStatus(id uuid.UUID, status VideoStatus, reason string, uploadID *uuid.UUID) error {
+	sources := videoStatusSources[status]
+	if len(sources) == 0 {
+		return fmt.Errorf("%w: can't move to %q", ErrInvalidVideoTransition, status)
+	}
+
+	var failureReason *string
+	if status == VideoStatusFailed {
+		failureReason = &reason
+	}
+
+	set := ""
+	args := []any{status, failureReason}
+	if status == VideoStatusUploading {
+		set = "upload_id = ?,"
+		args = append(args, uploadID)
+	}
+	args = append(args, id)
+	placeholders := make([]string, len(sources))
+	for i, from := range sources {
+		placeholders[i] = "?"
+		args = append(args, from)
+	}
+
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		failure_reason = ?,
+		` + set + `
+		` + status.timestampColumn() + ` = CURRENT_TIMESTAMP
+	WHERE id = ? AND status IN (` + strings.Join(placeholders, ", ") + `)
+	`
+	result, err := c.exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	video, err := c.GetVideo(id)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s to %s", ErrInvalidVideoTransition, video.Status, status)
+}
@@ -0,0 +1,20 @@
+package database
+
+// VideoVisibility decides who can watch a video. Public and unlisted videos
+// get permanent URLs; private ones are only shown to their owner, through
+// URLs that expire.
+type VideoVisibility string
+
+const (
+	VideoVisibilityPublic   VideoVisibility = "public"
+	VideoVisibilityUnlisted VideoVisibility = "unlisted"
+	VideoVisibilityPrivate  VideoVisibility = "private"
+)
+
+func (v VideoVisibility) Valid() bool {
+	switch v {
+	case VideoVisibilityPublic, VideoVisibilityUnlisted, VideoVisibilityPrivate:
+		return true
+	}
+	return false
+}
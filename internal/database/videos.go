@@ -9,37 +9,103 @@ import (
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Keys of the video's objects in the thumbnail and video stores. Rows
+	// written before keys were stored may still hold full URLs. Clients get
+	// URLs built from these, which is why they aren't serialized.
+	ThumbnailKey *string `json:"-"`
+	// ThumbnailVariants are resized renditions of the thumbnail
+	ThumbnailVariants ThumbnailVariants `json:"-"`
+	VideoKey          *string           `json:"-"`
+	// HLSKey is the master playlist, the renditions sit next to it
+	HLSKey *string `json:"-"`
+	// OriginalKey is the upload as it was sent, archived when it had to be
+	// transcoded
+	OriginalKey *string `json:"-"`
+	VideoState
 	CreateVideoParams
 }
 
+// VideoState is only changed through SetVideoStatus; UpdateVideo leaves it
+// alone.
+type VideoState struct {
+	Status        VideoStatus `json:"status"`
+	FailureReason *string     `json:"failure_reason"`
+	UploadingAt   *time.Time  `json:"uploading_at"`
+	ProcessingAt  *time.Time  `json:"processing_at"`
+	ReadyAt       *time.Time  `json:"ready_at"`
+	FailedAt      *time.Time  `json:"failed_at"`
+	// UploadID is the upload the video last started receiving, see
+	// StartVideoUpload
+	UploadID *uuid.UUID `json:"-"`
+}
+
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	UserID      uuid.UUID       `json:"user_id"`
+	Visibility  VideoVisibility `json:"visibility"`
+}
+
+const videoColumns = `
+	id,
+	created_at,
+	updated_at,
+	title,
+	description,
+	thumbnail_key,
+	thumbnail_variants,
+	video_key,
+	hls_key,
+	original_key,
+	user_id,
+	visibility,
+	status,
+	failure_reason,
+	uploading_at,
+	processing_at,
+	ready_at,
+	failed_at,
+	upload_id
+`
+
+func scanVideo(row interface{ Scan(...any) error }) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailKey,
+		&video.ThumbnailVariants,
+		&video.VideoKey,
+		&video.HLSKey,
+		&video.OriginalKey,
+		&video.UserID,
+		&video.Visibility,
+		&video.Status,
+		&video.FailureReason,
+		&video.UploadingAt,
+		&video.ProcessingAt,
+		&video.ReadyAt,
+		&video.FailedAt,
+		&video.UploadID,
+	)
+	return video, err
 }
 
 func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT ` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
 	ORDER BY created_at DESC
 	`
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.query(query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -47,17 +113,8 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -68,6 +125,9 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	id := uuid.New()
+	if params.Visibility == "" {
+		params.Visibility = VideoVisibilityPublic
+	}
 	query := `
 	INSERT INTO videos (
 		id,
@@ -75,10 +135,11 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		visibility
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.exec(query, id, params.Title, params.Description, params.UserID, params.Visibility)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +149,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT ` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.queryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,35 +165,64 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
-	query := `
+// UpdateVideo saves video and, in the same transaction, queues objects the
+// change left unreferenced for deletion.
+func (c Client) UpdateVideo(video Video, orphans ...CreateBlobDeletionParams) error {
+	query := c.rebind(`
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
-		thumbnail_url = ?,
-		video_url = ?,
-		user_id = ?
+		thumbnail_key = ?,
+		thumbnail_variants = ?,
+		video_key = ?,
+		hls_key = ?,
+		original_key = ?,
+		user_id = ?,
+		visibility = ?
 	WHERE id = ?
-	`
-
-	_, err := c.db.Exec(
-		query,
-		video.Title,
-		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		video.UserID,
-		video.ID,
-	)
-	return err
+	`)
+
+	return c.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			query,
+			video.Title,
+			video.Description,
+			&video.ThumbnailKey,
+			video.ThumbnailVariants,
+			&video.VideoKey,
+			&video.HLSKey,
+			&video.OriginalKey,
+			video.UserID,
+			video.Visibility,
+			video.ID,
+		)
+		if err != nil {
+			return err
+		}
+		return c.queueBlobDeletions(tx, orphans)
+	})
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
-	query := `
-	DELETE FROM videos
-	WHERE id = ?
-	`
-	_, err := c.db.Exec(query, id)
-	return err
+// DeleteVideo removes the video with the rows that belong to it, queueing
+// its stored objects for deletion in the same transaction. The child rows
+// are deleted explicitly because SQLite doesn't enforce ON DELETE CASCADE.
+func (c Client) DeleteVideo(id uuid.UUID, orphans ...CreateBlobDeletionParams) error {
+	return c.inTx(func(tx *sql.Tx) error {
+		for _, table := range []string{
+			"video_metadata",
+			"thumbnail_candidates",
+			"processing_jobs",
+			"tus_uploads",
+			"direct_uploads",
+		} {
+			if _, err := tx.Exec(c.rebind("DELETE FROM "+table+" WHERE video_id = ?"), id); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(c.rebind("DELETE FROM videos WHERE id = ?"), id); err != nil {
+			return err
+		}
+		return c.queueBlobDeletions(tx, orphans)
+	})
 }
@@ -0,0 +1,139 @@
+// Package imaging decodes uploaded images and renders the resized JPEG and
+// WebP copies served to clients. Re-encoding drops any metadata the
+// original carried, EXIF included.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/chai2010/webp"
+	xdraw "golang.org/x/image/draw"
+)
+
+var ErrUnsupportedImage = errors.New("not a JPEG or PNG image")
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+// MaxPixels bounds the decoded size of an image, so a small file can't
+// expand into gigabytes of pixels.
+const MaxPixels = 40_000_000
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+// Decode decodes a JPEG or PNG from its content alone, whatever the client
+// claimed it was, and rotates JPEGs upright according to their EXIF
+// orientation. It returns the image and its format.
+func Decode(data []byte) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != FormatJPEG && format != FormatPNG) {
+		return nil, "", ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, "", ErrUnsupportedImage
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, "", ErrImageTooLarge
+	}
+
+	var img image.Image
+	switch format {
+	case FormatJPEG:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case FormatPNG:
+		img, err = png.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, "", ErrUnsupportedImage
+	}
+	if format == FormatJPEG {
+		img = orient(img, jpegOrientation(data))
+	}
+	return img, format, nil
+}
+
+// Resize scales img to width pixels wide, keeping its aspect ratio. Images
+// are never enlarged.
+func Resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if width >= bounds.Dx() {
+		return img
+	}
+	height := max(1, (bounds.Dy()*width+bounds.Dx()/2)/bounds.Dx())
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, xdraw.Src, nil)
+	return dst
+}
+
+// EncodeJPEG writes img as a JPEG, flattening any transparency onto white
+// rather than the black JPEG encoders otherwise produce.
+func EncodeJPEG(w io.Writer, img image.Image, quality int) error {
+	return jpeg.Encode(w, flatten(img), &jpeg.Options{Quality: quality})
+}
+
+// EncodeWebP writes img as a lossy WebP; quality ranges from 0 to 100.
+func EncodeWebP(w io.Writer, img image.Image, quality float32) error {
+	return webp.Encode(w, img, &webp.Options{Quality: quality})
+}
+
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+// orient undoes an EXIF orientation, returning an image that displays
+// upright without it.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	// Work on RGBA pixels directly; JPEGs are opaque, so nothing is lost to
+	// premultiplication
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	// Orientations 5 to 8 are rotated by 90 degrees, swapping the sides
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise to display
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
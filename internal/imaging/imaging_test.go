@@ -0,0 +1,151 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/chai2010/webp"
+)
+
+// testJPEG encodes a w x h image whose left half is red and right half blue,
+// with an EXIF orientation when orientation isn't 0.
+func testJPEG(t *testing.T, w, h, orientation int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if orientation == 0 {
+		return data
+	}
+
+	// Big endian TIFF with one IFD0 entry: orientation, SHORT, count 1
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tiff[18:], uint16(orientation))
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xC000 && g < 0x4000 && b < 0x4000
+}
+
+func TestDecodeAppliesOrientation(t *testing.T) {
+	img, format, err := Decode(testJPEG(t, 40, 20, 0))
+	if err != nil || format != FormatJPEG {
+		t.Fatalf("Decode returned %q, %v", format, err)
+	}
+	if img.Bounds().Dx() != 40 || !isRed(img.At(5, 10)) {
+		t.Error("image without orientation was changed")
+	}
+
+	// Orientation 6 is displayed rotated 90 degrees clockwise, which puts
+	// the red left half at the top
+	img, _, err = Decode(testJPEG(t, 40, 20, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Fatalf("oriented image is %dx%d, want 20x40", b.Dx(), b.Dy())
+	}
+	if !isRed(img.At(10, 5)) || isRed(img.At(10, 35)) {
+		t.Error("orientation 6 wasn't applied")
+	}
+
+	img, _, err = Decode(testJPEG(t, 40, 20, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isRed(img.At(5, 10)) || !isRed(img.At(35, 10)) {
+		t.Error("orientation 3 wasn't applied")
+	}
+}
+
+func TestDecodeRejectsOtherContent(t *testing.T) {
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"text":      []byte("<html>not an image</html>"),
+		"gif":       gifData.Bytes(),
+		"truncated": testJPEG(t, 40, 20, 0)[:200],
+	} {
+		if _, _, err := Decode(data); !errors.Is(err, ErrUnsupportedImage) {
+			t.Errorf("%s: Decode returned %v, want ErrUnsupportedImage", name, err)
+		}
+	}
+
+	// A PNG header claiming huge dimensions is refused before decoding
+	var huge bytes.Buffer
+	png.Encode(&huge, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := huge.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 20000)
+	binary.BigEndian.PutUint32(data[20:], 20000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	if _, _, err := Decode(data); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Decode of a 20000x20000 PNG returned %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestResizeAndEncode(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1000, 500))
+	resized := Resize(img, 320)
+	if b := resized.Bounds(); b.Dx() != 320 || b.Dy() != 160 {
+		t.Errorf("Resize to 320 gave %dx%d, want 320x160", b.Dx(), b.Dy())
+	}
+	if Resize(img, 2000).Bounds().Dx() != 1000 {
+		t.Error("Resize enlarged the image")
+	}
+
+	// Transparent pixels become white, not black, in a JPEG
+	var buf bytes.Buffer
+	if err := EncodeJPEG(&buf, resized, 90); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := decoded.At(10, 10).RGBA(); r < 0xF000 || g < 0xF000 || b < 0xF000 {
+		t.Error("transparency wasn't flattened onto white")
+	}
+
+	buf.Reset()
+	if err := EncodeWebP(&buf, resized, 80); err != nil {
+		t.Fatal(err)
+	}
+	config, err := webp.DecodeConfig(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 320 || config.Height != 160 {
+		t.Errorf("WebP is %dx%d, want 320x160", config.Width, config.Height)
+	}
+}
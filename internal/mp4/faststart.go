@@ -0,0 +1,254 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+var ErrUnsupported = errors.New("unsupported MP4 layout")
+
+// sampleTablePath lists the containers between moov and the chunk offset
+// tables; everything else inside moov is copied untouched.
+var sampleTablePath = map[string]bool{
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+}
+
+// FastStart copies an MP4 from r to w with the moov box moved in front of
+// the first mdat box, rewriting the stco/co64 chunk offsets to match. Media
+// data is streamed, only moov is held in memory. Files that are already
+// fast start are copied as is.
+func FastStart(r io.ReadSeeker, w io.Writer) error {
+	boxes, err := ReadBoxes(r)
+	if err != nil {
+		return err
+	}
+	if len(boxes) == 0 || boxes[0].Type != "ftyp" {
+		return ErrNotMP4
+	}
+
+	moovIndex, mdatIndex := -1, -1
+	for i, box := range boxes {
+		switch box.Type {
+		case "moov":
+			if moovIndex == -1 {
+				moovIndex = i
+			}
+		case "mdat":
+			if mdatIndex == -1 {
+				mdatIndex = i
+			}
+		case "moof":
+			return fmt.Errorf("%w: fragmented MP4", ErrUnsupported)
+		}
+	}
+	if moovIndex == -1 {
+		return fmt.Errorf("%w: missing moov box", ErrNotMP4)
+	}
+	if mdatIndex == -1 || moovIndex < mdatIndex {
+		return copyBoxes(r, w, boxes)
+	}
+
+	moov := boxes[moovIndex]
+	payload, err := readPayload(r, moov)
+	if err != nil {
+		return err
+	}
+
+	// Bytes between the insertion point and the old moov position move
+	// forward by the size of the new moov. Anything after the old moov moves
+	// by how much moov grew or shrank, when stco was widened to co64 or a
+	// 64-bit size header became a 32-bit one.
+	insertAt := boxes[mdatIndex].Offset
+	moovEnd := moov.Offset + moov.Size
+	rewrite := func(forceCo64 bool) ([]byte, error) {
+		return rewriteContainer("moov", payload, func(size int64) func(uint64) uint64 {
+			return func(offset uint64) uint64 {
+				switch {
+				case int64(offset) >= insertAt && int64(offset) < moov.Offset:
+					return offset + uint64(size)
+				case int64(offset) >= moovEnd:
+					return uint64(int64(offset) + size - moov.Size)
+				}
+				return offset
+			}
+		}, forceCo64)
+	}
+
+	newMoov, err := rewrite(false)
+	if errors.Is(err, errOffsetOverflow) {
+		// Shifted offsets no longer fit in 32 bits, switch every stco to co64
+		newMoov, err = rewrite(true)
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, box := range boxes {
+		if i == mdatIndex {
+			if _, err := w.Write(newMoov); err != nil {
+				return err
+			}
+		}
+		if i == moovIndex {
+			continue
+		}
+		if err := copyBoxes(r, w, []Box{box}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FastStartFile writes a fast start copy of src to dst.
+func FastStartFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), ".faststart-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if err := FastStart(in, out); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+func copyBoxes(r io.ReadSeeker, w io.Writer, boxes []Box) error {
+	for _, box := range boxes {
+		if _, err := r.Seek(box.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, box.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errOffsetOverflow = errors.New("chunk offset does not fit in 32 bits")
+
+// rewriteContainer rebuilds a container box with its chunk offset tables
+// shifted. shiftFor receives the final size of the rebuilt moov box, which
+// depends on whether stco tables were widened to co64, and returns the
+// function applied to each offset.
+func rewriteContainer(typ string, payload []byte, shiftFor func(size int64) func(uint64) uint64, forceCo64 bool) ([]byte, error) {
+	// The size of moov doesn't depend on offset values, so build it once
+	// with a no-op shift to learn its size, then again with the real shift
+	sized, err := rebuild(typ, payload, func(o uint64) uint64 { return o }, forceCo64, false)
+	if err != nil {
+		return nil, err
+	}
+	return rebuild(typ, payload, shiftFor(int64(len(sized))), forceCo64, true)
+}
+
+func rebuild(typ string, payload []byte, shift func(uint64) uint64, forceCo64, checkOverflow bool) ([]byte, error) {
+	switch {
+	case typ == "moov" || sampleTablePath[typ]:
+		boxes, err := children(payload)
+		if err != nil {
+			return nil, err
+		}
+		var body []byte
+		for _, child := range boxes {
+			if child.typ == "cmov" {
+				return nil, fmt.Errorf("%w: compressed moov", ErrUnsupported)
+			}
+			rebuilt, err := rebuild(child.typ, child.payload, shift, forceCo64, checkOverflow)
+			if err != nil {
+				return nil, err
+			}
+			body = append(body, rebuilt...)
+		}
+		return makeBox(typ, body), nil
+
+	case typ == "stco":
+		offsets, err := readOffsets(payload, 4)
+		if err != nil {
+			return nil, err
+		}
+		if forceCo64 {
+			return makeBox("co64", writeOffsets(payload[:4], offsets, shift, 8)), nil
+		}
+		for _, offset := range offsets {
+			if checkOverflow && shift(offset) > math.MaxUint32 {
+				return nil, errOffsetOverflow
+			}
+		}
+		return makeBox("stco", writeOffsets(payload[:4], offsets, shift, 4)), nil
+
+	case typ == "co64":
+		offsets, err := readOffsets(payload, 8)
+		if err != nil {
+			return nil, err
+		}
+		return makeBox("co64", writeOffsets(payload[:4], offsets, shift, 8)), nil
+	}
+
+	return makeBox(typ, payload), nil
+}
+
+func readOffsets(payload []byte, width int) ([]uint64, error) {
+	if len(payload) < 8 {
+		return nil, fmt.Errorf("truncated chunk offset box")
+	}
+	count := int(binary.BigEndian.Uint32(payload[4:8]))
+	if len(payload) < 8+count*width {
+		return nil, fmt.Errorf("truncated chunk offset box")
+	}
+	offsets := make([]uint64, count)
+	for i := range offsets {
+		entry := payload[8+i*width:]
+		if width == 4 {
+			offsets[i] = uint64(binary.BigEndian.Uint32(entry))
+		} else {
+			offsets[i] = binary.BigEndian.Uint64(entry)
+		}
+	}
+	return offsets, nil
+}
+
+func writeOffsets(versionAndFlags []byte, offsets []uint64, shift func(uint64) uint64, width int) []byte {
+	out := make([]byte, 0, 8+len(offsets)*width)
+	out = append(out, versionAndFlags...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(offsets)))
+	for _, offset := range offsets {
+		if width == 4 {
+			out = binary.BigEndian.AppendUint32(out, uint32(shift(offset)))
+		} else {
+			out = binary.BigEndian.AppendUint64(out, shift(offset))
+		}
+	}
+	return out
+}
+
+func makeBox(typ string, payload []byte) []byte {
+	if len(payload)+8 > math.MaxUint32 {
+		out := make([]byte, 16, 16+len(payload))
+		binary.BigEndian.PutUint32(out, 1)
+		copy(out[4:8], typ)
+		binary.BigEndian.PutUint64(out[8:], uint64(16+len(payload)))
+		return append(out, payload...)
+	}
+	out := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(out, uint32(8+len(payload)))
+	copy(out[4:8], typ)
+	return append(out, payload...)
+}
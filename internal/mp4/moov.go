@@ -0,0 +1,194 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// childBox is a box inside an in-memory payload
+type childBox struct {
+	typ     string
+	payload []byte
+}
+
+// children splits a container payload into its child boxes.
+func children(data []byte) ([]childBox, error) {
+	boxes := []childBox{}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("truncated box header")
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid size %d for box %q", size, typ)
+		}
+		boxes = append(boxes, childBox{typ: typ, payload: data[headerSize:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+func findChild(boxes []childBox, typ string) (childBox, bool) {
+	for _, box := range boxes {
+		if box.typ == typ {
+			return box, true
+		}
+	}
+	return childBox{}, false
+}
+
+// findPath descends through nested containers, e.g. "mdia", "minf", "stbl".
+func findPath(data []byte, path ...string) ([]byte, bool) {
+	for _, typ := range path {
+		boxes, err := children(data)
+		if err != nil {
+			return nil, false
+		}
+		box, ok := findChild(boxes, typ)
+		if !ok {
+			return nil, false
+		}
+		data = box.payload
+	}
+	return data, true
+}
+
+func toDuration(units uint64, timescale uint32) time.Duration {
+	if timescale == 0 {
+		return 0
+	}
+	seconds := units / uint64(timescale)
+	rest := units % uint64(timescale)
+	return time.Duration(seconds)*time.Second + time.Duration(rest)*time.Second/time.Duration(timescale)
+}
+
+// parseTimes reads the timescale and duration shared by the layout of
+// mvhd and mdhd.
+func parseTimes(data []byte) (uint32, uint64, error) {
+	if len(data) < 4 {
+		return 0, 0, fmt.Errorf("truncated header box")
+	}
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return 0, 0, fmt.Errorf("truncated header box")
+		}
+		return binary.BigEndian.Uint32(data[20:24]), binary.BigEndian.Uint64(data[24:32]), nil
+	}
+	if len(data) < 20 {
+		return 0, 0, fmt.Errorf("truncated header box")
+	}
+	return binary.BigEndian.Uint32(data[12:16]), uint64(binary.BigEndian.Uint32(data[16:20])), nil
+}
+
+func (info *Info) parseMoov(data []byte) error {
+	boxes, err := children(data)
+	if err != nil {
+		return fmt.Errorf("invalid moov box: %w", err)
+	}
+
+	mvhd, ok := findChild(boxes, "mvhd")
+	if !ok {
+		return fmt.Errorf("moov box has no mvhd")
+	}
+	timescale, duration, err := parseTimes(mvhd.payload)
+	if err != nil {
+		return err
+	}
+	info.Timescale = timescale
+	info.Duration = toDuration(duration, timescale)
+
+	for _, box := range boxes {
+		if box.typ != "trak" {
+			continue
+		}
+		track, err := parseTrak(box.payload)
+		if err != nil {
+			return err
+		}
+		info.Tracks = append(info.Tracks, track)
+	}
+	return nil
+}
+
+func parseTrak(data []byte) (Track, error) {
+	track := Track{}
+
+	if tkhd, ok := findPath(data, "tkhd"); ok && len(tkhd) >= 4 {
+		// Width and height are 16.16 fixed point at the end of tkhd
+		idOffset, sizeOffset := 12, 76
+		if tkhd[0] == 1 {
+			idOffset, sizeOffset = 20, 88
+		}
+		if len(tkhd) >= idOffset+4 {
+			track.ID = binary.BigEndian.Uint32(tkhd[idOffset:])
+		}
+		if len(tkhd) >= sizeOffset+8 {
+			track.Width = int(binary.BigEndian.Uint32(tkhd[sizeOffset:]) >> 16)
+			track.Height = int(binary.BigEndian.Uint32(tkhd[sizeOffset+4:]) >> 16)
+		}
+	}
+
+	if mdhd, ok := findPath(data, "mdia", "mdhd"); ok {
+		timescale, duration, err := parseTimes(mdhd)
+		if err != nil {
+			return Track{}, err
+		}
+		track.Timescale = timescale
+		track.Duration = toDuration(duration, timescale)
+	}
+
+	if hdlr, ok := findPath(data, "mdia", "hdlr"); ok && len(hdlr) >= 12 {
+		track.Handler = string(hdlr[8:12])
+	}
+
+	if stsd, ok := findPath(data, "mdia", "minf", "stbl", "stsd"); ok && len(stsd) >= 8 {
+		entries, err := children(stsd[8:])
+		if err == nil && len(entries) > 0 {
+			entry := entries[0]
+			track.Codec = entry.typ
+			if track.Handler == "vide" {
+				parseVisualSampleEntry(&track, entry)
+			}
+		}
+	}
+
+	return track, nil
+}
+
+// visualSampleEntrySize is the fixed part of a VisualSampleEntry before its
+// child boxes such as avcC
+const visualSampleEntrySize = 78
+
+func parseVisualSampleEntry(track *Track, entry childBox) {
+	if len(entry.payload) < visualSampleEntrySize {
+		return
+	}
+	// Prefer the coded size over the tkhd presentation size
+	width := int(binary.BigEndian.Uint16(entry.payload[24:26]))
+	height := int(binary.BigEndian.Uint16(entry.payload[26:28]))
+	if width > 0 && height > 0 {
+		track.Width, track.Height = width, height
+	}
+
+	boxes, err := children(entry.payload[visualSampleEntrySize:])
+	if err != nil {
+		return
+	}
+	// RFC 6381 codec string, e.g. avc1.64001f
+	if avcC, ok := findChild(boxes, "avcC"); ok && len(avcC.payload) >= 4 {
+		track.Codec = fmt.Sprintf("%s.%02x%02x%02x", entry.typ, avcC.payload[1], avcC.payload[2], avcC.payload[3])
+	}
+}
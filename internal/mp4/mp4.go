@@ -0,0 +1,179 @@
+// Package mp4 walks the box structure of ISO base media (MP4) files.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var ErrNotMP4 = errors.New("not an MP4 file")
+
+// maxMoovSize bounds how much of a file is read into memory to parse moov
+const maxMoovSize = 64 << 20
+
+// Box is the header of a box and where it sits in the file.
+type Box struct {
+	Type       string `json:"type"`
+	Offset     int64  `json:"offset"`
+	Size       int64  `json:"size"`
+	HeaderSize int64  `json:"-"`
+}
+
+type Track struct {
+	ID        uint32        `json:"id"`
+	Handler   string        `json:"handler"`
+	Codec     string        `json:"codec"`
+	Width     int           `json:"width,omitempty"`
+	Height    int           `json:"height,omitempty"`
+	Timescale uint32        `json:"timescale"`
+	Duration  time.Duration `json:"duration"`
+}
+
+type Info struct {
+	MajorBrand string        `json:"major_brand"`
+	Boxes      []Box         `json:"boxes"`
+	Timescale  uint32        `json:"timescale"`
+	Duration   time.Duration `json:"duration"`
+	Tracks     []Track       `json:"tracks"`
+}
+
+// BoxOrder returns the types of the top level boxes in file order.
+func (info *Info) BoxOrder() []string {
+	order := make([]string, 0, len(info.Boxes))
+	for _, box := range info.Boxes {
+		order = append(order, box.Type)
+	}
+	return order
+}
+
+// IsFastStart reports whether moov comes before the first mdat, so players
+// can start before the whole file has downloaded.
+func (info *Info) IsFastStart() bool {
+	for _, box := range info.Boxes {
+		switch box.Type {
+		case "moov":
+			return true
+		case "mdat":
+			return false
+		}
+	}
+	return false
+}
+
+// ReadBoxes returns the top level boxes of r.
+func ReadBoxes(r io.ReadSeeker) ([]Box, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	boxes := []Box{}
+	var offset int64
+	for offset < end {
+		box, err := readBoxHeader(r, offset, end)
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, box)
+		offset += box.Size
+	}
+	return boxes, nil
+}
+
+func readBoxHeader(r io.ReadSeeker, offset, end int64) (Box, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return Box{}, err
+	}
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:8]); err != nil {
+		return Box{}, fmt.Errorf("truncated box header at offset %d: %w", offset, err)
+	}
+
+	box := Box{
+		Type:       string(header[4:8]),
+		Offset:     offset,
+		Size:       int64(binary.BigEndian.Uint32(header[:4])),
+		HeaderSize: 8,
+	}
+	switch box.Size {
+	case 0:
+		// The box extends to the end of the file
+		box.Size = end - offset
+	case 1:
+		if _, err := io.ReadFull(r, header[8:16]); err != nil {
+			return Box{}, fmt.Errorf("truncated box header at offset %d: %w", offset, err)
+		}
+		box.Size = int64(binary.BigEndian.Uint64(header[8:16]))
+		box.HeaderSize = 16
+	}
+	if box.Size < box.HeaderSize || offset+box.Size > end {
+		return Box{}, fmt.Errorf("invalid size %d for box %q at offset %d", box.Size, box.Type, offset)
+	}
+	return box, nil
+}
+
+// Parse reads the box layout and the movie metadata of an MP4 file.
+func Parse(r io.ReadSeeker) (*Info, error) {
+	boxes, err := ReadBoxes(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(boxes) == 0 || (boxes[0].Type != "ftyp" && boxes[0].Type != "styp") {
+		return nil, ErrNotMP4
+	}
+
+	info := &Info{Boxes: boxes}
+	for _, box := range boxes {
+		switch box.Type {
+		case "ftyp":
+			data, err := readPayload(r, box)
+			if err != nil {
+				return nil, err
+			}
+			if len(data) >= 4 {
+				info.MajorBrand = string(data[:4])
+			}
+		case "moov":
+			data, err := readPayload(r, box)
+			if err != nil {
+				return nil, err
+			}
+			if err := info.parseMoov(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if info.Timescale == 0 {
+		return nil, fmt.Errorf("%w: missing moov box", ErrNotMP4)
+	}
+	return info, nil
+}
+
+// ParseFile is Parse for a file on disk.
+func ParseFile(path string) (*Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+func readPayload(r io.ReadSeeker, box Box) ([]byte, error) {
+	size := box.Size - box.HeaderSize
+	if size > maxMoovSize {
+		return nil, fmt.Errorf("box %q is too large to parse (%d bytes)", box.Type, size)
+	}
+	if _, err := r.Seek(box.Offset+box.HeaderSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,317 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+func box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(out, uint32(8+len(body)))
+	copy(out[4:], typ)
+	return append(out, body...)
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func u16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+// testMoov builds a moov with one 1280x720 avc1 track lasting 10 seconds.
+func testMoov() []byte {
+	mvhd := box("mvhd", u32(0), u32(0), u32(0), u32(1000), u32(10000), make([]byte, 80))
+	tkhd := box("tkhd", u32(0), u32(0), u32(0), u32(1), u32(0), u32(10000), make([]byte, 52), u32(1280<<16), u32(720<<16))
+	mdhd := box("mdhd", u32(0), u32(0), u32(0), u32(90000), u32(900000), u32(0))
+	hdlr := box("hdlr", u32(0), u32(0), []byte("vide"), make([]byte, 13))
+	avcC := box("avcC", []byte{1, 0x64, 0x00, 0x1f, 0xff})
+	avc1 := box("avc1", make([]byte, 24), u16(1280), u16(720), make([]byte, 50), avcC)
+	stsd := box("stsd", u32(0), u32(1), avc1)
+	stbl := box("stbl", stsd, box("stco", u32(0), u32(1), u32(0)))
+	trak := box("trak", tkhd, box("mdia", mdhd, hdlr, box("minf", stbl)))
+	return box("moov", mvhd, trak)
+}
+
+func TestParse(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom"), u32(512), []byte("isomiso2avc1mp41"))
+	mdat := box("mdat", []byte("moov moov moov"))
+
+	info, err := Parse(bytes.NewReader(bytes.Join([][]byte{ftyp, testMoov(), mdat}, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.BoxOrder(); len(got) != 3 || got[0] != "ftyp" || got[1] != "moov" || got[2] != "mdat" {
+		t.Errorf("BoxOrder = %v", got)
+	}
+	if !info.IsFastStart() {
+		t.Error("expected fast start")
+	}
+	if info.MajorBrand != "isom" || info.Timescale != 1000 || info.Duration != 10*time.Second {
+		t.Errorf("unexpected movie header: %+v", info)
+	}
+	if len(info.Tracks) != 1 {
+		t.Fatalf("Tracks = %+v", info.Tracks)
+	}
+	track := info.Tracks[0]
+	if track.ID != 1 || track.Handler != "vide" || track.Codec != "avc1.64001f" ||
+		track.Width != 1280 || track.Height != 720 || track.Timescale != 90000 || track.Duration != 10*time.Second {
+		t.Errorf("Track = %+v", track)
+	}
+
+	// The same file with moov at the end still parses but isn't fast start
+	info, err = Parse(bytes.NewReader(bytes.Join([][]byte{ftyp, mdat, testMoov()}, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.IsFastStart() {
+		t.Error("expected moov after mdat not to be fast start")
+	}
+}
+
+func TestParseRejectsNonMP4(t *testing.T) {
+	testCases := map[string][]byte{
+		"text that mentions moov": []byte("this is not a video but it says moov"),
+		"no moov":                 bytes.Join([][]byte{box("ftyp", []byte("isom"), u32(0)), box("mdat", []byte("x"))}, nil),
+		"truncated box":           box("ftyp", []byte("isom"))[:10],
+	}
+	for name, data := range testCases {
+		if _, err := Parse(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestFastStart(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom"), u32(512))
+	// Two chunks whose contents we can find again after the rewrite
+	mdat := box("mdat", []byte("AAAABBBB"))
+	chunkA := uint64(len(ftyp) + 8)
+	chunkB := chunkA + 4
+
+	moovWithOffsets := func(offsets ...uint32) []byte {
+		mvhd := box("mvhd", u32(0), u32(0), u32(0), u32(1000), u32(1000), make([]byte, 80))
+		stcoBody := [][]byte{u32(0), u32(uint32(len(offsets)))}
+		for _, o := range offsets {
+			stcoBody = append(stcoBody, u32(o))
+		}
+		stbl := box("stbl", box("stco", stcoBody...))
+		return box("moov", mvhd, box("trak", box("mdia", box("minf", stbl))))
+	}
+
+	src := bytes.Join([][]byte{ftyp, mdat, moovWithOffsets(uint32(chunkA), uint32(chunkB))}, nil)
+	var out bytes.Buffer
+	if err := FastStart(bytes.NewReader(src), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != len(src) {
+		t.Fatalf("output is %d bytes, want %d", out.Len(), len(src))
+	}
+
+	info, err := Parse(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsFastStart() {
+		t.Fatalf("box order after rewrite = %v", info.BoxOrder())
+	}
+
+	stco, ok := findPath(out.Bytes()[len(ftyp)+8:], "trak", "mdia", "minf", "stbl", "stco")
+	if !ok {
+		t.Fatal("stco not found after rewrite")
+	}
+	offsets, err := readOffsets(stco, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"AAAA", "BBBB"} {
+		if got := string(out.Bytes()[offsets[i] : offsets[i]+4]); got != want {
+			t.Errorf("chunk %d points at %q, want %q", i, got, want)
+		}
+	}
+
+	// Already fast start files are copied unchanged
+	var again bytes.Buffer
+	if err := FastStart(bytes.NewReader(out.Bytes()), &again); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), out.Bytes()) {
+		t.Error("fast start file was modified")
+	}
+}
+
+func TestRebuildWidensStcoToCo64(t *testing.T) {
+	stbl := box("stbl", box("stco", u32(0), u32(1), u32(100)))
+	shift := func(o uint64) uint64 { return o + 1<<32 }
+
+	if _, err := rebuild("stbl", stbl[8:], shift, false, true); err != errOffsetOverflow {
+		t.Fatalf("expected overflow, got %v", err)
+	}
+	rebuilt, err := rebuild("stbl", stbl[8:], shift, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	co64, ok := findPath(rebuilt[8:], "co64")
+	if !ok {
+		t.Fatal("co64 not found")
+	}
+	offsets, err := readOffsets(co64, 8)
+	if err != nil || len(offsets) != 1 || offsets[0] != 100+1<<32 {
+		t.Errorf("co64 offsets = %v, %v", offsets, err)
+	}
+}
+
+// sparseFile reads as a file of zeros with a few stretches of real bytes,
+// for files too large to hold in memory.
+type sparseFile struct {
+	size   int64
+	pos    int64
+	chunks map[int64][]byte
+}
+
+func (f *sparseFile) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), f.size-f.pos))
+	clear(p[:n])
+	for off, b := range f.chunks {
+		start, end := max(off, f.pos), min(off+int64(len(b)), f.pos+int64(n))
+		if start < end {
+			copy(p[start-f.pos:end-f.pos], b[start-off:end-off])
+		}
+	}
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *sparseFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// headTail keeps the start and the end of what is written to it
+type headTail struct {
+	n          int64
+	head, tail []byte
+}
+
+func (w *headTail) Write(p []byte) (int, error) {
+	if room := 4096 - len(w.head); room > 0 {
+		w.head = append(w.head, p[:min(room, len(p))]...)
+	}
+	w.tail = append(w.tail, p...)
+	w.tail = w.tail[max(0, len(w.tail)-64):]
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestFastStartShiftsChunksAfterMoov(t *testing.T) {
+	if testing.Short() {
+		t.Skip("copies more than 4 GB")
+	}
+
+	// ftyp | mdat past 4 GB | moov | mdat. Moving moov in front pushes the
+	// last chunk of the first mdat past 4 GB, so its stco becomes a co64 and
+	// moov grows, moving the second mdat too.
+	ftyp := box("ftyp", []byte("isom"), u32(512))
+	firstMdat := int64(len(ftyp))
+	firstMdatSize := int64(1 << 32)
+	chunkA := firstMdat + 16
+	// Still fits in an stco, but not once moov is in front of it
+	chunkB := int64(1<<32 - 100)
+	moovOffset := firstMdat + firstMdatSize
+	chunkC := func(moovSize int64) int64 { return moovOffset + moovSize + 8 }
+
+	moovWith := func(c int64) []byte {
+		mvhd := box("mvhd", u32(0), u32(0), u32(0), u32(1000), u32(1000), make([]byte, 80))
+		stco := box("stco", u32(0), u32(2), u32(uint32(chunkA)), u32(uint32(chunkB)))
+		co64 := box("co64", u32(0), u32(1), binary.BigEndian.AppendUint64(nil, uint64(c)))
+		trak := func(table []byte) []byte { return box("trak", box("mdia", box("minf", box("stbl", table)))) }
+		return box("moov", mvhd, trak(stco), trak(co64))
+	}
+	moovSize := int64(len(moovWith(0)))
+	moov := moovWith(chunkC(moovSize))
+	secondMdat := box("mdat", []byte("CCCC"))
+
+	firstMdatHeader := append(u32(1), "mdat"...)
+	firstMdatHeader = binary.BigEndian.AppendUint64(firstMdatHeader, uint64(firstMdatSize))
+	src := &sparseFile{
+		size: moovOffset + moovSize + int64(len(secondMdat)),
+		chunks: map[int64][]byte{
+			0:                     ftyp,
+			firstMdat:             firstMdatHeader,
+			chunkA:                []byte("AAAA"),
+			chunkB:                []byte("BBBB"),
+			moovOffset:            moov,
+			moovOffset + moovSize: secondMdat,
+		},
+	}
+
+	var out headTail
+	if err := FastStart(src, &out); err != nil {
+		t.Fatal(err)
+	}
+	newMoovSize := int64(binary.BigEndian.Uint32(out.head[len(ftyp):]))
+	moovBoxes, err := children(out.head[len(ftyp) : int64(len(ftyp))+newMoovSize])
+	if err != nil || len(moovBoxes) != 1 || moovBoxes[0].typ != "moov" {
+		t.Fatalf("output doesn't start with moov after ftyp: %v", err)
+	}
+	if newMoovSize != moovSize+8 {
+		t.Fatalf("moov is %d bytes after the rewrite, want %d with both stco entries widened", newMoovSize, moovSize+8)
+	}
+	if out.n != src.size+8 {
+		t.Errorf("output is %d bytes, want %d", out.n, src.size+8)
+	}
+
+	var offsets []uint64
+	traks, err := children(moovBoxes[0].payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trak := range traks {
+		if trak.typ != "trak" {
+			continue
+		}
+		co64, ok := findPath(trak.payload, "mdia", "minf", "stbl", "co64")
+		if !ok {
+			t.Fatal("chunk offsets weren't widened to co64")
+		}
+		trakOffsets, err := readOffsets(co64, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, trakOffsets...)
+	}
+	want := []uint64{
+		uint64(chunkA + newMoovSize),
+		uint64(chunkB + newMoovSize),
+		// The second mdat's payload, now at the very end of the output
+		uint64(out.n - 4),
+	}
+	if len(offsets) != len(want) {
+		t.Fatalf("chunk offsets = %v, want %v", offsets, want)
+	}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Errorf("chunk %d at %d, want %d", i, offsets[i], want[i])
+		}
+	}
+	if got := string(out.head[offsets[0] : offsets[0]+4]); got != "AAAA" {
+		t.Errorf("first chunk points at %q", got)
+	}
+	if got := string(out.tail[len(out.tail)-4:]); got != "CCCC" {
+		t.Errorf("output ends in %q, want the last chunk", got)
+	}
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LocalStore keeps objects as files under a root directory, which the
+// server exposes through its /assets/ file server.
+type LocalStore struct {
+	root    string
+	baseURL string
+	signer  *URLSigner
+}
+
+func NewLocalStore(root, baseURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{root: root, baseURL: baseURL}, nil
+}
+
+func (s *LocalStore) diskPath(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	diskPath, err := s.diskPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(diskPath), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(diskPath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), diskPath)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	diskPath, err := s.diskPath(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	file, err := os.Open(diskPath)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return file, info, nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	diskPath, err := s.diskPath(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(diskPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	if fi.IsDir() {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return localObjectInfo(key, fi), nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	diskPath, err := s.diskPath(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(diskPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	infos := []ObjectInfo{}
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(path.Base(key), ".upload-") {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		infos = append(infos, localObjectInfo(key, fi))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
+
+// UseURLSigning makes Presign return URLs signed by signer, which the
+// /assets/ file server checks with signer.Protect.
+func (s *LocalStore) UseURLSigning(signer *URLSigner) {
+	s.signer = signer
+}
+
+func (s *LocalStore) Presign(ctx context.Context, key string, expiresIn time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	if s.signer != nil {
+		return s.signer.Sign(s.URL(key), key, time.Now().Add(expiresIn)), nil
+	}
+	return s.URL(key), nil
+}
+
+func (s *LocalStore) URL(key string) string {
+	return joinURL(s.baseURL, key)
+}
+
+func localObjectInfo(key string, fi fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  mime.TypeByExtension(path.Ext(key)),
+		LastModified: fi.ModTime().UTC(),
+	}
+}
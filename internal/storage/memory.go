@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data []byte
+	info ObjectInfo
+}
+
+// MemoryStore keeps objects in process memory. It is meant for tests and
+// for running the server locally without any persistent storage.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	baseURL string
+	signer  *URLSigner
+}
+
+func NewMemoryStore(baseURL string) *MemoryStore {
+	return &MemoryStore{
+		objects: map[string]memoryObject{},
+		baseURL: baseURL,
+	}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = memoryObject{
+		data: data,
+		info: ObjectInfo{
+			Key:          key,
+			Size:         int64(len(data)),
+			ContentType:  contentType,
+			LastModified: time.Now().UTC(),
+		},
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, ObjectInfo{}, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), obj.info, nil
+}
+
+func (s *MemoryStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return obj.info, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := []ObjectInfo{}
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, obj.info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
+
+// UseURLSigning makes Presign return URLs signed by signer. ServeHTTP
+// doesn't check them itself; wrap it with signer.Protect.
+func (s *MemoryStore) UseURLSigning(signer *URLSigner) {
+	s.signer = signer
+}
+
+func (s *MemoryStore) Presign(ctx context.Context, key string, expiresIn time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	if s.signer != nil {
+		return s.signer.Sign(s.URL(key), key, time.Now().Add(expiresIn)), nil
+	}
+	return s.URL(key), nil
+}
+
+func (s *MemoryStore) URL(key string) string {
+	return joinURL(s.baseURL, key)
+}
+
+// ServeHTTP serves objects by key so URLs handed out by the store resolve
+// when it is mounted under its base URL path.
+func (s *MemoryStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	body, info, err := s.Get(r.Context(), key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, body)
+}
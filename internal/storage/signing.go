@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrivatePrefix is where objects that may only be fetched through signed
+// URLs are stored. A CloudFront distribution in front of a bucket has to be
+// set up to require signed URLs for it; local and memory storage enforce it
+// with URLSigner.Protect.
+const PrivatePrefix = "private/"
+
+// URLSigner signs URLs of objects the server serves itself, from local or
+// memory storage, the way CloudFront signs URLs of objects in a bucket.
+type URLSigner struct {
+	secret []byte
+}
+
+func NewURLSigner(secret []byte) *URLSigner {
+	return &URLSigner{secret: secret}
+}
+
+// Sign appends an expiry time and a signature over it and the key to the
+// object's URL.
+func (s *URLSigner) Sign(objectURL, key string, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return objectURL + "?" + url.Values{
+		"expires":   {expires},
+		"signature": {s.signature(key, expires)},
+	}.Encode()
+}
+
+// Verify reports whether query carries a signature for key that hasn't
+// expired yet.
+func (s *URLSigner) Verify(key string, query url.Values, now time.Time) bool {
+	expires := query.Get("expires")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return false
+	}
+	return hmac.Equal([]byte(query.Get("signature")), []byte(s.signature(key, expires)))
+}
+
+func (s *URLSigner) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Protect wraps the handler serving a store, mounted with the store's base
+// path already stripped, so that objects under PrivatePrefix are only
+// served to requests with a valid signature. Everything else passes
+// through.
+func (s *URLSigner) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		// Case-insensitive file systems would serve PRIVATE/ as private/
+		lower := strings.ToLower(key)
+		if lower+"/" == PrivatePrefix || strings.HasPrefix(lower, PrivatePrefix) {
+			if !s.Verify(key, r.URL.Query(), time.Now()) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
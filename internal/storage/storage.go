@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+var ErrInvalidKey = errors.New("invalid object key")
+
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// BlobStore is the storage backend used for every uploaded asset, videos
+// and thumbnails alike. Keys are slash separated paths relative to the
+// root of the store, e.g. "landscape/abc123.mp4".
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Presign returns a URL that grants temporary read access to the object.
+	// Backends that have no notion of signing return the public URL.
+	Presign(ctx context.Context, key string, expiresIn time.Duration) (string, error)
+	// URL returns the permanent public URL of the object.
+	URL(key string) string
+}
+
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+func joinURL(base, key string) string {
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
+
+// KeyForURL recovers the key of an object from a URL returned by store.URL.
+// ok is false for URLs the store didn't hand out.
+func KeyForURL(store BlobStore, url string) (key string, ok bool) {
+	key, ok = strings.CutPrefix(url, store.URL(""))
+	if !ok || validateKey(key) != nil {
+		return "", false
+	}
+	return key, true
+}
+
+type CompletedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// DirectUploader is implemented by stores that let clients upload objects
+// themselves through presigned URLs instead of streaming through the server.
+type DirectUploader interface {
+	PresignPut(ctx context.Context, key, contentType string, expiresIn time.Duration) (string, error)
+	CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error)
+	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expiresIn time.Duration) (string, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
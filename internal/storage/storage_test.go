@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "landscape/abc.mp4", strings.NewReader("video bytes"), "video/mp4"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put(ctx, "thumb.png", strings.NewReader("png"), "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	info, err := store.Stat(ctx, "landscape/abc.mp4")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size != int64(len("video bytes")) || info.ContentType != "video/mp4" {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	body, _, err := store.Get(ctx, "landscape/abc.mp4")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "video bytes" {
+		t.Errorf("Get returned %q", data)
+	}
+
+	infos, err := store.List(ctx, "landscape/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(infos) != 1 || infos[0].Key != "landscape/abc.mp4" {
+		t.Errorf("List = %+v", infos)
+	}
+
+	if err := store.Delete(ctx, "landscape/abc.mp4"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Stat(ctx, "landscape/abc.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after delete: got %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "landscape/abc.mp4"); err != nil {
+		t.Errorf("Delete of missing object: %v", err)
+	}
+
+	if err := store.Put(ctx, "../escape.txt", strings.NewReader("x"), "text/plain"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put with traversal key: got %v, want ErrInvalidKey", err)
+	}
+
+	if got := store.URL("thumb.png"); got != "http://localhost:8091/assets/thumb.png" {
+		t.Errorf("URL = %q", got)
+	}
+	if key, ok := KeyForURL(store, store.URL("landscape/abc.mp4")); !ok || key != "landscape/abc.mp4" {
+		t.Errorf("KeyForURL of a store URL = %q, %v", key, ok)
+	}
+	if _, ok := KeyForURL(store, "https://elsewhere.example.com/assets/thumb.png"); ok {
+		t.Error("KeyForURL accepted a URL from another host")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testBlobStore(t, NewMemoryStore("http://localhost:8091/assets"))
+}
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "http://localhost:8091/assets/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, store)
+}
+
+func TestS3StoreCloudFrontSigning(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewS3Store(s3.New(s3.Options{Region: "us-east-1"}), "bucket", "https://d111.cloudfront.net")
+	store.UseCloudFrontSigning("K2JCJMDEHXQW5F", key)
+
+	signed, err := store.Presign(context.Background(), "landscape/abc.mp4", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base := u.Scheme + "://" + u.Host + u.Path; base != store.URL("landscape/abc.mp4") {
+		t.Errorf("signed URL %q isn't under the distribution", signed)
+	}
+	q := u.Query()
+	if q.Get("Key-Pair-Id") != "K2JCJMDEHXQW5F" || q.Get("Signature") == "" || q.Get("Expires") == "" {
+		t.Errorf("signed URL %q is missing signature parameters", signed)
+	}
+
+	if _, err := store.Presign(context.Background(), "../etc/passwd", time.Hour); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Presign of an invalid key returned %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestURLSigning(t *testing.T) {
+	ctx := context.Background()
+	signer := NewURLSigner([]byte("secret"))
+	store := NewMemoryStore("http://localhost:8091/memory")
+	store.UseURLSigning(signer)
+	handler := signer.Protect(store)
+	for _, key := range []string{PrivatePrefix + "abc.mp4", PrivatePrefix + "def.mp4", "abc.mp4"} {
+		if err := store.Put(ctx, key, strings.NewReader("video bytes"), "video/mp4"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(path string, query url.Values) int {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	signed, err := store.Presign(ctx, PrivatePrefix+"abc.mp4", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base := u.Scheme + "://" + u.Host + u.Path; base != store.URL(PrivatePrefix+"abc.mp4") {
+		t.Errorf("signed URL %q isn't the object's URL", signed)
+	}
+	expired, _ := url.Parse(signer.Sign("", PrivatePrefix+"abc.mp4", time.Now().Add(-time.Minute)))
+
+	tests := []struct {
+		name  string
+		path  string
+		query url.Values
+		want  int
+	}{
+		{"signed", "/private/abc.mp4", u.Query(), http.StatusOK},
+		{"unsigned", "/private/abc.mp4", nil, http.StatusForbidden},
+		{"expired", "/private/abc.mp4", expired.Query(), http.StatusForbidden},
+		{"signed for another key", "/private/def.mp4", u.Query(), http.StatusForbidden},
+		{"different case", "/PRIVATE/abc.mp4", nil, http.StatusForbidden},
+		{"private directory", "/private/", nil, http.StatusForbidden},
+		{"public", "/abc.mp4", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := get(tt.path, tt.query); got != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// loadJWTKeys builds the keys access JWTs are signed and verified with.
+// Tokens are signed with the PEM key at signingKeyPath, or with secret
+// when there is none. verificationKeyPaths is a comma-separated list of
+// more keys to accept, such as the one being rotated out. A secret set
+// alongside a signing key is only used to verify, so tokens issued before
+// the switch to signing keys stay valid until they expire.
+func loadJWTKeys(secret, signingKeyPath, verificationKeyPaths string) (*auth.KeySet, error) {
+	var verification []auth.Key
+	for _, path := range strings.Split(verificationKeyPaths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		key, err := auth.LoadKey(path)
+		if err != nil {
+			return nil, err
+		}
+		verification = append(verification, key)
+	}
+
+	if signingKeyPath == "" {
+		if secret == "" {
+			return nil, errors.New("JWT_SECRET or JWT_SIGNING_KEY must be set")
+		}
+		return auth.NewKeySet(auth.NewSecretKey(secret), verification...)
+	}
+
+	signing, err := auth.LoadKey(signingKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	if secret != "" {
+		verification = append(verification, auth.NewSecretKey(secret))
+	}
+	return auth.NewKeySet(signing, verification...)
+}
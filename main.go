@@ -2,29 +2,56 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"crypto/rand"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
 	db               database.Client
-	jwtSecret        string
+	videos           database.VideoStore
+	users            database.UserStore
+	refreshTokens    database.RefreshTokenStore
+	jwtKeys          *auth.KeySet
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
+	cfKeyPairID      string
+	cfPrivateKey     crypto.Signer
+	signedURLExpiry  time.Duration
+	urlSigner        *storage.URLSigner
 	port             string
-	s3client         *s3.Client
+	videoStore       storage.BlobStore
+	thumbnailStore   storage.BlobStore
+	memoryStore      *storage.MemoryStore
+	uploadsRoot      string
+	instanceID       string
+	jobWake          chan struct{}
+	cleanupWake      chan struct{}
+	hls              hlsConfig
+	tusUploadExpiry  time.Duration
+	tusLocks         *tusLocks
+	thumbnails       thumbnailConfig
+	orphanGC         orphanGCConfig
+	mediaRules       mediaRules
+	transcode        transcodeConfig
+	quotas           quotaConfig
 }
 
 
@@ -35,100 +62,434 @@ type thumbnail struct {
 
 func main() {
 	godotenv.Load(".env")
-	awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(os.Getenv("S3_REGION")))
-	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
-	}
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
+	// DB_URL is either a SQLite file path or a postgres:// URL. DB_PATH is
+	// still read so existing .env files keep working.
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = os.Getenv("DB_PATH")
+	}
+	if dbURL == "" {
 		log.Fatal("DB_URL must be set")
 	}
-	
 
-	db, err := database.NewClient(pathToDB)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(dbURL, os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	db, err := database.NewClient(dbURL)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	// Default quotas for users without their own, 0 for unlimited; set
+	// per-user ones with `tubely quota`
+	quotas := quotaConfig{
+		maxStorageBytes: 10 << 30,
+		maxVideos:       100,
+		maxFileSize:     1 << 30,
+	}
+	if v := os.Getenv("QUOTA_MAX_STORAGE"); v != "" {
+		quotas.maxStorageBytes, err = parseByteSize(v)
+		if err != nil {
+			log.Fatalf("QUOTA_MAX_STORAGE must be a size such as 10GB, got %q", v)
+		}
+	}
+	if v := os.Getenv("QUOTA_MAX_VIDEOS"); v != "" {
+		quotas.maxVideos, err = strconv.Atoi(v)
+		if err != nil || quotas.maxVideos < 0 {
+			log.Fatalf("QUOTA_MAX_VIDEOS must be a non-negative integer, got %q", v)
+		}
+	}
+	if v := os.Getenv("QUOTA_MAX_FILE_SIZE"); v != "" {
+		quotas.maxFileSize, err = parseByteSize(v)
+		if err != nil {
+			log.Fatalf("QUOTA_MAX_FILE_SIZE must be a size such as 1GB, got %q", v)
+		}
 	}
 
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+	// Like migrate, these only need the database and run before any of the
+	// server's settings are checked
+	if len(os.Args) > 1 && os.Args[1] == "role" {
+		cfg := apiConfig{db: db, videos: db, users: db}
+		if err := cfg.runRole(os.Args[2:]); err != nil {
+			log.Fatalf("role: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "quota" {
+		cfg := apiConfig{db: db, videos: db, users: db, quotas: quotas}
+		if err := cfg.runQuota(os.Args[2:]); err != nil {
+			log.Fatalf("quota: %v", err)
+		}
+		return
 	}
 
+	// PLATFORM, FILEPATH_ROOT and PORT are only checked once it's clear the
+	// server is starting, gc doesn't need them
+	platform := os.Getenv("PLATFORM")
 	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
-	}
+	port := os.Getenv("PORT")
 
 	assetsRoot := os.Getenv("ASSETS_ROOT")
 	if assetsRoot == "" {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	uploadsRoot := os.Getenv("UPLOADS_ROOT")
+	if uploadsRoot == "" {
+		uploadsRoot = "./uploads"
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	// Raw uploads wait in UPLOADS_ROOT, which only this instance can read,
+	// so its processing jobs are tagged with it. It has to stay the same
+	// across restarts for interrupted jobs to be picked up again.
+	instanceID := os.Getenv("INSTANCE_ID")
+	if instanceID == "" {
+		instanceID, err = os.Hostname()
+		if err != nil {
+			log.Fatalf("INSTANCE_ID isn't set and the hostname is unknown: %v", err)
+		}
+	}
+
+	videoWorkers := 2
+	if v := os.Getenv("VIDEO_WORKERS"); v != "" {
+		videoWorkers, err = strconv.Atoi(v)
+		if err != nil || videoWorkers < 1 {
+			log.Fatalf("VIDEO_WORKERS must be a positive integer, got %q", v)
+		}
+	}
+
+	// HLS packaging runs after the MP4 is stored; set HLS_RENDITIONS=off to skip it
+	hls := hlsConfig{
+		segmentType:    hlsSegmentTypeFMP4,
+		segmentSeconds: 6,
+	}
+	hlsRenditions := os.Getenv("HLS_RENDITIONS")
+	if hlsRenditions == "" {
+		hlsRenditions = "1080p,720p,480p,360p"
+	}
+	if hlsRenditions != "off" {
+		hls.renditions, err = parseHLSRenditions(hlsRenditions)
+		if err != nil {
+			log.Fatalf("HLS_RENDITIONS is invalid: %v", err)
+		}
+	}
+	if v := os.Getenv("HLS_SEGMENT_TYPE"); v != "" {
+		if v != hlsSegmentTypeFMP4 && v != hlsSegmentTypeTS {
+			log.Fatalf("HLS_SEGMENT_TYPE must be %q or %q, got %q", hlsSegmentTypeFMP4, hlsSegmentTypeTS, v)
+		}
+		hls.segmentType = v
+	}
+	if v := os.Getenv("HLS_SEGMENT_SECONDS"); v != "" {
+		hls.segmentSeconds, err = strconv.Atoi(v)
+		if err != nil || hls.segmentSeconds < 1 {
+			log.Fatalf("HLS_SEGMENT_SECONDS must be a positive integer, got %q", v)
+		}
+	}
+
+	// Uploads that aren't H.264/AAC MP4s are transcoded with libx264
+	transcode := transcodeConfig{
+		crf:    23,
+		preset: "veryfast",
+	}
+	if v := os.Getenv("TRANSCODE_CRF"); v != "" {
+		transcode.crf, err = strconv.Atoi(v)
+		if err != nil || transcode.crf < 0 || transcode.crf > 51 {
+			log.Fatalf("TRANSCODE_CRF must be an integer from 0 to 51, got %q", v)
+		}
+	}
+	if v := os.Getenv("TRANSCODE_PRESET"); v != "" {
+		if !slices.Contains(x264Presets, v) {
+			log.Fatalf("TRANSCODE_PRESET must be one of %s, got %q", strings.Join(x264Presets, ", "), v)
+		}
+		transcode.preset = v
+	}
+	if v := os.Getenv("ARCHIVE_ORIGINALS"); v != "" {
+		transcode.archiveOriginals, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("ARCHIVE_ORIGINALS must be true or false, got %q", v)
+		}
+	}
+
+	tusUploadExpiry := 24 * time.Hour
+	if v := os.Getenv("TUS_UPLOAD_EXPIRY"); v != "" {
+		tusUploadExpiry, err = time.ParseDuration(v)
+		if err != nil || tusUploadExpiry <= 0 {
+			log.Fatalf("TUS_UPLOAD_EXPIRY must be a positive duration, got %q", v)
+		}
+	}
+
+	thumbnails := thumbnailConfig{
+		mode:       thumbnailModeTimestamp,
+		timestamp:  time.Second,
+		candidates: 3,
+	}
+	if v := os.Getenv("THUMBNAIL_MODE"); v != "" {
+		if v != thumbnailModeOff && v != thumbnailModeTimestamp && v != thumbnailModeScene {
+			log.Fatalf("THUMBNAIL_MODE must be %q, %q or %q, got %q", thumbnailModeTimestamp, thumbnailModeScene, thumbnailModeOff, v)
+		}
+		thumbnails.mode = v
+	}
+	if v := os.Getenv("THUMBNAIL_TIMESTAMP"); v != "" {
+		thumbnails.timestamp, err = time.ParseDuration(v)
+		if err != nil || thumbnails.timestamp < 0 {
+			log.Fatalf("THUMBNAIL_TIMESTAMP must be a duration, got %q", v)
+		}
+	}
+	if v := os.Getenv("THUMBNAIL_CANDIDATES"); v != "" {
+		thumbnails.candidates, err = strconv.Atoi(v)
+		if err != nil || thumbnails.candidates < 1 {
+			log.Fatalf("THUMBNAIL_CANDIDATES must be a positive integer, got %q", v)
+		}
+	}
+
+	// The background orphan collector is off unless ORPHAN_GC_INTERVAL is set;
+	// `tubely gc` runs it once
+	orphanGC := orphanGCConfig{
+		grace: 24 * time.Hour,
+	}
+	if v := os.Getenv("ORPHAN_GC_INTERVAL"); v != "" {
+		orphanGC.interval, err = time.ParseDuration(v)
+		if err != nil || orphanGC.interval <= 0 {
+			log.Fatalf("ORPHAN_GC_INTERVAL must be a positive duration, got %q", v)
+		}
+	}
+	if v := os.Getenv("ORPHAN_GC_GRACE"); v != "" {
+		orphanGC.grace, err = time.ParseDuration(v)
+		if err != nil || orphanGC.grace < 0 {
+			log.Fatalf("ORPHAN_GC_GRACE must be a duration, got %q", v)
+		}
+	}
+	if v := os.Getenv("ORPHAN_GC_DRY_RUN"); v != "" {
+		orphanGC.dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("ORPHAN_GC_DRY_RUN must be true or false, got %q", v)
+		}
 	}
 
+	// Uploads breaking any of these are rejected before they're queued; a
+	// limit of 0 or an empty codec list turns that rule off
+	mediaRules := defaultMediaRules()
+	if v, ok := os.LookupEnv("UPLOAD_VIDEO_CODECS"); ok {
+		mediaRules.videoCodecs = parseCodecList(v)
+	}
+	if v, ok := os.LookupEnv("UPLOAD_AUDIO_CODECS"); ok {
+		mediaRules.audioCodecs = parseCodecList(v)
+	}
+	if v := os.Getenv("UPLOAD_MAX_DURATION"); v != "" {
+		mediaRules.maxDuration, err = time.ParseDuration(v)
+		if err != nil || mediaRules.maxDuration < 0 {
+			log.Fatalf("UPLOAD_MAX_DURATION must be a duration, got %q", v)
+		}
+	}
+	if v := os.Getenv("UPLOAD_MAX_RESOLUTION"); v == "0" {
+		mediaRules.maxWidth, mediaRules.maxHeight = 0, 0
+	} else if v != "" {
+		_, err = fmt.Sscanf(v, "%dx%d", &mediaRules.maxWidth, &mediaRules.maxHeight)
+		if err != nil || mediaRules.maxWidth < 0 || mediaRules.maxHeight < 0 {
+			log.Fatalf("UPLOAD_MAX_RESOLUTION must look like 3840x2160, got %q", v)
+		}
+	}
+	if v := os.Getenv("UPLOAD_MAX_BITRATE"); v != "" {
+		mediaRules.maxBitRate, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || mediaRules.maxBitRate < 0 {
+			log.Fatalf("UPLOAD_MAX_BITRATE must be a number of bits per second, got %q", v)
+		}
+	}
+
+	videoStorage := os.Getenv("VIDEO_STORAGE")
+	if videoStorage == "" {
+		videoStorage = storageBackendS3
+	}
+
+	thumbnailStorage := os.Getenv("THUMBNAIL_STORAGE")
+	if thumbnailStorage == "" {
+		thumbnailStorage = storageBackendLocal
+	}
+
+	// S3 settings are only needed when one of the stores lives in S3
+	s3Bucket := os.Getenv("S3_BUCKET")
+	s3Region := os.Getenv("S3_REGION")
 	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	if videoStorage == storageBackendS3 || thumbnailStorage == storageBackendS3 {
+		if s3Bucket == "" {
+			log.Fatal("S3_BUCKET environment variable is not set")
+		}
+		if s3Region == "" {
+			log.Fatal("S3_REGION environment variable is not set")
+		}
+		if s3CfDistribution == "" {
+			log.Fatal("S3_CF_DISTRO environment variable is not set")
+		}
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	// Private videos get URLs that expire. They are CloudFront signed URLs
+	// when a key pair is configured, presigned S3 URLs otherwise.
+	signedURLExpiry := time.Hour
+	if v := os.Getenv("SIGNED_URL_EXPIRY"); v != "" {
+		signedURLExpiry, err = time.ParseDuration(v)
+		if err != nil || signedURLExpiry <= 0 {
+			log.Fatalf("SIGNED_URL_EXPIRY must be a positive duration, got %q", v)
+		}
+	}
+	cfKeyPairID := os.Getenv("CF_KEY_PAIR_ID")
+	var cfPrivateKey crypto.Signer
+	if cfKeyPairID != "" {
+		keyPath := os.Getenv("CF_PRIVATE_KEY_PATH")
+		if keyPath == "" {
+			log.Fatal("CF_PRIVATE_KEY_PATH must be set when CF_KEY_PAIR_ID is")
+		}
+		cfPrivateKey, err = loadCloudFrontPrivateKey(keyPath)
+		if err != nil {
+			log.Fatalf("Couldn't load CloudFront private key: %v", err)
+		}
+	}
+	// Local and memory storage sign the URLs of private objects themselves.
+	// Without a fixed secret the URLs stop working when the server restarts.
+	urlSigningSecret := []byte(os.Getenv("URL_SIGNING_SECRET"))
+	if len(urlSigningSecret) == 0 {
+		urlSigningSecret = make([]byte, 32)
+		if _, err := rand.Read(urlSigningSecret); err != nil {
+			log.Fatalf("Couldn't generate URL signing secret: %v", err)
+		}
 	}
 
 	cfg := apiConfig{
 		db:               db,
-		jwtSecret:        jwtSecret,
+		videos:           db,
+		users:            db,
+		refreshTokens:    db,
 		platform:         platform,
 		filepathRoot:     filepathRoot,
 		assetsRoot:       assetsRoot,
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
+		cfKeyPairID:      cfKeyPairID,
+		cfPrivateKey:     cfPrivateKey,
+		signedURLExpiry:  signedURLExpiry,
+		urlSigner:        storage.NewURLSigner(urlSigningSecret),
 		port:             port,
-		s3client: s3.NewFromConfig(awsCfg),
+		memoryStore:      storage.NewMemoryStore(fmt.Sprintf("http://localhost:%s/memory", port)),
+		uploadsRoot:      uploadsRoot,
+		instanceID:       instanceID,
+		jobWake:          make(chan struct{}, 1),
+		cleanupWake:      make(chan struct{}, 1),
+		hls:              hls,
+		tusUploadExpiry:  tusUploadExpiry,
+		tusLocks:         &tusLocks{},
+		thumbnails:       thumbnails,
+		orphanGC:         orphanGC,
+		mediaRules:       mediaRules,
+		transcode:        transcode,
+		quotas:           quotas,
 	}
+	cfg.memoryStore.UseURLSigning(cfg.urlSigner)
 	err = cfg.ensureAssetsDir()
 	if err != nil {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	err = os.MkdirAll(cfg.uploadsRoot, 0755)
+	if err != nil {
+		log.Fatalf("Couldn't create uploads directory: %v", err)
+	}
+
+	cfg.videoStore, err = cfg.newBlobStore(videoStorage)
+	if err != nil {
+		log.Fatalf("Couldn't create video storage: %v", err)
+	}
+	cfg.thumbnailStore, err = cfg.newBlobStore(thumbnailStorage)
+	if err != nil {
+		log.Fatalf("Couldn't create thumbnail storage: %v", err)
+	}
+
+	// gc only needs the database and the stores it sweeps
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		if err := cfg.runGC(os.Args[2:]); err != nil {
+			log.Fatalf("gc: %v", err)
+		}
+		return
+	}
+
+	cfg.jwtKeys, err = loadJWTKeys(os.Getenv("JWT_SECRET"), os.Getenv("JWT_SIGNING_KEY"), os.Getenv("JWT_VERIFICATION_KEYS"))
+	if err != nil {
+		log.Fatalf("Couldn't load JWT keys: %v", err)
+	}
+	if platform == "" {
+		log.Fatal("PLATFORM environment variable is not set")
+	}
+	if filepathRoot == "" {
+		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	}
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
+	}
+
+	err = cfg.startVideoWorkers(context.Background(), videoWorkers)
+	if err != nil {
+		log.Fatalf("Couldn't start video workers: %v", err)
+	}
+	cfg.startTusExpiry(context.Background(), time.Hour)
+	cfg.startDirectUploadExpiry(context.Background(), time.Hour)
+	cfg.startBlobCleanup(context.Background(), time.Minute)
+	if cfg.orphanGC.interval > 0 {
+		cfg.startOrphanGC(context.Background())
+	}
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
+	// Private objects are only served with a signature, see storage.PrivatePrefix
+	assetsHandler := http.StripPrefix("/assets", cfg.urlSigner.Protect(http.FileServer(http.Dir(assetsRoot))))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.Handle("/memory/", noCacheMiddleware(http.StripPrefix("/memory", cfg.urlSigner.Protect(cfg.memoryStore))))
 
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	// These need an access JWT, see handler_api_keys.go
+	mux.Handle("GET /api/users/me/usage", cfg.requireSession(cfg.handlerUsageGet))
+	mux.Handle("POST /api/api_keys", cfg.requireSession(cfg.handlerAPIKeyCreate))
+	mux.Handle("GET /api/api_keys", cfg.requireSession(cfg.handlerAPIKeysList))
+	mux.Handle("DELETE /api/api_keys/{keyID}", cfg.requireSession(cfg.handlerAPIKeyRevoke))
+
+	// These accept an API key as well as a JWT
+	read := func(handler http.HandlerFunc) http.Handler { return cfg.requireAuth(auth.ScopeVideosRead, handler) }
+	write := func(handler http.HandlerFunc) http.Handler { return cfg.requireAuth(auth.ScopeVideosWrite, handler) }
+	mux.Handle("POST /api/videos", write(cfg.handlerVideoMetaCreate))
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", write(cfg.handlerUploadThumbnail))
+	mux.Handle("POST /api/video_upload/{videoID}", write(cfg.handlerUploadVideo))
+	mux.Handle("POST /api/video_upload/{videoID}/presign", write(cfg.handlerUploadVideoPresign))
+	mux.Handle("POST /api/video_upload/{videoID}/complete", write(cfg.handlerUploadVideoComplete))
+	mux.HandleFunc("OPTIONS /api/tus/", cfg.handlerTusOptions)
+	mux.Handle("POST /api/tus/{$}", write(cfg.handlerTusCreate))
+	mux.Handle("HEAD /api/tus/{uploadID}", write(cfg.handlerTusHead))
+	mux.Handle("PATCH /api/tus/{uploadID}", write(cfg.handlerTusPatch))
+	mux.Handle("DELETE /api/tus/{uploadID}", write(cfg.handlerTusDelete))
+	mux.Handle("GET /api/videos", read(cfg.handlerVideosRetrieve))
+	mux.Handle("GET /api/videos/{videoID}", cfg.optionalAuth(auth.ScopeVideosRead, cfg.handlerVideoGet))
+	mux.Handle("GET /api/videos/{videoID}/processing", read(cfg.handlerVideoProcessingGet))
+	mux.Handle("DELETE /api/videos/{videoID}", write(cfg.handlerVideoMetaDelete))
+	mux.Handle("PUT /api/videos/{videoID}/visibility", write(cfg.handlerVideoVisibilityUpdate))
+	mux.Handle("GET /api/videos/{videoID}/thumbnails", read(cfg.handlerThumbnailCandidatesGet))
+	mux.Handle("POST /api/videos/{videoID}/thumbnails/{candidateID}/select", write(cfg.handlerThumbnailCandidateSelect))
 
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.Handle("POST /admin/reset", cfg.requireRole(auth.RoleAdmin, cfg.handlerReset))
+	mux.Handle("GET /admin/users", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUsersList))
+	mux.Handle("POST /admin/users/{userID}/disable", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUserDisable))
+	mux.Handle("POST /admin/users/{userID}/enable", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUserEnable))
+	mux.Handle("PUT /admin/users/{userID}/role", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUserRoleUpdate))
+	mux.Handle("DELETE /admin/videos/{videoID}", cfg.requireRole(auth.RoleModerator, cfg.handlerAdminVideoDelete))
 
 	srv := &http.Server{
 		Addr:    ":" + port,
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
+)
+
+// acceptedVideoTypes are the containers uploads may use, as detected from
+// the file itself rather than whatever the client claimed. Everything but
+// MP4 is transcoded, which takes ffmpeg.
+var acceptedVideoTypes = []string{"video/mp4", "video/quicktime", "video/webm", "video/x-matroska", "video/x-msvideo"}
+
+// mediaRules are the limits an upload has to meet before anything is stored
+// or queued. Zero limits and empty codec lists are not enforced.
+type mediaRules struct {
+	videoCodecs []string
+	audioCodecs []string
+	maxDuration time.Duration
+	// maxWidth and maxHeight bound the display size in either orientation,
+	// so 3840x2160 also admits a 2160x3840 portrait video
+	maxWidth   int
+	maxHeight  int
+	maxBitRate int64
+}
+
+func defaultMediaRules() mediaRules {
+	return mediaRules{
+		videoCodecs: []string{"h264", "hevc", "vp8", "vp9", "av1", "mpeg4"},
+		audioCodecs: []string{"aac", "mp3", "opus", "vorbis", "flac", "ac3", "eac3", "pcm_s16le"},
+		maxDuration: 4 * time.Hour,
+		maxWidth:    4096,
+		maxHeight:   2160,
+		maxBitRate:  100_000_000,
+	}
+}
+
+// mediaRuleError says which rule an upload broke. It is sent to the client
+// as is, so the message must not leak anything about the server.
+type mediaRuleError struct {
+	Message string `json:"error"`
+	Rule    string `json:"rule"`
+	Limit   any    `json:"limit,omitempty"`
+	Actual  any    `json:"actual,omitempty"`
+}
+
+func (e *mediaRuleError) Error() string {
+	return e.Message
+}
+
+func (e *mediaRuleError) status() int {
+	if e.Rule == "content_type" {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusUnprocessableEntity
+}
+
+func respondWithMediaRuleError(w http.ResponseWriter, err *mediaRuleError) {
+	log.Printf("Rejected upload, %s rule: %s", err.Rule, err.Message)
+	respondWithJSON(w, err.status(), err)
+}
+
+// sniffVideoType identifies a video container from the first bytes of a
+// file, falling back to net/http's sniffer for anything else.
+func sniffVideoType(header []byte) string {
+	switch {
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		if string(header[8:12]) == "qt  " {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	case bytes.HasPrefix(header, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		// The EBML header names the document type near the start
+		if bytes.Contains(header[:min(len(header), 64)], []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "AVI ":
+		return "video/x-msvideo"
+	}
+	mediaType, _, _ := strings.Cut(http.DetectContentType(header), ";")
+	return mediaType
+}
+
+// sniffVideo reads just enough of r to detect its type and checks it is one
+// of the accepted containers.
+func sniffVideo(r io.Reader, accepted []string) (string, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	mediaType := sniffVideoType(header[:n])
+	if !slices.Contains(accepted, mediaType) {
+		return "", &mediaRuleError{
+			Message: fmt.Sprintf("Uploaded file is %s, not a supported video", mediaType),
+			Rule:    "content_type",
+			Limit:   accepted,
+			Actual:  mediaType,
+		}
+	}
+	return mediaType, nil
+}
+
+// probeUpload runs ffprobe on an upload before it is accepted. Tests
+// replace it, since ffprobe may not be installed.
+var probeUpload = probeVideo
+
+// validateVideoFile checks an upload on disk against every rule and returns
+// its detected media type and ffprobe output. Errors that are the upload's
+// fault are *mediaRuleError, anything else is a server problem.
+func (cfg *apiConfig) validateVideoFile(filePath string) (string, FFProbeOutput, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", FFProbeOutput{}, err
+	}
+	accepted := acceptedVideoTypes
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		accepted = []string{"video/mp4"}
+	}
+	mediaType, err := sniffVideo(file, accepted)
+	file.Close()
+	if err != nil {
+		return "", FFProbeOutput{}, err
+	}
+
+	var info *mp4.Info
+	if mediaType == "video/mp4" {
+		info, err = mp4.ParseFile(filePath)
+		if err != nil {
+			return "", FFProbeOutput{}, &mediaRuleError{Message: "Uploaded file is not a valid MP4", Rule: "container"}
+		}
+	}
+
+	probe, err := probeUpload(filePath)
+	if errors.Is(err, exec.ErrNotFound) && info != nil {
+		// Servers without ffprobe still get the limits the MP4 boxes reveal
+		stat, statErr := os.Stat(filePath)
+		if statErr != nil {
+			return "", FFProbeOutput{}, statErr
+		}
+		probe, err = probeFromMP4(info, stat.Size()), nil
+	}
+	if err != nil {
+		log.Printf("Error probing upload %s: %v", filePath, err)
+		return "", FFProbeOutput{}, &mediaRuleError{Message: "Uploaded file couldn't be read as a video", Rule: "probe"}
+	}
+
+	if err := cfg.mediaRules.check(probe); err != nil {
+		return "", FFProbeOutput{}, err
+	}
+	return mediaType, probe, nil
+}
+
+// check returns the first rule the probed file breaks, or nil.
+func (rules mediaRules) check(probe FFProbeOutput) *mediaRuleError {
+	stream, ok := probe.VideoStream()
+	if !ok {
+		return &mediaRuleError{Message: "Uploaded file has no video stream", Rule: "video_stream"}
+	}
+	if len(rules.videoCodecs) > 0 && !slices.Contains(rules.videoCodecs, stream.CodecName) {
+		return &mediaRuleError{
+			Message: fmt.Sprintf("Video codec %q is not allowed", stream.CodecName),
+			Rule:    "video_codec",
+			Limit:   rules.videoCodecs,
+			Actual:  stream.CodecName,
+		}
+	}
+	for _, audio := range probe.Streams {
+		if audio.CodecType != "audio" || len(rules.audioCodecs) == 0 {
+			continue
+		}
+		if !slices.Contains(rules.audioCodecs, audio.CodecName) {
+			return &mediaRuleError{
+				Message: fmt.Sprintf("Audio codec %q is not allowed", audio.CodecName),
+				Rule:    "audio_codec",
+				Limit:   rules.audioCodecs,
+				Actual:  audio.CodecName,
+			}
+		}
+	}
+
+	duration, _ := strconv.ParseFloat(probe.Format.Duration, 64)
+	if rules.maxDuration > 0 && duration > rules.maxDuration.Seconds() {
+		return &mediaRuleError{
+			Message: fmt.Sprintf("Video is %s long, the limit is %s", time.Duration(duration*float64(time.Second)).Round(time.Second), rules.maxDuration),
+			Rule:    "duration",
+			Limit:   rules.maxDuration.Seconds(),
+			Actual:  duration,
+		}
+	}
+
+	width, height := stream.DisplaySize()
+	if rules.maxWidth > 0 && rules.maxHeight > 0 &&
+		(max(width, height) > max(rules.maxWidth, rules.maxHeight) || min(width, height) > min(rules.maxWidth, rules.maxHeight)) {
+		return &mediaRuleError{
+			Message: fmt.Sprintf("Video is %dx%d, the limit is %dx%d", width, height, rules.maxWidth, rules.maxHeight),
+			Rule:    "resolution",
+			Limit:   fmt.Sprintf("%dx%d", rules.maxWidth, rules.maxHeight),
+			Actual:  fmt.Sprintf("%dx%d", width, height),
+		}
+	}
+
+	bitRate, _ := strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	if bitRate == 0 && duration > 0 {
+		size, _ := strconv.ParseInt(probe.Format.Size, 10, 64)
+		bitRate = int64(float64(size*8) / duration)
+	}
+	if rules.maxBitRate > 0 && bitRate > rules.maxBitRate {
+		return &mediaRuleError{
+			Message: fmt.Sprintf("Video bitrate is %d bit/s, the limit is %d bit/s", bitRate, rules.maxBitRate),
+			Rule:    "bitrate",
+			Limit:   rules.maxBitRate,
+			Actual:  bitRate,
+		}
+	}
+	return nil
+}
+
+// mp4CodecNames maps MP4 sample entry types to ffprobe codec names
+var mp4CodecNames = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"vp09": "vp9",
+	"av01": "av1",
+	"mp4a": "aac",
+	"Opus": "opus",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	".mp3": "mp3",
+}
+
+// probeFromMP4 describes a file the way ffprobe would, from what the MP4
+// parser found in its boxes.
+func probeFromMP4(info *mp4.Info, size int64) FFProbeOutput {
+	output := FFProbeOutput{
+		Format: FFProbeFormat{
+			FormatName: "mov,mp4,m4a,3gp,3g2,mj2",
+			Duration:   strconv.FormatFloat(info.Duration.Seconds(), 'f', 6, 64),
+			Size:       strconv.FormatInt(size, 10),
+			NbStreams:  len(info.Tracks),
+		},
+	}
+	for i, track := range info.Tracks {
+		codec, _, _ := strings.Cut(track.Codec, ".")
+		if name, ok := mp4CodecNames[codec]; ok {
+			codec = name
+		}
+		stream := FFProbeStream{Index: i, CodecName: codec, Width: track.Width, Height: track.Height}
+		switch track.Handler {
+		case "vide":
+			stream.CodecType = "video"
+		case "soun":
+			stream.CodecType = "audio"
+		default:
+			stream.CodecType = "data"
+		}
+		output.Streams = append(output.Streams, stream)
+	}
+	return output
+}
+
+// parseCodecList parses a comma separated list of ffprobe codec names
+func parseCodecList(s string) []string {
+	codecs := []string{}
+	for _, codec := range strings.Split(s, ",") {
+		if codec = strings.TrimSpace(codec); codec != "" {
+			codecs = append(codecs, codec)
+		}
+	}
+	return codecs
+}
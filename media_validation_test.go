@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestSniffVideoType(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"mp4", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00isomiso2avc1mp41"), "video/mp4"},
+		{"quicktime", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00qt  "), "video/quicktime"},
+		{"webm", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"), "video/webm"},
+		{"matroska", []byte("\x1a\x45\xdf\xa3\xa3\x42\x86\x81\x01\x42\x82\x88matroska"), "video/x-matroska"},
+		{"avi", []byte("RIFF\x00\x00\x00\x00AVI LIST"), "video/x-msvideo"},
+		{"html", []byte("<html><body>hi</body></html>"), "text/html"},
+		{"empty", nil, "text/plain"},
+	}
+	for _, tt := range tests {
+		if got := sniffVideoType(tt.header); got != tt.want {
+			t.Errorf("%s: sniffVideoType = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMediaRulesCheck(t *testing.T) {
+	valid := func() FFProbeOutput {
+		return FFProbeOutput{
+			Streams: []FFProbeStream{
+				{Index: 0, CodecName: "h264", CodecType: "video", Width: 1920, Height: 1080},
+				{Index: 1, CodecName: "aac", CodecType: "audio"},
+			},
+			Format: FFProbeFormat{Duration: "60.000000", BitRate: "8000000", Size: "60000000"},
+		}
+	}
+	rules := defaultMediaRules()
+	rules.maxWidth, rules.maxHeight = 3840, 2160
+
+	tests := []struct {
+		name   string
+		modify func(*FFProbeOutput)
+		rule   string
+	}{
+		{"valid", func(o *FFProbeOutput) {}, ""},
+		{"portrait within limits", func(o *FFProbeOutput) { o.Streams[0].Width, o.Streams[0].Height = 2160, 3840 }, ""},
+		{"audio only", func(o *FFProbeOutput) { o.Streams = o.Streams[1:] }, "video_stream"},
+		{"video codec", func(o *FFProbeOutput) { o.Streams[0].CodecName = "mpeg2video" }, "video_codec"},
+		{"audio codec", func(o *FFProbeOutput) { o.Streams[1].CodecName = "dts" }, "audio_codec"},
+		{"duration", func(o *FFProbeOutput) { o.Format.Duration = "14401" }, "duration"},
+		{"resolution", func(o *FFProbeOutput) { o.Streams[0].Width, o.Streams[0].Height = 7680, 4320 }, "resolution"},
+		{"bitrate", func(o *FFProbeOutput) { o.Format.BitRate = "200000000" }, "bitrate"},
+		{"bitrate from size", func(o *FFProbeOutput) { o.Format.BitRate, o.Format.Size = "", "1000000000" }, "bitrate"},
+	}
+	for _, tt := range tests {
+		probe := valid()
+		tt.modify(&probe)
+		err := rules.check(probe)
+		switch {
+		case tt.rule == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.rule != "" && (err == nil || err.Rule != tt.rule):
+			t.Errorf("%s: got %v, want a %s error", tt.name, err, tt.rule)
+		}
+	}
+
+	if err := (mediaRules{}).check(valid()); err != nil {
+		t.Errorf("zero rules should only require a video stream, got %v", err)
+	}
+}
+
+func TestHandlerUploadVideoRejectsNonVideo(t *testing.T) {
+	cfg, store := newTestConfig(t)
+	cfg.uploadsRoot = t.TempDir()
+	cfg.mediaRules = defaultMediaRules()
+
+	userID, token := newTestUser(t, cfg, "a@example.com")
+	video, err := store.CreateVideo(database.CreateVideoParams{Title: "t", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The client claims an MP4, the bytes say otherwise
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreatePart(map[string][]string{
+		"Content-Disposition": {`form-data; name="video"; filename="video.mp4"`},
+		"Content-Type":        {"video/mp4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("<html><body>not a video</body></html>"))
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+video.ID.String(), &body)
+	r.SetPathValue("videoID", video.ID.String())
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerUploadVideo).ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusUnsupportedMediaType, w.Body)
+	}
+	var resp struct {
+		Error  string `json:"error"`
+		Rule   string `json:"rule"`
+		Actual string `json:"actual"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rule != "content_type" || resp.Actual != "text/html" || resp.Error == "" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	video, err = store.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Status != database.VideoStatusFailed {
+		t.Errorf("video status = %s, want %s", video.Status, database.VideoStatusFailed)
+	}
+	job, err := cfg.db.GetLatestProcessingJob(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != uuid.Nil {
+		t.Errorf("rejected upload was queued as job %s", job.ID)
+	}
+	if files, _ := os.ReadDir(cfg.uploadsRoot); len(files) > 0 {
+		t.Errorf("rejected upload left %d files behind", len(files))
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// principal is who a request was authenticated as
+type principal struct {
+	UserID uuid.UUID
+	Role   auth.Role
+	// APIKeyID is set for requests made with an API key, which may only do
+	// what its Scopes allow
+	APIKeyID uuid.UUID
+	Scopes   []string
+}
+
+func (p principal) hasScope(scope string) bool {
+	return p.APIKeyID == uuid.Nil || slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFrom returns the principal the auth middleware stored, or the
+// zero principal for an anonymous request.
+func principalFrom(ctx context.Context) principal {
+	p, _ := ctx.Value(principalKey{}).(principal)
+	return p
+}
+
+// authPolicy is what a route requires of the requests it serves
+type authPolicy struct {
+	// scope lets API keys with it through. Without one only access JWTs
+	// are accepted.
+	scope string
+	// role is the least role the user must have, if any
+	role auth.Role
+	// optional lets anonymous requests through, and requests whose
+	// credentials aren't accepted as if they were anonymous
+	optional bool
+}
+
+// requireAuth serves authenticated requests only; API keys need scope.
+func (cfg *apiConfig) requireAuth(scope string, next http.HandlerFunc) http.Handler {
+	return cfg.withAuth(authPolicy{scope: scope}, next)
+}
+
+// requireSession serves requests with an access JWT only, for routes an
+// API key mustn't reach such as managing the keys themselves.
+func (cfg *apiConfig) requireSession(next http.HandlerFunc) http.Handler {
+	return cfg.withAuth(authPolicy{}, next)
+}
+
+// requireRole serves requests with an access JWT whose role includes role.
+func (cfg *apiConfig) requireRole(role auth.Role, next http.HandlerFunc) http.Handler {
+	return cfg.withAuth(authPolicy{role: role}, next)
+}
+
+// optionalAuth serves anyone, identifying the principal when it can.
+func (cfg *apiConfig) optionalAuth(scope string, next http.HandlerFunc) http.Handler {
+	return cfg.withAuth(authPolicy{scope: scope, optional: true}, next)
+}
+
+// withAuth enforces policy and stores the principal in the request context
+// for the handler to find with principalFrom. Missing or rejected
+// credentials get 401 and a principal that isn't allowed 403.
+func (cfg *apiConfig) withAuth(policy authPolicy, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if policy.optional && r.Header.Get("Authorization") == "" {
+			next(w, r)
+			return
+		}
+
+		p, err := cfg.authenticate(r)
+		if err == nil {
+			err = policy.allows(p)
+		}
+		if err != nil && policy.optional {
+			next(w, r)
+			return
+		}
+		var authErr *authError
+		if errors.As(err, &authErr) {
+			respondWithError(w, authErr.status, authErr.message, err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't authenticate request", err)
+			return
+		}
+		next(w, r.WithContext(withPrincipal(r.Context(), p)))
+	})
+}
+
+func (policy authPolicy) allows(p principal) error {
+	if p.APIKeyID != uuid.Nil && (policy.scope == "" || !p.hasScope(policy.scope)) {
+		if policy.scope == "" {
+			return &authError{http.StatusForbidden, "API keys can't be used here", nil}
+		}
+		return &authError{http.StatusForbidden, "API key lacks the " + policy.scope + " scope", nil}
+	}
+	if policy.role != "" && !p.Role.Includes(policy.role) {
+		return &authError{http.StatusForbidden, "This requires the " + string(policy.role) + " role", nil}
+	}
+	return nil
+}
+
+// authError is a request whose credentials were missing, not accepted, or
+// not enough for the route
+type authError struct {
+	status  int
+	message string
+	err     error
+}
+
+func (e *authError) Error() string {
+	if e.err != nil {
+		return e.message + ": " + e.err.Error()
+	}
+	return e.message
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+func unauthorized(message string, err error) *authError {
+	return &authError{http.StatusUnauthorized, message, err}
+}
+
+// authenticate accepts either a Bearer access JWT or an ApiKey. Either way
+// the user is looked up, so disabling an account or changing its role takes
+// effect at once rather than when its tokens expire.
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error) {
+	var p principal
+	if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+		key, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			return principal{}, unauthorized("Couldn't find API key", err)
+		}
+		apiKey, err := cfg.apiKey(key)
+		if err != nil {
+			return principal{}, err
+		}
+		p = principal{UserID: apiKey.UserID, APIKeyID: apiKey.ID, Scopes: apiKey.Scopes}
+	} else {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			return principal{}, unauthorized("Couldn't find JWT", err)
+		}
+		access, err := auth.ParseAccessToken(token, cfg.jwtKeys)
+		if err != nil {
+			return principal{}, unauthorized("Couldn't validate JWT", err)
+		}
+		p = principal{UserID: access.UserID, Role: access.Role}
+	}
+
+	user, err := cfg.users.GetUser(p.UserID)
+	if err != nil {
+		return principal{}, err
+	}
+	if user == nil {
+		return principal{}, unauthorized("User not found", nil)
+	}
+	if user.DisabledAt != nil {
+		return principal{}, unauthorized("Account is disabled", nil)
+	}
+	// The role a token was issued with may be out of date, so a demotion
+	// takes effect at once
+	p.Role = auth.Role(user.Role)
+	return p, nil
+}
+
+// apiKey returns the live API key a client presented
+func (cfg *apiConfig) apiKey(key string) (database.APIKey, error) {
+	apiKey, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(key))
+	if err != nil {
+		return database.APIKey{}, err
+	}
+	switch {
+	case apiKey.ID == uuid.Nil:
+		return database.APIKey{}, unauthorized("Invalid API key", nil)
+	case apiKey.RevokedAt != nil:
+		return database.APIKey{}, unauthorized("API key was revoked", nil)
+	case apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(time.Now()):
+		return database.APIKey{}, unauthorized("API key has expired", nil)
+	}
+
+	if err := cfg.db.TouchAPIKey(apiKey.ID); err != nil {
+		log.Printf("Couldn't record use of API key %s: %v", apiKey.ID, err)
+	}
+	return apiKey, nil
+}
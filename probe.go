@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// FFProbeOutput mirrors the parts of `ffprobe -show_format -show_streams`
+// JSON output that we keep. ffprobe prints most numbers as strings.
+type FFProbeOutput struct {
+	Streams []FFProbeStream `json:"streams"`
+	Format  FFProbeFormat   `json:"format"`
+}
+
+type FFProbeStream struct {
+	Index         int               `json:"index"`
+	CodecName     string            `json:"codec_name"`
+	CodecType     string            `json:"codec_type"`
+	Profile       string            `json:"profile"`
+	PixFmt        string            `json:"pix_fmt"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	RFrameRate    string            `json:"r_frame_rate"`
+	AvgFrameRate  string            `json:"avg_frame_rate"`
+	SampleRate    string            `json:"sample_rate"`
+	Channels      int               `json:"channels"`
+	ChannelLayout string            `json:"channel_layout"`
+	BitRate       string            `json:"bit_rate"`
+	Duration      string            `json:"duration"`
+	Tags          map[string]string `json:"tags"`
+	SideDataList  []struct {
+		SideDataType string  `json:"side_data_type"`
+		Rotation     float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+type FFProbeFormat struct {
+	FormatName     string `json:"format_name"`
+	FormatLongName string `json:"format_long_name"`
+	Duration       string `json:"duration"`
+	BitRate        string `json:"bit_rate"`
+	Size           string `json:"size"`
+	NbStreams      int    `json:"nb_streams"`
+}
+
+// Rotation returns the display rotation in degrees, normalised to 0-359.
+// Newer ffmpeg reports it as display matrix side data, older as a tag.
+func (s FFProbeStream) Rotation() int {
+	rotation := 0.0
+	for _, sideData := range s.SideDataList {
+		if sideData.SideDataType == "Display Matrix" {
+			rotation = sideData.Rotation
+		}
+	}
+	if rotation == 0 {
+		if tag, ok := s.Tags["rotate"]; ok {
+			rotation, _ = strconv.ParseFloat(tag, 64)
+		}
+	}
+	degrees := int(math.Round(rotation)) % 360
+	if degrees < 0 {
+		degrees += 360
+	}
+	return degrees
+}
+
+// DisplaySize is the size the stream is shown at once rotation is applied
+func (s FFProbeStream) DisplaySize() (int, int) {
+	if r := s.Rotation(); r == 90 || r == 270 {
+		return s.Height, s.Width
+	}
+	return s.Width, s.Height
+}
+
+// VideoStream returns the first video stream
+func (o FFProbeOutput) VideoStream() (FFProbeStream, bool) {
+	for _, stream := range o.Streams {
+		// Cover art is stored as a one frame video stream
+		if stream.CodecType == "video" && stream.Width > 0 && stream.Height > 0 {
+			return stream, true
+		}
+	}
+	return FFProbeStream{}, false
+}
+
+// probeVideo runs ffprobe on a file path or URL
+func probeVideo(filePath string) (FFProbeOutput, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", filePath)
+	var b bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return FFProbeOutput{}, fmt.Errorf("Failed to run ffprobe: %w\n%s", err, stderr.String())
+	}
+
+	var output FFProbeOutput
+	if err := json.Unmarshal(b.Bytes(), &output); err != nil {
+		return FFProbeOutput{}, fmt.Errorf("Failed to unmarshal ffprobe out: %w", err)
+	}
+	return output, nil
+}
+
+// getVideoDimensions returns the display width and height of the first video stream
+func getVideoDimensions(filePath string) (int, int, error) {
+	output, err := probeVideo(filePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	stream, ok := output.VideoStream()
+	if !ok {
+		return 0, 0, fmt.Errorf("Could not determine video dimensions")
+	}
+	width, height := stream.DisplaySize()
+	return width, height, nil
+}
+
+// parseFrameRate turns ffprobe's "30000/1001" style rates into a number
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return math.Round(n/d*1000) / 1000
+}
+
+// newVideoMetadata converts ffprobe output into the record we store
+func newVideoMetadata(output FFProbeOutput) database.VideoMetadata {
+	metadata := database.VideoMetadata{
+		Container: output.Format.FormatName,
+		Streams:   []database.VideoStream{},
+	}
+	metadata.Duration, _ = strconv.ParseFloat(output.Format.Duration, 64)
+	metadata.BitRate, _ = strconv.ParseInt(output.Format.BitRate, 10, 64)
+	metadata.Size, _ = strconv.ParseInt(output.Format.Size, 10, 64)
+	if stream, ok := output.VideoStream(); ok {
+		metadata.Width, metadata.Height = stream.DisplaySize()
+	}
+
+	for _, s := range output.Streams {
+		stream := database.VideoStream{
+			Index:         s.Index,
+			CodecType:     s.CodecType,
+			CodecName:     s.CodecName,
+			Profile:       s.Profile,
+			PixFmt:        s.PixFmt,
+			Width:         s.Width,
+			Height:        s.Height,
+			Channels:      s.Channels,
+			ChannelLayout: s.ChannelLayout,
+			Rotation:      s.Rotation(),
+		}
+		// avg_frame_rate is 0/0 for some containers
+		stream.FrameRate = parseFrameRate(s.AvgFrameRate)
+		if stream.FrameRate == 0 {
+			stream.FrameRate = parseFrameRate(s.RFrameRate)
+		}
+		stream.SampleRate, _ = strconv.Atoi(s.SampleRate)
+		stream.BitRate, _ = strconv.ParseInt(s.BitRate, 10, 64)
+		stream.Duration, _ = strconv.ParseFloat(s.Duration, 64)
+		metadata.Streams = append(metadata.Streams, stream)
+	}
+	return metadata
+}
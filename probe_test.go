@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewVideoMetadata(t *testing.T) {
+	// Trimmed ffprobe output for a portrait phone recording stored rotated
+	raw := `{
+		"streams": [
+			{
+				"index": 0, "codec_name": "h264", "codec_type": "video", "profile": "High",
+				"pix_fmt": "yuv420p", "width": 1920, "height": 1080,
+				"r_frame_rate": "30/1", "avg_frame_rate": "30000/1001", "bit_rate": "8000000",
+				"side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]
+			},
+			{
+				"index": 1, "codec_name": "aac", "codec_type": "audio", "profile": "LC",
+				"sample_rate": "48000", "channels": 2, "channel_layout": "stereo",
+				"r_frame_rate": "0/0", "avg_frame_rate": "0/0", "bit_rate": "128000"
+			}
+		],
+		"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "12.500000", "bit_rate": "8200000", "size": "12812500"}
+	}`
+
+	var output FFProbeOutput
+	if err := json.Unmarshal([]byte(raw), &output); err != nil {
+		t.Fatal(err)
+	}
+	metadata := newVideoMetadata(output)
+
+	if metadata.Duration != 12.5 || metadata.BitRate != 8200000 || metadata.Size != 12812500 {
+		t.Errorf("format fields = %+v", metadata)
+	}
+	if metadata.Width != 1080 || metadata.Height != 1920 {
+		t.Errorf("display size = %dx%d, want 1080x1920", metadata.Width, metadata.Height)
+	}
+	if len(metadata.Streams) != 2 {
+		t.Fatalf("Streams = %+v", metadata.Streams)
+	}
+	video, audio := metadata.Streams[0], metadata.Streams[1]
+	if video.Rotation != 270 || video.FrameRate != 29.97 || video.CodecName != "h264" || video.PixFmt != "yuv420p" {
+		t.Errorf("video stream = %+v", video)
+	}
+	if audio.SampleRate != 48000 || audio.Channels != 2 || audio.FrameRate != 0 {
+		t.Errorf("audio stream = %+v", audio)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
+	"github.com/google/uuid"
+)
+
+// errVideoGone means a job's video was deleted, or taken down, while the
+// job waited or ran. Retrying wouldn't help.
+var errVideoGone = errors.New("video no longer exists")
+
+const (
+	videoProcessingMaxAttempts  = 3
+	videoProcessingPollInterval = 2 * time.Second
+)
+
+// enqueueVideoProcessing moves the video to processing and queues a job for
+// it. The status changes first so a worker that finishes quickly can't be
+// overtaken by it. Errors wrapping database.ErrInvalidVideoTransition mean
+// the video is already being processed.
+func (cfg *apiConfig) enqueueVideoProcessing(videoID uuid.UUID, sourcePath, contentType string) (database.ProcessingJob, error) {
+	if err := cfg.videos.SetVideoStatus(videoID, database.VideoStatusProcessing, ""); err != nil {
+		return database.ProcessingJob{}, err
+	}
+
+	job, err := cfg.db.CreateProcessingJob(database.CreateProcessingJobParams{
+		VideoID:     videoID,
+		SourcePath:  sourcePath,
+		ContentType: contentType,
+		MaxAttempts: videoProcessingMaxAttempts,
+		InstanceID:  cfg.instanceID,
+	})
+	if err != nil {
+		cfg.markVideoFailed(videoID, "couldn't queue video for processing")
+		return database.ProcessingJob{}, err
+	}
+
+	select {
+	case cfg.jobWake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// startVideoWorkers launches n workers that pull jobs from the processing
+// queue until ctx is cancelled.
+func (cfg *apiConfig) startVideoWorkers(ctx context.Context, n int) error {
+	// Anything this instance still has marked running was interrupted by a
+	// restart
+	if err := cfg.db.RequeueRunningProcessingJobs(cfg.instanceID); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		go cfg.videoWorker(ctx)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) videoWorker(ctx context.Context) {
+	ticker := time.NewTicker(videoProcessingPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the queue before going back to sleep
+		for {
+			job, ok, err := cfg.db.ClaimProcessingJob(cfg.instanceID)
+			if err != nil {
+				log.Printf("Couldn't claim processing job: %v", err)
+				break
+			}
+			if !ok {
+				break
+			}
+			cfg.runProcessingJob(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.jobWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) runProcessingJob(ctx context.Context, job database.ProcessingJob) {
+	log.Printf("Processing video %s (job %s, attempt %d/%d)", job.VideoID, job.ID, job.Attempts, job.MaxAttempts)
+
+	err := cfg.processVideo(ctx, job)
+	if err == nil {
+		if err := cfg.db.CompleteProcessingJob(job.ID); err != nil {
+			log.Printf("Couldn't mark job %s as ready: %v", job.ID, err)
+		}
+		if err := cfg.videos.SetVideoStatus(job.VideoID, database.VideoStatusReady, ""); err != nil {
+			log.Printf("Couldn't mark video %s as ready: %v", job.VideoID, err)
+		}
+		os.Remove(job.SourcePath)
+		return
+	}
+
+	log.Printf("Processing video %s failed: %v", job.VideoID, err)
+	if job.Attempts < job.MaxAttempts && !errors.Is(err, errVideoGone) {
+		backoff := time.Duration(job.Attempts*job.Attempts) * 10 * time.Second
+		if err := cfg.db.RetryProcessingJob(job.ID, err.Error(), time.Now().Add(backoff)); err != nil {
+			log.Printf("Couldn't requeue job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	if err := cfg.db.FailProcessingJob(job.ID, err.Error()); err != nil {
+		log.Printf("Couldn't mark job %s as failed: %v", job.ID, err)
+	}
+	cfg.markVideoFailed(job.VideoID, err.Error())
+	os.Remove(job.SourcePath)
+}
+
+// markVideoFailed records why a video's upload or processing failed. It's
+// called on paths that are already reporting an error, so it only logs.
+func (cfg *apiConfig) markVideoFailed(videoID uuid.UUID, reason string) {
+	if err := cfg.videos.SetVideoStatus(videoID, database.VideoStatusFailed, reason); err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", videoID, err)
+	}
+}
+
+// failVideoUpload is markVideoFailed for a failure of the upload uploadID.
+// A video that has moved on from that upload is left alone.
+func (cfg *apiConfig) failVideoUpload(videoID, uploadID uuid.UUID, reason string) {
+	if err := cfg.videos.FailVideoUpload(videoID, uploadID, reason); err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", videoID, err)
+	}
+}
+
+// archiveOriginal stores an upload as it was sent
+func (cfg *apiConfig) archiveOriginal(ctx context.Context, userID uuid.UUID, sourcePath, key, contentType string) error {
+	original, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open original upload: %w", err)
+	}
+	defer original.Close()
+	if err := cfg.putObject(ctx, blobStoreVideos, userID, key, original, contentType); err != nil {
+		return fmt.Errorf("failed to archive original upload: %w", err)
+	}
+	return nil
+}
+
+// videoTrackSize returns the dimensions of the first video track
+func videoTrackSize(info *mp4.Info) (int, int) {
+	for _, track := range info.Tracks {
+		if track.Handler == "vide" {
+			return track.Width, track.Height
+		}
+	}
+	return 0, 0
+}
+
+// processVideo runs the ffmpeg/ffprobe steps on a raw upload, stores the
+// result and points the video record at it. Objects stored by an attempt
+// that fails before the video points at them are queued for deletion, so a
+// retry doesn't leave them behind under keys nothing knows about.
+func (cfg *apiConfig) processVideo(ctx context.Context, job database.ProcessingJob) (err error) {
+	var written []database.CreateBlobDeletionParams
+	defer func() {
+		if err == nil || len(written) == 0 {
+			return
+		}
+		if err := cfg.db.QueueBlobDeletions(written...); err != nil {
+			log.Printf("Couldn't queue objects of failed job %s for deletion: %v", job.ID, err)
+			return
+		}
+		cfg.wakeBlobCleanup()
+	}()
+
+	// Everything stored below is charged to the video's owner
+	owner, err := cfg.videos.GetVideo(job.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if owner.ID == uuid.Nil {
+		return fmt.Errorf("video %s: %w", job.VideoID, errVideoGone)
+	}
+	userID := owner.UserID
+
+	// Anything that isn't already H.264/AAC in an MP4 is transcoded first, so
+	// the steps after this only see web-compatible MP4s. Without ffprobe,
+	// MP4s are taken as they are.
+	sourcePath := job.SourcePath
+	contentType := job.ContentType
+	sourceProbe, sourceProbeErr := probeVideo(job.SourcePath)
+	transcoded := contentType != "video/mp4" || (sourceProbeErr == nil && !isWebCompatible(sourceProbe))
+	if transcoded {
+		sourcePath = job.SourcePath + ".transcoded"
+		defer os.Remove(sourcePath)
+		if err := cfg.transcode.transcodeToMP4(ctx, job.SourcePath, sourcePath); err != nil {
+			return err
+		}
+		contentType = "video/mp4"
+	}
+
+	info, err := mp4.ParseFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("invalid MP4: %w", err)
+	}
+
+	// Only remux when the moov atom isn't already in front of the media data
+	processedVideoPath := sourcePath
+	if !info.IsFastStart() {
+		processedVideoPath, err = processVideoForFastStart(sourcePath)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(processedVideoPath)
+
+		// Check that video is optimized for streaming
+		info, err = mp4.ParseFile(processedVideoPath)
+		if err != nil {
+			return fmt.Errorf("invalid MP4 after remux: %w", err)
+		}
+		if !info.IsFastStart() {
+			return fmt.Errorf("video is still not fast start after remux, box order %v", info.BoxOrder())
+		}
+	}
+
+	processedVideoFile, err := os.Open(processedVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open processed video: %w", err)
+	}
+	defer processedVideoFile.Close()
+
+	// ffprobe knows about rotation, the track header is the fallback when
+	// ffprobe isn't installed
+	probe, probeErr := probeVideo(processedVideoPath)
+	if probeErr != nil {
+		log.Printf("Error probing file %s: %v", processedVideoPath, probeErr)
+	}
+
+	// append aspect ratio orientation to end of string
+	aspectRatio := "other"
+	if stream, ok := probe.VideoStream(); probeErr == nil && ok {
+		aspectRatio = aspectRatioForDimensions(stream.DisplaySize())
+	} else if width, height := videoTrackSize(info); width > 0 && height > 0 {
+		aspectRatio = aspectRatioForDimensions(width, height)
+	}
+
+	ext, err := cfg.getExtensionType(contentType)
+	if err != nil {
+		log.Print(err)
+	}
+
+	// load 32 random bytes used for the key name
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return fmt.Errorf("error generating random bytes: %w", err)
+	}
+	name := hex.EncodeToString(randomBytes)
+	key := objectKeyPrefix(owner) + createDirectoryBucketPrefix(getAspectRatioOrientation(aspectRatio)) + name + ext
+
+	err = cfg.putObject(ctx, blobStoreVideos, userID, key, processedVideoFile, contentType)
+	if err != nil {
+		return fmt.Errorf("failed to upload video: %w", err)
+	}
+	written = append(written, database.CreateBlobDeletionParams{Store: blobStoreVideos, Key: key})
+
+	// An MP4 that was only remuxed is its own original
+	var originalKey *string
+	if transcoded && cfg.transcode.archiveOriginals {
+		archiveKey := objectKeyPrefix(owner) + originalKeyPrefix + name + videoExtensions[job.ContentType]
+		if err := cfg.archiveOriginal(ctx, userID, job.SourcePath, archiveKey, job.ContentType); err != nil {
+			return err
+		}
+		written = append(written, database.CreateBlobDeletionParams{Store: blobStoreVideos, Key: archiveKey})
+		originalKey = &archiveKey
+	}
+
+	var hlsKey *string
+	if cfg.hls.enabled() {
+		hlsDir, err := os.MkdirTemp("", "tubely-hls-*")
+		if err != nil {
+			return fmt.Errorf("failed to create HLS directory: %w", err)
+		}
+		defer os.RemoveAll(hlsDir)
+
+		if err := cfg.hls.packageVideoForHLS(processedVideoPath, hlsDir); err != nil {
+			return err
+		}
+		// The HLS tree sits next to the MP4, e.g. landscape/<key>/master.m3u8.
+		// Part of it may be stored even if the upload fails.
+		hlsPrefix := strings.TrimSuffix(key, path.Ext(key))
+		written = append(written, database.CreateBlobDeletionParams{Store: blobStoreVideos, Key: hlsPrefix + "/"})
+		masterKey, err := cfg.uploadHLSTree(ctx, userID, hlsDir, hlsPrefix)
+		if err != nil {
+			return err
+		}
+		hlsKey = &masterKey
+	}
+
+	// Reload the video so edits made while the job ran aren't overwritten
+	video, err := cfg.videos.GetVideo(job.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return fmt.Errorf("video %s: %w", job.VideoID, errVideoGone)
+	}
+
+	video, err = cfg.replaceVideoFiles(video, key, hlsKey, originalKey)
+	if err != nil {
+		return err
+	}
+	// The video points at everything stored above from here on
+	written = nil
+
+	if probeErr == nil {
+		metadata := newVideoMetadata(probe)
+		metadata.VideoID = video.ID
+		if err := cfg.db.UpsertVideoMetadata(metadata); err != nil {
+			return fmt.Errorf("couldn't save video metadata: %w", err)
+		}
+	}
+
+	// A missing thumbnail shouldn't fail an otherwise playable video
+	if cfg.thumbnails.mode != thumbnailModeOff && video.ThumbnailKey == nil {
+		if err := cfg.generateThumbnails(ctx, video.ID, processedVideoPath, info.Duration); err != nil {
+			log.Printf("Couldn't generate thumbnails for video %s: %v", video.ID, err)
+		}
+	}
+	return nil
+}
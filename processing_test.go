@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestProcessingQueueRetries(t *testing.T) {
+	ctx := context.Background()
+	cfg, _ := newTestConfig(t)
+	cfg.videos, cfg.users = cfg.db, cfg.db
+	cfg.instanceID = "test"
+	cfg.jobWake = make(chan struct{}, 1)
+
+	// Packaging for HLS fails after the MP4 has been stored, whether ffmpeg
+	// is installed or not, since the test MP4 has no media in it
+	renditions, err := parseHLSRenditions("720p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.hls.renditions = renditions
+
+	userID, _ := newTestUser(t, cfg, "a@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "t", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourcePath := filepath.Join(t.TempDir(), "upload.mp4")
+	if err := os.WriteFile(sourcePath, newTestMP4(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := cfg.enqueueVideoProcessing(video.ID, sourcePath, "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued.Status != database.ProcessingStatusQueued || queued.MaxAttempts != videoProcessingMaxAttempts {
+		t.Fatalf("queued %+v", queued)
+	}
+	if got, _ := cfg.db.GetVideo(video.ID); got.Status != database.VideoStatusProcessing {
+		t.Errorf("video is %s once queued, want processing", got.Status)
+	}
+	select {
+	case <-cfg.jobWake:
+	default:
+		t.Error("queueing a job didn't wake a worker")
+	}
+
+	job, ok, err := cfg.db.ClaimProcessingJob(cfg.instanceID)
+	if err != nil || !ok || job.ID != queued.ID || job.Status != database.ProcessingStatusRunning || job.Attempts != 1 {
+		t.Fatalf("ClaimProcessingJob = %+v, %t, %v", job, ok, err)
+	}
+	if _, ok, _ := cfg.db.ClaimProcessingJob(cfg.instanceID); ok {
+		t.Fatal("claimed a running job twice")
+	}
+
+	for attempt := 1; attempt <= videoProcessingMaxAttempts; attempt++ {
+		job.Attempts = attempt
+		cfg.runProcessingJob(ctx, job)
+
+		// Whatever the failed attempt stored is queued for deletion
+		if used, _ := cfg.db.GetStoredBytes(userID); used == 0 {
+			t.Fatalf("attempt %d stored nothing, so it didn't fail where the test expects", attempt)
+		}
+		cfg.runBlobCleanup(ctx)
+		if used, _ := cfg.db.GetStoredBytes(userID); used != 0 {
+			t.Errorf("attempt %d left %d bytes behind", attempt, used)
+		}
+		if objects, _ := cfg.videoStore.List(ctx, ""); len(objects) != 0 {
+			t.Errorf("attempt %d left %d objects behind", attempt, len(objects))
+		}
+
+		got, err := cfg.db.GetProcessingJob(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.LastError == nil {
+			t.Errorf("attempt %d recorded no error", attempt)
+		}
+		if attempt == videoProcessingMaxAttempts {
+			if got.Status != database.ProcessingStatusFailed {
+				t.Errorf("job is %s after its last attempt, want failed", got.Status)
+			}
+			break
+		}
+		if got.Status != database.ProcessingStatusQueued || !got.RunAfter.After(time.Now()) {
+			t.Errorf("job is %s to run at %s after attempt %d, want queued with a backoff", got.Status, got.RunAfter, attempt)
+		}
+		if _, ok, _ := cfg.db.ClaimProcessingJob(cfg.instanceID); ok {
+			t.Errorf("claimed a job before its backoff after attempt %d", attempt)
+		}
+	}
+
+	got, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != database.VideoStatusFailed || got.FailureReason == nil {
+		t.Errorf("video is %s (%v) after its job failed, want failed", got.Status, got.FailureReason)
+	}
+	if _, err := os.Stat(sourcePath); !os.IsNotExist(err) {
+		t.Errorf("source of a failed job left behind: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// quotaConfig holds the quotas of one user, or the defaults for users
+// without overrides of their own. 0 means unlimited.
+type quotaConfig struct {
+	maxStorageBytes int64
+	maxVideos       int
+	maxFileSize     int64
+}
+
+// userQuota returns the quotas that apply to a user
+func (cfg *apiConfig) userQuota(userID uuid.UUID) (quotaConfig, error) {
+	overrides, err := cfg.db.GetUserQuota(userID)
+	if err != nil {
+		return quotaConfig{}, err
+	}
+	quota := cfg.quotas
+	if overrides.MaxStorageBytes != nil {
+		quota.maxStorageBytes = *overrides.MaxStorageBytes
+	}
+	if overrides.MaxVideos != nil {
+		quota.maxVideos = *overrides.MaxVideos
+	}
+	if overrides.MaxFileSize != nil {
+		quota.maxFileSize = *overrides.MaxFileSize
+	}
+	return quota, nil
+}
+
+// quotaError says which quota a request would exceed. Like
+// mediaRuleError, it is sent to the client as is.
+type quotaError struct {
+	Message string `json:"error"`
+	Quota   string `json:"quota"`
+	Limit   int64  `json:"limit"`
+	// Used is what the user has used so far, or the size of the upload for
+	// max_file_size
+	Used int64 `json:"used"`
+}
+
+func (e *quotaError) Error() string {
+	return e.Message
+}
+
+func (e *quotaError) status() int {
+	if e.Quota == "videos" {
+		return http.StatusForbidden
+	}
+	return http.StatusRequestEntityTooLarge
+}
+
+func respondWithQuotaError(w http.ResponseWriter, err *quotaError) {
+	log.Printf("Rejected request, %s quota: %s", err.Quota, err.Message)
+	respondWithJSON(w, err.status(), err)
+}
+
+func fileSizeQuotaError(quota quotaConfig, size int64) *quotaError {
+	return &quotaError{
+		Message: fmt.Sprintf("Uploads are limited to %d bytes", quota.maxFileSize),
+		Quota:   "max_file_size",
+		Limit:   quota.maxFileSize,
+		Used:    size,
+	}
+}
+
+// checkUploadQuota returns the quota storing size more bytes for the user
+// would exceed, or nil. Pass 0 when the size isn't known yet to only check
+// that there is room left.
+func (cfg *apiConfig) checkUploadQuota(userID uuid.UUID, quota quotaConfig, size int64) (*quotaError, error) {
+	if quota.maxFileSize > 0 && size > quota.maxFileSize {
+		return fileSizeQuotaError(quota, size), nil
+	}
+	if quota.maxStorageBytes == 0 {
+		return nil, nil
+	}
+	used, err := cfg.db.GetStoredBytes(userID)
+	if err != nil {
+		return nil, err
+	}
+	if used+size > quota.maxStorageBytes || used >= quota.maxStorageBytes {
+		return &quotaError{
+			Message: fmt.Sprintf("Not enough storage left, %d of %d bytes used", used, quota.maxStorageBytes),
+			Quota:   "storage",
+			Limit:   quota.maxStorageBytes,
+			Used:    used,
+		}, nil
+	}
+	return nil, nil
+}
+
+// enforceUploadQuota checks that the user can store size more bytes. It
+// writes the error response itself and returns false if not.
+func (cfg *apiConfig) enforceUploadQuota(w http.ResponseWriter, userID uuid.UUID, quota quotaConfig, size int64) bool {
+	quotaErr, err := cfg.checkUploadQuota(userID, quota, size)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check quota", err)
+		return false
+	}
+	if quotaErr != nil {
+		respondWithQuotaError(w, quotaErr)
+		return false
+	}
+	return true
+}
+
+// checkVideoQuota returns the quota creating another video would exceed
+func (cfg *apiConfig) checkVideoQuota(userID uuid.UUID) (*quotaError, error) {
+	quota, err := cfg.userQuota(userID)
+	if err != nil || quota.maxVideos == 0 {
+		return nil, err
+	}
+	videos, err := cfg.videos.GetVideos(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(videos) >= quota.maxVideos {
+		return &quotaError{
+			Message: fmt.Sprintf("Video limit of %d reached", quota.maxVideos),
+			Quota:   "videos",
+			Limit:   int64(quota.maxVideos),
+			Used:    int64(len(videos)),
+		}, nil
+	}
+	return nil, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// putObject stores an object and charges its size to the user's storage
+func (cfg *apiConfig) putObject(ctx context.Context, storeName string, userID uuid.UUID, key string, body io.Reader, contentType string) error {
+	store, ok := cfg.blobStoreByName(storeName)
+	if !ok {
+		return fmt.Errorf("unknown blob store %q", storeName)
+	}
+	counter := &countingReader{r: body}
+	if err := store.Put(ctx, key, counter, contentType); err != nil {
+		return err
+	}
+	return cfg.db.RecordStoredObject(database.RecordStoredObjectParams{
+		Store:  storeName,
+		Key:    key,
+		UserID: userID,
+		Size:   counter.n,
+	})
+}
+
+// deleteObject deletes an object and stops charging anyone for it
+func (cfg *apiConfig) deleteObject(ctx context.Context, storeName, key string) error {
+	store, ok := cfg.blobStoreByName(storeName)
+	if !ok {
+		return fmt.Errorf("unknown blob store %q", storeName)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		return err
+	}
+	return cfg.db.DeleteStoredObject(storeName, key)
+}
+
+// parseByteSize parses sizes such as "500MB", "10GB" or a plain number of
+// bytes. Units are powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"TB", 1 << 40},
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			s, multiplier = strings.TrimSpace(number), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"512B", 512},
+		{"1KB", 1 << 10},
+		{"500mb", 500 << 20},
+		{"10 GB", 10 << 30},
+		{"2TB", 2 << 40},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "GB", "-1", "1.5GB", "ten"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	cfg, store := newTestConfig(t)
+	cfg.uploadsRoot = t.TempDir()
+	cfg.mediaRules = defaultMediaRules()
+	cfg.quotas = quotaConfig{maxStorageBytes: 1000, maxVideos: 1, maxFileSize: 100}
+
+	// Overrides are stored on the user's row
+	cfg.users = cfg.db
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "quota@example.com", Password: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := user.ID
+	token, err := auth.MakeJWT(userID, auth.RoleUser, cfg.jwtKeys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createVideo := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/videos", strings.NewReader(`{"title":"t"}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoMetaCreate).ServeHTTP(w, r)
+		return w
+	}
+	if w := createVideo(); w.Code != http.StatusCreated {
+		t.Fatalf("creating the first video: got status %d: %s", w.Code, w.Body)
+	}
+	if w := createVideo(); w.Code != http.StatusForbidden {
+		t.Errorf("creating a video over the limit: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	videos, err := store.GetVideos(userID)
+	if err != nil || len(videos) != 1 {
+		t.Fatalf("GetVideos = %d videos, %v", len(videos), err)
+	}
+	video := videos[0]
+
+	// Over the file size quota, however small the body is otherwise
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("video", "video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(bytes.Repeat([]byte{0}, 500))
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+video.ID.String(), &body)
+	r.SetPathValue("videoID", video.ID.String())
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerUploadVideo).ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("uploading over the file size quota: got status %d, want %d: %s", w.Code, http.StatusRequestEntityTooLarge, w.Body)
+	}
+	var quotaResp quotaError
+	if err := json.NewDecoder(w.Body).Decode(&quotaResp); err != nil {
+		t.Fatal(err)
+	}
+	if quotaResp.Quota != "max_file_size" || quotaResp.Limit != 100 || quotaResp.Used != 500 {
+		t.Errorf("response = %+v", quotaResp)
+	}
+
+	// A per-user override lifts the video limit
+	videoLimit := 5
+	if err := cfg.db.SetUserQuota(userID, database.UserQuota{MaxVideos: &videoLimit}); err != nil {
+		t.Fatal(err)
+	}
+	if w := createVideo(); w.Code != http.StatusCreated {
+		t.Errorf("creating a video with an override: got status %d: %s", w.Code, w.Body)
+	}
+
+	if err := cfg.putObject(context.Background(), blobStoreVideos, userID, "landscape/a.mp4", strings.NewReader(strings.Repeat("x", 300)), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/api/users/me/usage", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	cfg.requireSession(cfg.handlerUsageGet).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("getting usage: got status %d: %s", w.Code, w.Body)
+	}
+	var usage usageResponse
+	if err := json.NewDecoder(w.Body).Decode(&usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.Storage.Used != 300 || usage.Storage.Limit == nil || *usage.Storage.Limit != 1000 {
+		t.Errorf("storage usage = %+v", usage.Storage)
+	}
+	if usage.Videos.Used != 2 || usage.Videos.Limit == nil || *usage.Videos.Limit != 5 {
+		t.Errorf("video usage = %+v", usage.Videos)
+	}
+	if usage.MaxFileSize == nil || *usage.MaxFileSize != 100 {
+		t.Errorf("max file size = %v", usage.MaxFileSize)
+	}
+
+	if err := cfg.deleteObject(context.Background(), blobStoreVideos, "landscape/a.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	used, err := cfg.db.GetStoredBytes(userID)
+	if err != nil || used != 0 {
+		t.Errorf("GetStoredBytes after delete = %d, %v, want 0", used, err)
+	}
+}
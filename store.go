@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/cloudfront/sign"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+const (
+	storageBackendS3     = "s3"
+	storageBackendLocal  = "local"
+	storageBackendMemory = "memory"
+)
+
+// newBlobStore builds the storage backend named by the VIDEO_STORAGE or
+// THUMBNAIL_STORAGE environment variables.
+func (cfg *apiConfig) newBlobStore(backend string) (storage.BlobStore, error) {
+	switch backend {
+	case storageBackendS3:
+		awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(cfg.s3Region))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load SDK config: %w", err)
+		}
+		store := storage.NewS3Store(s3.NewFromConfig(awsCfg), cfg.s3Bucket, cfg.s3CfDistribution)
+		if cfg.cfKeyPairID != "" {
+			store.UseCloudFrontSigning(cfg.cfKeyPairID, cfg.cfPrivateKey)
+		}
+		return store, nil
+	case storageBackendLocal:
+		store, err := storage.NewLocalStore(cfg.assetsRoot, cfg.getAssetURL(""))
+		if err != nil {
+			return nil, err
+		}
+		store.UseURLSigning(cfg.urlSigner)
+		return store, nil
+	case storageBackendMemory:
+		return cfg.memoryStore, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// loadCloudFrontPrivateKey reads the PEM private key of a CloudFront key
+// pair, in either the PKCS #1 form CloudFront generates or PKCS #8.
+func loadCloudFrontPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := sign.LoadPEMPrivKey(bytes.NewReader(data)); err == nil {
+		return key, nil
+	}
+	return sign.LoadPEMPrivKeyPKCS8AsSigner(bytes.NewReader(data))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"log"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+)
+
+// thumbnailWidths are the renditions every thumbnail is stored at, largest
+// first. Images narrower than a width are stored at their own width
+// instead, never enlarged.
+var thumbnailWidths = []int{1280, 640, 320}
+
+const (
+	thumbnailJPEGQuality = 85
+	thumbnailWebPQuality = 80
+)
+
+type thumbnailFormat struct {
+	contentType string
+	ext         string
+	encode      func(buf *bytes.Buffer, img image.Image) error
+}
+
+var thumbnailFormats = []thumbnailFormat{
+	{"image/jpeg", "jpg", func(buf *bytes.Buffer, img image.Image) error {
+		return imaging.EncodeJPEG(buf, img, thumbnailJPEGQuality)
+	}},
+	{"image/webp", "webp", func(buf *bytes.Buffer, img image.Image) error {
+		return imaging.EncodeWebP(buf, img, thumbnailWebPQuality)
+	}},
+}
+
+// storeThumbnailImage decodes an image, uploaded or extracted from a video,
+// and stores JPEG and WebP renditions of it at each thumbnail width. It
+// returns the key of the largest JPEG, which is what thumbnail_url points
+// at, along with every variant. The objects are charged to the video's
+// owner and stored where its visibility requires.
+func (cfg *apiConfig) storeThumbnailImage(ctx context.Context, video database.Video, data []byte) (string, database.ThumbnailVariants, error) {
+	img, _, err := imaging.Decode(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", nil, fmt.Errorf("error generating random bytes: %w", err)
+	}
+	base := objectKeyPrefix(video) + base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	var variants database.ThumbnailVariants
+	for _, width := range thumbnailVariantWidths(img.Bounds().Dx()) {
+		resized := imaging.Resize(img, width)
+		for _, format := range thumbnailFormats {
+			var buf bytes.Buffer
+			if err := format.encode(&buf, resized); err != nil {
+				cfg.deleteThumbnailVariants(ctx, variants)
+				return "", nil, err
+			}
+			key := fmt.Sprintf("%s-%d.%s", base, width, format.ext)
+			if err := cfg.putObject(ctx, blobStoreThumbnails, video.UserID, key, &buf, format.contentType); err != nil {
+				cfg.deleteThumbnailVariants(ctx, variants)
+				return "", nil, err
+			}
+			variants = append(variants, database.ThumbnailVariant{
+				Key:         key,
+				Width:       resized.Bounds().Dx(),
+				Height:      resized.Bounds().Dy(),
+				ContentType: format.contentType,
+			})
+		}
+	}
+	return variants[0].Key, variants, nil
+}
+
+// deleteThumbnailVariants removes the variants stored before a later one
+// failed. Anything left behind is found by the orphan collector.
+func (cfg *apiConfig) deleteThumbnailVariants(ctx context.Context, variants database.ThumbnailVariants) {
+	for _, key := range variants.Keys() {
+		if err := cfg.deleteObject(ctx, blobStoreThumbnails, key); err != nil {
+			log.Printf("Couldn't delete thumbnail %s: %v", key, err)
+		}
+	}
+}
+
+// thumbnailVariantWidths returns the widths to render an image of the given
+// width at, replacing those wider than the image with the image's own.
+func thumbnailVariantWidths(width int) []int {
+	widths := []int{}
+	for _, w := range thumbnailWidths {
+		w = min(w, width)
+		if len(widths) == 0 || widths[len(widths)-1] != w {
+			widths = append(widths, w)
+		}
+	}
+	return widths
+}
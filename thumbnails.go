@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	thumbnailModeOff       = "off"
+	thumbnailModeTimestamp = "timestamp"
+	thumbnailModeScene     = "scene"
+
+	// Frames whose scene change score is above this are scene cuts
+	thumbnailSceneThreshold = 0.3
+	thumbnailMaxWidth       = 1280
+)
+
+type thumbnailConfig struct {
+	mode       string
+	timestamp  time.Duration
+	candidates int
+}
+
+type extractedFrame struct {
+	path      string
+	timestamp float64
+}
+
+// generateThumbnails extracts candidate frames from a processed video,
+// stores them next to manually uploaded thumbnails, and uses the first one
+// as the video's thumbnail if it doesn't have one yet.
+func (cfg *apiConfig) generateThumbnails(ctx context.Context, videoID uuid.UUID, filePath string, duration time.Duration) error {
+	dir, err := os.MkdirTemp("", "tubely-thumbnails-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var frames []extractedFrame
+	if cfg.thumbnails.mode == thumbnailModeScene {
+		frames, err = extractSceneFrames(filePath, dir, cfg.thumbnails.candidates)
+		if err != nil {
+			return err
+		}
+	}
+	// Videos without scene cuts fall back to a single frame
+	if len(frames) == 0 {
+		timestamp := cfg.thumbnails.timestamp
+		if duration > 0 && timestamp >= duration {
+			timestamp = duration / 2
+		}
+		frame, err := extractThumbnailFrame(filePath, dir, timestamp)
+		if err != nil {
+			return err
+		}
+		frames = append(frames, frame)
+	}
+
+	// Replace candidates from an earlier upload of this video, keeping the
+	// object of the one that is still the thumbnail
+	current, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	previous, err := cfg.db.GetThumbnailCandidates(videoID)
+	if err != nil {
+		return err
+	}
+	var orphans []database.CreateBlobDeletionParams
+	for _, candidate := range previous {
+		if current.ThumbnailKey == nil || candidate.Key != *current.ThumbnailKey {
+			orphans = append(orphans, cfg.thumbnailObjects(&candidate.Key, candidate.Variants)...)
+		}
+	}
+	if err := cfg.db.DeleteThumbnailCandidates(videoID, orphans...); err != nil {
+		return err
+	}
+
+	var first database.ThumbnailCandidate
+	for _, frame := range frames {
+		data, err := os.ReadFile(frame.path)
+		if err != nil {
+			return err
+		}
+		key, variants, err := cfg.storeThumbnailImage(ctx, current, data)
+		if err != nil {
+			return err
+		}
+		candidate, err := cfg.db.CreateThumbnailCandidate(database.CreateThumbnailCandidateParams{
+			VideoID:   videoID,
+			Key:       key,
+			Variants:  variants,
+			Timestamp: frame.timestamp,
+		})
+		if err != nil {
+			return err
+		}
+		if first.ID == uuid.Nil {
+			first = candidate
+		}
+	}
+
+	// Reload so a thumbnail uploaded in the meantime wins
+	video, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.ThumbnailKey != nil {
+		return nil
+	}
+	video.ThumbnailKey = &first.Key
+	video.ThumbnailVariants = first.Variants
+	return cfg.videos.UpdateVideo(video)
+}
+
+func thumbnailScaleFilter() string {
+	return fmt.Sprintf("scale='min(%d,iw)':-2", thumbnailMaxWidth)
+}
+
+// extractThumbnailFrame is the frame grab generateThumbnails falls back to.
+// Tests replace it, since ffmpeg may not be installed.
+var extractThumbnailFrame = extractFrameAt
+
+// extractFrameAt grabs a single frame at the given offset into the video
+func extractFrameAt(filePath, dir string, timestamp time.Duration) (extractedFrame, error) {
+	output := filepath.Join(dir, "frame.jpg")
+	cmd := exec.Command("ffmpeg",
+		"-ss", strconv.FormatFloat(timestamp.Seconds(), 'f', 3, 64),
+		"-i", filePath,
+		"-frames:v", "1",
+		"-vf", thumbnailScaleFilter(),
+		"-q:v", "3",
+		output,
+	)
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = &b
+	if err := cmd.Run(); err != nil {
+		return extractedFrame{}, fmt.Errorf("Error extracting thumbnail frame: %w\n%s", err, b.String())
+	}
+	if _, err := os.Stat(output); err != nil {
+		return extractedFrame{}, fmt.Errorf("ffmpeg produced no thumbnail frame: %w", err)
+	}
+	return extractedFrame{path: output, timestamp: timestamp.Seconds()}, nil
+}
+
+var showinfoPTSTime = regexp.MustCompile(`pts_time:\s*([0-9.]+)`)
+
+// extractSceneFrames keeps up to n frames that start a new scene, which
+// tend to be more representative than a fixed offset.
+func extractSceneFrames(filePath, dir string, n int) ([]extractedFrame, error) {
+	pattern := filepath.Join(dir, "scene_%03d.jpg")
+	cmd := exec.Command("ffmpeg",
+		"-i", filePath,
+		"-vf", fmt.Sprintf("select='gt(scene,%g)',showinfo,%s", thumbnailSceneThreshold, thumbnailScaleFilter()),
+		"-frames:v", strconv.Itoa(n),
+		"-vsync", "vfr",
+		"-q:v", "3",
+		pattern,
+	)
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = &b
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Error extracting scene frames: %w\n%s", err, b.String())
+	}
+
+	// showinfo logs one line per selected frame, in output order
+	matches := showinfoPTSTime.FindAllStringSubmatch(b.String(), -1)
+	paths, err := filepath.Glob(filepath.Join(dir, "scene_*.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	frames := make([]extractedFrame, 0, len(paths))
+	for i, path := range paths {
+		frame := extractedFrame{path: path}
+		if i < len(matches) {
+			frame.timestamp, _ = strconv.ParseFloat(matches[i][1], 64)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
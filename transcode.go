@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// Archived originals of transcoded uploads live here in the video store
+const originalKeyPrefix = "originals/"
+
+// videoExtensions names the file extension of every accepted container,
+// since the mime package knows none of them out of the box
+var videoExtensions = map[string]string{
+	"video/mp4":        ".mp4",
+	"video/quicktime":  ".mov",
+	"video/webm":       ".webm",
+	"video/x-matroska": ".mkv",
+	"video/x-msvideo":  ".avi",
+}
+
+// x264Presets are the presets libx264 accepts, fastest first
+var x264Presets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+
+// transcodeConfig controls how uploads that aren't already H.264/AAC MP4s
+// are converted. Only software encoders are used, so any ffmpeg build will
+// do and output doesn't depend on the machine's GPU.
+type transcodeConfig struct {
+	crf    int
+	preset string
+	// archiveOriginals keeps the upload as sent next to the transcoded MP4
+	archiveOriginals bool
+}
+
+// isWebCompatible reports whether every browser can play the probed file as
+// it is: H.264 in 8-bit 4:2:0 with AAC audio, if any.
+func isWebCompatible(probe FFProbeOutput) bool {
+	stream, ok := probe.VideoStream()
+	if !ok || stream.CodecName != "h264" {
+		return false
+	}
+	if stream.PixFmt != "" && stream.PixFmt != "yuv420p" && stream.PixFmt != "yuvj420p" {
+		return false
+	}
+	for _, s := range probe.Streams {
+		if s.CodecType == "audio" && s.CodecName != "aac" {
+			return false
+		}
+	}
+	return true
+}
+
+// transcodeToMP4 converts the file at inputPath to a fast start H.264/AAC
+// MP4 at outputPath.
+func (t transcodeConfig) transcodeToMP4(ctx context.Context, inputPath, outputPath string) error {
+	// ffmpeg rotates the frames upright as it decodes them and drops the
+	// rotation metadata, so the video plays the same way it was recorded
+	// even in players that ignore that metadata. libx264 needs even
+	// dimensions for 4:2:0.
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", inputPath,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-c:v", "libx264", "-preset", t.preset, "-crf", strconv.Itoa(t.crf), "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "160k",
+		"-movflags", "+faststart",
+		"-f", "mp4", outputPath,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error transcoding video: %w\n%s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestIsWebCompatible(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams []FFProbeStream
+		want    bool
+	}{
+		{"h264 aac", []FFProbeStream{
+			{CodecType: "video", CodecName: "h264", PixFmt: "yuv420p", Width: 1920, Height: 1080},
+			{CodecType: "audio", CodecName: "aac"},
+		}, true},
+		{"h264 without audio", []FFProbeStream{
+			{CodecType: "video", CodecName: "h264", PixFmt: "yuvj420p", Width: 1920, Height: 1080},
+		}, true},
+		{"hevc from a phone", []FFProbeStream{
+			{CodecType: "video", CodecName: "hevc", PixFmt: "yuv420p10le", Width: 3840, Height: 2160},
+			{CodecType: "audio", CodecName: "aac"},
+		}, false},
+		{"10-bit h264", []FFProbeStream{
+			{CodecType: "video", CodecName: "h264", PixFmt: "yuv420p10le", Width: 1920, Height: 1080},
+		}, false},
+		{"opus audio", []FFProbeStream{
+			{CodecType: "video", CodecName: "h264", PixFmt: "yuv420p", Width: 1280, Height: 720},
+			{CodecType: "audio", CodecName: "opus"},
+		}, false},
+		{"no video", []FFProbeStream{{CodecType: "audio", CodecName: "aac"}}, false},
+	}
+	for _, tt := range tests {
+		if got := isWebCompatible(FFProbeOutput{Streams: tt.streams}); got != tt.want {
+			t.Errorf("%s: isWebCompatible = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
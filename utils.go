@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"math"
-	"os"
 	"os/exec"
-	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
 )
 
 
@@ -59,8 +59,17 @@ func getAspectRatioOrientation(aspectRatio string ) (orientation string) {
 func processVideoForFastStart(filePath string) (string, error) {
 	outputFilePath := filePath + ".processing"
 
-	// Create the ffmpeg command
-	cmd := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputFilePath)
+	// Relocating moov natively covers ordinary MP4s without needing ffmpeg
+	err := mp4.FastStartFile(filePath, outputFilePath)
+	if err == nil {
+		return outputFilePath, nil
+	}
+	if _, lookErr := exec.LookPath("ffmpeg"); lookErr != nil {
+		return "", fmt.Errorf("Error creating fast start video: %w", err)
+	}
+
+	// Fall back to ffmpeg for layouts the native rewriter doesn't handle
+	cmd := exec.Command("ffmpeg", "-y", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputFilePath)
 
 
 	// Capture both stdout and stderr
@@ -76,22 +85,3 @@ func processVideoForFastStart(filePath string) (string, error) {
 
 	return outputFilePath, nil
 }
-
-func checkFileContainsString(filePath string, searchString string) (bool, error) {
-	// Open file
-	file, err := os.Open(filePath)
-	if err != nil {
-		return false, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	// Read file content
-	data := make([]byte, 1024*1024) // Read first 1MB (adjust size if needed)
-	n, err := file.Read(data)
-	if err != nil && err.Error() != "EOF" {
-		return false, fmt.Errorf("failed to read file: %w", err)
-	}
-
-	// Convert to string and check for "moov"
-	return strings.Contains(string(data[:n]), searchString), nil
-}
\ No newline at end of file
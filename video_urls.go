@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// videoResponse is a video as clients see it, with URLs in place of the
+// object keys stored in the database.
+type videoResponse struct {
+	database.Video
+	ThumbnailURL *string `json:"thumbnail_url"`
+	// ThumbnailSrcset maps a content type to a srcset of the thumbnail's
+	// variants in it, e.g. {"image/webp": "https://... 1280w, ..."}
+	ThumbnailSrcset map[string]string `json:"thumbnail_srcset"`
+	VideoURL        *string           `json:"video_url"`
+	HLSURL          *string           `json:"hls_url"`
+	// URLsExpireAt is set for private videos, whose URLs are signed
+	URLsExpireAt *time.Time `json:"urls_expire_at"`
+}
+
+type thumbnailCandidateResponse struct {
+	database.ThumbnailCandidate
+	URL    string            `json:"url"`
+	Srcset map[string]string `json:"srcset"`
+}
+
+// objectKey returns the key of an object a row points at. Rows written
+// before keys were stored hold the URL the store handed out instead; ok is
+// false for URLs that didn't come from the store at all.
+func (cfg *apiConfig) objectKey(storeName string, stored string) (key string, ok bool) {
+	if !strings.Contains(stored, "://") {
+		return stored, true
+	}
+	store, ok := cfg.blobStoreByName(storeName)
+	if !ok {
+		return "", false
+	}
+	return storage.KeyForURL(store, stored)
+}
+
+// objectURL returns the URL clients use to fetch a stored object, signed to
+// expire at expiresAt unless that is zero. Legacy URLs from elsewhere are
+// passed through unchanged.
+func (cfg *apiConfig) objectURL(ctx context.Context, storeName string, stored *string, expiresAt time.Time) (*string, error) {
+	if stored == nil {
+		return nil, nil
+	}
+	key, ok := cfg.objectKey(storeName, *stored)
+	if !ok {
+		return stored, nil
+	}
+	store, _ := cfg.blobStoreByName(storeName)
+	if expiresAt.IsZero() {
+		url := store.URL(key)
+		return &url, nil
+	}
+	url, err := store.Presign(ctx, key, time.Until(expiresAt))
+	if err != nil {
+		return nil, err
+	}
+	return &url, nil
+}
+
+// urlExpiry returns when signed URLs for the video handed out now expire,
+// or the zero time if its URLs are permanent.
+func (cfg *apiConfig) urlExpiry(video database.Video) time.Time {
+	if video.Visibility != database.VideoVisibilityPrivate {
+		return time.Time{}
+	}
+	return time.Now().Add(cfg.signedURLExpiry).UTC().Truncate(time.Second)
+}
+
+func (cfg *apiConfig) videoResponse(ctx context.Context, video database.Video) (videoResponse, error) {
+	resp := videoResponse{Video: video}
+	expiresAt := cfg.urlExpiry(video)
+	if !expiresAt.IsZero() {
+		resp.URLsExpireAt = &expiresAt
+	}
+
+	var err error
+	resp.ThumbnailURL, err = cfg.objectURL(ctx, blobStoreThumbnails, video.ThumbnailKey, expiresAt)
+	if err != nil {
+		return videoResponse{}, err
+	}
+	resp.ThumbnailSrcset, err = cfg.thumbnailSrcset(ctx, video.ThumbnailVariants, expiresAt)
+	if err != nil {
+		return videoResponse{}, err
+	}
+	resp.VideoURL, err = cfg.objectURL(ctx, blobStoreVideos, video.VideoKey, expiresAt)
+	if err != nil {
+		return videoResponse{}, err
+	}
+	// Playlists point at their renditions by relative URL, which a signature
+	// on the master playlist doesn't cover, so private videos play the MP4
+	if expiresAt.IsZero() {
+		resp.HLSURL, err = cfg.objectURL(ctx, blobStoreVideos, video.HLSKey, expiresAt)
+		if err != nil {
+			return videoResponse{}, err
+		}
+	}
+	return resp, nil
+}
+
+// thumbnailSrcset groups a thumbnail's variants by content type into
+// srcset attribute values. Thumbnails stored before variants have none.
+func (cfg *apiConfig) thumbnailSrcset(ctx context.Context, variants database.ThumbnailVariants, expiresAt time.Time) (map[string]string, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	srcset := map[string]string{}
+	for _, variant := range variants {
+		url, err := cfg.objectURL(ctx, blobStoreThumbnails, &variant.Key, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		candidate := fmt.Sprintf("%s %dw", *url, variant.Width)
+		if srcset[variant.ContentType] != "" {
+			candidate = srcset[variant.ContentType] + ", " + candidate
+		}
+		srcset[variant.ContentType] = candidate
+	}
+	return srcset, nil
+}
+
+func (cfg *apiConfig) videoResponses(ctx context.Context, videos []database.Video) ([]videoResponse, error) {
+	resps := make([]videoResponse, 0, len(videos))
+	for _, video := range videos {
+		resp, err := cfg.videoResponse(ctx, video)
+		if err != nil {
+			return nil, err
+		}
+		resps = append(resps, resp)
+	}
+	return resps, nil
+}
+
+func (cfg *apiConfig) thumbnailCandidateResponses(ctx context.Context, video database.Video, candidates []database.ThumbnailCandidate) ([]thumbnailCandidateResponse, error) {
+	expiresAt := cfg.urlExpiry(video)
+	resps := make([]thumbnailCandidateResponse, 0, len(candidates))
+	for _, candidate := range candidates {
+		url, err := cfg.objectURL(ctx, blobStoreThumbnails, &candidate.Key, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		srcset, err := cfg.thumbnailSrcset(ctx, candidate.Variants, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		resps = append(resps, thumbnailCandidateResponse{ThumbnailCandidate: candidate, URL: *url, Srcset: srcset})
+	}
+	return resps, nil
+}
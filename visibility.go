@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// objectKeyPrefix returns the prefix new objects of a video are stored
+// under. Objects of private videos are only served through signed URLs.
+func objectKeyPrefix(video database.Video) string {
+	if video.Visibility == database.VideoVisibilityPrivate {
+		return storage.PrivatePrefix
+	}
+	return ""
+}
+
+// visibilityKey returns the key an object belongs at once its video is, or
+// stops being, private.
+func visibilityKey(key string, private bool) string {
+	key = strings.TrimPrefix(key, storage.PrivatePrefix)
+	if private {
+		return storage.PrivatePrefix + key
+	}
+	return key
+}
+
+// objectMove copies the objects of a video to the keys they belong at for
+// its visibility, keeping track of the copies and of the originals to
+// delete once nothing points at them.
+type objectMove struct {
+	cfg     *apiConfig
+	userID  uuid.UUID
+	private bool
+	// copied maps each original to the key it was copied to
+	copied  map[database.CreateBlobDeletionParams]string
+	orphans []database.CreateBlobDeletionParams
+}
+
+// move copies one object a row points at and returns the key the row
+// should point at instead. Legacy URLs from elsewhere are left alone.
+func (m *objectMove) move(ctx context.Context, storeName, stored string) (string, error) {
+	key, ok := m.cfg.objectKey(storeName, stored)
+	if !ok {
+		return stored, nil
+	}
+	newKey := visibilityKey(key, m.private)
+	if newKey == key {
+		return key, nil
+	}
+	original := database.CreateBlobDeletionParams{Store: storeName, Key: key}
+	if copied, ok := m.copied[original]; ok {
+		return copied, nil
+	}
+	if err := m.copy(ctx, storeName, key, newKey); err != nil {
+		return "", err
+	}
+	m.copied[original] = newKey
+	m.orphans = append(m.orphans, original)
+	return newKey, nil
+}
+
+func (m *objectMove) movePtr(ctx context.Context, storeName string, stored *string) (*string, error) {
+	if stored == nil {
+		return nil, nil
+	}
+	key, err := m.move(ctx, storeName, *stored)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// moveVariants returns a copy of variants pointing at the moved objects
+func (m *objectMove) moveVariants(ctx context.Context, variants database.ThumbnailVariants) (database.ThumbnailVariants, error) {
+	variants = slices.Clone(variants)
+	for i := range variants {
+		key, err := m.move(ctx, blobStoreThumbnails, variants[i].Key)
+		if err != nil {
+			return nil, err
+		}
+		variants[i].Key = key
+	}
+	return variants, nil
+}
+
+// moveHLSTree moves the whole rendition tree in the directory of the
+// master playlist, which the playlists point into by relative URL.
+func (m *objectMove) moveHLSTree(ctx context.Context, stored *string) (*string, error) {
+	if stored == nil {
+		return nil, nil
+	}
+	key, ok := m.cfg.objectKey(blobStoreVideos, *stored)
+	if !ok || path.Dir(key) == "." || visibilityKey(key, m.private) == key {
+		return m.movePtr(ctx, blobStoreVideos, stored)
+	}
+	dir := path.Dir(key) + "/"
+	objects, err := m.cfg.videoStore.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		newKey := visibilityKey(object.Key, m.private)
+		if err := m.copy(ctx, blobStoreVideos, object.Key, newKey); err != nil {
+			return nil, err
+		}
+		m.copied[database.CreateBlobDeletionParams{Store: blobStoreVideos, Key: object.Key}] = newKey
+	}
+	m.orphans = append(m.orphans, database.CreateBlobDeletionParams{Store: blobStoreVideos, Key: dir})
+	newKey := visibilityKey(key, m.private)
+	return &newKey, nil
+}
+
+// copy stores a copy of an object, charged to the video's owner like the
+// original. Objects that are already gone are skipped, the row ends up
+// pointing at nothing either way.
+func (m *objectMove) copy(ctx context.Context, storeName, key, newKey string) error {
+	store, _ := m.cfg.blobStoreByName(storeName)
+	body, info, err := store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return m.cfg.putObject(ctx, storeName, m.userID, newKey, body, info.ContentType)
+}
+
+// undo deletes the copies made so far, after a later one failed. Anything
+// left behind is found by the orphan collector.
+func (m *objectMove) undo(ctx context.Context) {
+	for original, newKey := range m.copied {
+		if err := m.cfg.deleteObject(ctx, original.Store, newKey); err != nil {
+			log.Printf("Couldn't delete copy %s/%s: %v", original.Store, newKey, err)
+		}
+	}
+}
+
+// moveVideoObjects copies every object of a video to the keys that match
+// its visibility, see storage.PrivatePrefix, and points its thumbnail
+// candidates at the copies. It returns the video pointing at the copies,
+// which the caller saves along with the returned originals, so links handed
+// out before the change stop working.
+func (cfg *apiConfig) moveVideoObjects(ctx context.Context, video database.Video) (database.Video, []database.CreateBlobDeletionParams, error) {
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		return database.Video{}, nil, err
+	}
+
+	m := &objectMove{
+		cfg:     cfg,
+		userID:  video.UserID,
+		private: video.Visibility == database.VideoVisibilityPrivate,
+		copied:  map[database.CreateBlobDeletionParams]string{},
+	}
+	video, candidates, err = m.moveAll(ctx, video, candidates)
+	if err != nil {
+		m.undo(ctx)
+		return database.Video{}, nil, err
+	}
+
+	// Once a candidate points at its copies they can't be undone, anything
+	// left over after a failure here is found by the orphan collector
+	for _, candidate := range candidates {
+		if err := cfg.db.UpdateThumbnailCandidateKeys(candidate.ID, candidate.Key, candidate.Variants); err != nil {
+			return database.Video{}, nil, err
+		}
+	}
+	return video, m.orphans, nil
+}
+
+// moveAll copies the objects of a video and its thumbnail candidates,
+// returning them pointed at the copies.
+func (m *objectMove) moveAll(ctx context.Context, video database.Video, candidates []database.ThumbnailCandidate) (database.Video, []database.ThumbnailCandidate, error) {
+	var err error
+	if video.VideoKey, err = m.movePtr(ctx, blobStoreVideos, video.VideoKey); err != nil {
+		return database.Video{}, nil, err
+	}
+	if video.OriginalKey, err = m.movePtr(ctx, blobStoreVideos, video.OriginalKey); err != nil {
+		return database.Video{}, nil, err
+	}
+	if video.HLSKey, err = m.moveHLSTree(ctx, video.HLSKey); err != nil {
+		return database.Video{}, nil, err
+	}
+	if video.ThumbnailKey, err = m.movePtr(ctx, blobStoreThumbnails, video.ThumbnailKey); err != nil {
+		return database.Video{}, nil, err
+	}
+	if video.ThumbnailVariants, err = m.moveVariants(ctx, video.ThumbnailVariants); err != nil {
+		return database.Video{}, nil, err
+	}
+
+	moved := make([]database.ThumbnailCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.Key, err = m.move(ctx, blobStoreThumbnails, candidate.Key); err != nil {
+			return database.Video{}, nil, err
+		}
+		if candidate.Variants, err = m.moveVariants(ctx, candidate.Variants); err != nil {
+			return database.Video{}, nil, err
+		}
+		moved = append(moved, candidate)
+	}
+	return video, moved, nil
+}